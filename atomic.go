@@ -0,0 +1,276 @@
+package dsbbolt
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/daotl/go-datastore"
+	dskey "github.com/daotl/go-datastore/key"
+	"go.etcd.io/bbolt"
+)
+
+// ErrNotCounter is returned by Increment when the value of the key is not an
+// 8 byte counter
+var ErrNotCounter = errors.New("value is not an 8 byte counter")
+
+// readStored returns the decoded value stored under the stored key k in
+// bucket, or ErrNotFound if there is none or it has expired
+func (d *Datastore) readStored(bucket *bbolt.Bucket, k []byte) ([]byte, error) {
+	data := bucket.Get(k)
+	if data == nil {
+		return nil, datastore.ErrNotFound
+	}
+	if _, err := d.liveMeta(data, time.Now()); err != nil {
+		return nil, err
+	}
+	return d.decodeValue(k, data)
+}
+
+// CompareAndSwap puts value under key if its current value is expected, a
+// nil expected value meaning that the key must be absent, and returns
+// whether it did. The comparison and the put happen in the same write
+// transaction, so concurrent calls cannot both succeed on the same value.
+func (d *Datastore) CompareAndSwap(ctx context.Context, key dskey.Key, expected, value []byte) (swapped bool, err error) {
+	if key.KeyType() != d.ktype {
+		return false, ErrKeyTypeNotMatch
+	}
+	if d.bolt().IsReadOnly() {
+		return false, ErrReadOnly
+	}
+	k, err := d.storedKey(key)
+	if err != nil {
+		return false, err
+	}
+	if err := d.checkEntry(k, value); err != nil {
+		return false, err
+	}
+	encoded, err := d.encodeValue(k, value)
+	if err != nil {
+		return false, err
+	}
+	err = d.update(ctx, func(tx *bbolt.Tx) error {
+		bucket, err := d.openBucket(tx)
+		if err != nil {
+			return err
+		}
+		current, err := d.readStored(bucket, k)
+		switch {
+		case err == datastore.ErrNotFound:
+			if expected != nil {
+				return nil
+			}
+		case err != nil:
+			return err
+		case expected == nil || !bytes.Equal(current, expected):
+			return nil
+		}
+		swapped = true
+		return d.putStored(bucket, k, encoded)
+	})
+	if err != nil {
+		return false, err
+	}
+	return swapped, nil
+}
+
+// Increment adds delta to the counter stored under key as a big-endian
+// int64, an absent key counting as 0, and returns the new value of the
+// counter. The read and the write happen in the same write transaction, so
+// concurrent increments are never lost. A value that is not 8 bytes long
+// is left untouched and ErrNotCounter is returned.
+func (d *Datastore) Increment(ctx context.Context, key dskey.Key, delta int64) (int64, error) {
+	if key.KeyType() != d.ktype {
+		return 0, ErrKeyTypeNotMatch
+	}
+	if d.bolt().IsReadOnly() {
+		return 0, ErrReadOnly
+	}
+	k, err := d.storedKey(key)
+	if err != nil {
+		return 0, err
+	}
+	var counter int64
+	err = d.update(ctx, func(tx *bbolt.Tx) error {
+		bucket, err := d.openBucket(tx)
+		if err != nil {
+			return err
+		}
+		current, err := d.readStored(bucket, k)
+		switch {
+		case err == datastore.ErrNotFound:
+			counter = 0
+		case err != nil:
+			return err
+		case len(current) != 8:
+			return ErrNotCounter
+		default:
+			counter = int64(binary.BigEndian.Uint64(current))
+		}
+		counter += delta
+		value := make([]byte, 8)
+		binary.BigEndian.PutUint64(value, uint64(counter))
+		if value, err = d.encodeValue(k, value); err != nil {
+			return err
+		}
+		return d.putStored(bucket, k, value)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return counter, nil
+}
+
+// GetAndDelete deletes key and returns the value it had, or ErrNotFound if
+// it has none. The read and the delete happen in the same write
+// transaction, so of concurrent calls on the same key only one gets the
+// value, e.g. to pop an item off a queue.
+func (d *Datastore) GetAndDelete(ctx context.Context, key dskey.Key) ([]byte, error) {
+	if key.KeyType() != d.ktype {
+		return nil, ErrKeyTypeNotMatch
+	}
+	if d.bolt().IsReadOnly() {
+		return nil, ErrReadOnly
+	}
+	k, err := d.storedKey(key)
+	if err != nil {
+		return nil, err
+	}
+	var value []byte
+	err = d.update(ctx, func(tx *bbolt.Tx) error {
+		bucket, err := d.openBucket(tx)
+		if err != nil {
+			return err
+		}
+		if value, err = d.readStored(bucket, k); err != nil {
+			return err
+		}
+		return d.deleteStored(bucket, k)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// GetOrPut returns the value of key with loaded true if it has one,
+// otherwise it puts value under key and returns it with loaded false, like
+// the LoadOrStore of sync.Map. The read and the put happen in the same
+// write transaction, so of concurrent calls on an absent key only one puts
+// its value and all of them return it.
+func (d *Datastore) GetOrPut(ctx context.Context, key dskey.Key, value []byte) (actual []byte, loaded bool, err error) {
+	if key.KeyType() != d.ktype {
+		return nil, false, ErrKeyTypeNotMatch
+	}
+	if d.bolt().IsReadOnly() {
+		return nil, false, ErrReadOnly
+	}
+	k, err := d.storedKey(key)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := d.checkEntry(k, value); err != nil {
+		return nil, false, err
+	}
+	encoded, err := d.encodeValue(k, value)
+	if err != nil {
+		return nil, false, err
+	}
+	err = d.update(ctx, func(tx *bbolt.Tx) error {
+		bucket, err := d.openBucket(tx)
+		if err != nil {
+			return err
+		}
+		current, err := d.readStored(bucket, k)
+		switch {
+		case err == datastore.ErrNotFound:
+		case err != nil:
+			return err
+		default:
+			actual, loaded = current, true
+			return nil
+		}
+		actual, loaded = value, false
+		return d.putStored(bucket, k, encoded)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return actual, loaded, nil
+}
+
+// PopFirst deletes the first key a Query with prefix would return and
+// returns it along with its value, or ErrNotFound if there is none. The
+// lookup and the delete happen in the same write transaction, so of
+// concurrent pops each gets a different key, e.g. to consume a work queue
+// keyed by timestamp in order. A nil prefix pops from all keys.
+func (d *Datastore) PopFirst(ctx context.Context, prefix dskey.Key) (dskey.Key, []byte, error) {
+	return d.pop(ctx, prefix, false)
+}
+
+// PopLast is PopFirst for the last key a Query with prefix would return
+func (d *Datastore) PopLast(ctx context.Context, prefix dskey.Key) (dskey.Key, []byte, error) {
+	return d.pop(ctx, prefix, true)
+}
+
+func (d *Datastore) pop(ctx context.Context, prefix dskey.Key, last bool) (dskey.Key, []byte, error) {
+	if keyTypeMismatch(prefix, d.ktype) {
+		return nil, nil, ErrKeyTypeNotMatch
+	}
+	if d.bolt().IsReadOnly() {
+		return nil, nil, ErrReadOnly
+	}
+	start, limit, err := d.prefixBounds(prefix)
+	if err != nil {
+		return nil, nil, err
+	}
+	var key dskey.Key
+	var value []byte
+	err = d.update(ctx, func(tx *bbolt.Tx) error {
+		bucket, err := d.openBucket(tx)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		c := bucket.Cursor()
+		next := c.Next
+		var k, v []byte
+		if last {
+			next = c.Prev
+			if limit == nil {
+				k, v = c.Last()
+			} else if k, _ = c.Seek(limit); k == nil {
+				k, v = c.Last()
+			} else {
+				k, v = c.Prev()
+			}
+		} else {
+			k, v = c.Seek(start)
+		}
+		for ; k != nil; k, v = next() {
+			if bytes.Compare(k, start) < 0 || limit != nil && bytes.Compare(k, limit) >= 0 {
+				break
+			}
+			// skip nested buckets and expired values
+			if v == nil {
+				continue
+			}
+			if _, err := d.liveMeta(v, now); err == datastore.ErrNotFound {
+				continue
+			}
+			if value, err = d.decodeValue(k, v); err != nil {
+				return err
+			}
+			k = copyBytes(k)
+			key = dskey.NewBytesKey(d.userKey(k))
+			return d.deleteStored(bucket, k)
+		}
+		return datastore.ErrNotFound
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, value, nil
+}
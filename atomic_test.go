@@ -0,0 +1,193 @@
+package dsbbolt
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/daotl/go-datastore"
+	dskey "github.com/daotl/go-datastore/key"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompareAndSwap(t *testing.T) {
+	ds := newTestDatastore(t)
+	key := dskey.NewBytesKeyFromString("key")
+
+	// a nil expected value matches an absent key only
+	swapped, err := ds.CompareAndSwap(bg, key, nil, []byte("v1"))
+	assert.NoError(t, err)
+	assert.True(t, swapped)
+	swapped, err = ds.CompareAndSwap(bg, key, nil, []byte("v2"))
+	assert.NoError(t, err)
+	assert.False(t, swapped)
+
+	swapped, err = ds.CompareAndSwap(bg, key, []byte("v1"), []byte("v2"))
+	assert.NoError(t, err)
+	assert.True(t, swapped)
+	// v1 is stale
+	swapped, err = ds.CompareAndSwap(bg, key, []byte("v1"), []byte("v3"))
+	assert.NoError(t, err)
+	assert.False(t, swapped)
+	value, err := ds.Get(bg, key)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("v2"), value)
+
+	swapped, err = ds.CompareAndSwap(bg, dskey.NewBytesKeyFromString("absent"), []byte("v1"), []byte("v2"))
+	assert.NoError(t, err)
+	assert.False(t, swapped)
+	_, err = ds.CompareAndSwap(bg, dskey.NewStrKey("key"), nil, nil)
+	assert.Equal(t, ErrKeyTypeNotMatch, err)
+}
+
+func TestIncrement(t *testing.T) {
+	ds := newTestDatastore(t)
+	key := dskey.NewBytesKeyFromString("counter")
+	n, err := ds.Increment(bg, key, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), n)
+	n, err = ds.Increment(bg, key, -7)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(-2), n)
+	value, err := ds.Get(bg, key)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xfe}, value)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_, err := ds.Increment(bg, key, 1)
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+	n, err = ds.Increment(bg, key, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(998), n)
+
+	notCounter := dskey.NewBytesKeyFromString("text")
+	assert.NoError(t, ds.Put(bg, notCounter, []byte("text")))
+	_, err = ds.Increment(bg, notCounter, 1)
+	assert.Equal(t, ErrNotCounter, err)
+	value, err = ds.Get(bg, notCounter)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("text"), value)
+}
+
+func TestGetAndDelete(t *testing.T) {
+	ds := newTestDatastore(t)
+	key := dskey.NewBytesKeyFromString("item")
+	_, err := ds.GetAndDelete(bg, key)
+	assert.Equal(t, datastore.ErrNotFound, err)
+
+	for i := 0; i < 20; i++ {
+		assert.NoError(t, ds.Put(bg, key, []byte("value")))
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		popped := 0
+		for j := 0; j < 5; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				value, err := ds.GetAndDelete(bg, key)
+				if err == datastore.ErrNotFound {
+					return
+				}
+				assert.NoError(t, err)
+				assert.Equal(t, []byte("value"), value)
+				mu.Lock()
+				popped++
+				mu.Unlock()
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, 1, popped)
+		has, err := ds.Has(bg, key)
+		assert.NoError(t, err)
+		assert.False(t, has)
+	}
+}
+
+func TestGetOrPut(t *testing.T) {
+	ds := newTestDatastore(t)
+	for i := 0; i < 20; i++ {
+		key := dskey.NewBytesKeyFromString(fmt.Sprintf("init-%d", i))
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		stored := 0
+		actuals := make([][]byte, 5)
+		for j := range actuals {
+			wg.Add(1)
+			go func(j int) {
+				defer wg.Done()
+				actual, loaded, err := ds.GetOrPut(bg, key, []byte(fmt.Sprintf("value-%d", j)))
+				assert.NoError(t, err)
+				actuals[j] = actual
+				if !loaded {
+					mu.Lock()
+					stored++
+					mu.Unlock()
+				}
+			}(j)
+		}
+		wg.Wait()
+		assert.Equal(t, 1, stored)
+		value, err := ds.Get(bg, key)
+		assert.NoError(t, err)
+		for _, actual := range actuals {
+			assert.Equal(t, value, actual)
+		}
+	}
+}
+
+func TestPop(t *testing.T) {
+	ds := newTestDatastore(t)
+	prefix := dskey.NewBytesKeyFromString("queue/")
+	_, _, err := ds.PopFirst(bg, prefix)
+	assert.Equal(t, datastore.ErrNotFound, err)
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	var keys []dskey.Key
+	for i := 0; i < 5; i++ {
+		ts := start.Add(time.Duration(i) * time.Minute).Format(time.RFC3339)
+		key := dskey.NewBytesKeyFromString("queue/" + ts)
+		keys = append(keys, key)
+		assert.NoError(t, ds.Put(bg, key, []byte(ts)))
+	}
+	// keys around the prefix are never popped
+	assert.NoError(t, ds.Put(bg, dskey.NewBytesKeyFromString("queue"), nil))
+	assert.NoError(t, ds.Put(bg, dskey.NewBytesKeyFromString("queuf"), nil))
+	assert.NoError(t, ds.Put(bg, dskey.NewBytesKeyFromString("a"), nil))
+
+	key, value, err := ds.PopLast(bg, prefix)
+	assert.NoError(t, err)
+	assert.Equal(t, keys[4], key)
+	assert.Equal(t, keys[4].Bytes()[len("queue/"):], value)
+	for _, want := range keys[:4] {
+		key, value, err := ds.PopFirst(bg, prefix)
+		assert.NoError(t, err)
+		assert.Equal(t, want, key)
+		assert.Equal(t, want.Bytes()[len("queue/"):], value)
+	}
+	_, _, err = ds.PopFirst(bg, prefix)
+	assert.Equal(t, datastore.ErrNotFound, err)
+	_, _, err = ds.PopLast(bg, prefix)
+	assert.Equal(t, datastore.ErrNotFound, err)
+
+	has, err := ds.Has(bg, dskey.NewBytesKeyFromString("queuf"))
+	assert.NoError(t, err)
+	assert.True(t, has)
+
+	// a nil prefix pops any key
+	key, _, err = ds.PopFirst(bg, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, dskey.NewBytesKeyFromString("a"), key)
+	key, _, err = ds.PopLast(bg, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, dskey.NewBytesKeyFromString("queuf"), key)
+}
@@ -0,0 +1,80 @@
+package dsbbolt
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+
+	"go.etcd.io/bbolt"
+)
+
+// Backup writes a consistent snapshot of the whole db to w while the
+// datastore stays open for reads and writes, returning the bytes written
+func (d *Datastore) Backup(ctx context.Context, w io.Writer) (int64, error) {
+	if err := orBackground(ctx).Err(); err != nil {
+		return 0, err
+	}
+	var n int64
+	err := d.bolt().View(func(tx *bbolt.Tx) error {
+		var err error
+		n, err = tx.WriteTo(w)
+		return err
+	})
+	return n, err
+}
+
+// BackupToFile writes a consistent snapshot of the whole db to a file at
+// path, which can be opened as a datastore. Unlike CopyTo, an existing file
+// at path is truncated and overwritten, and is left partially written if
+// the backup fails.
+func (d *Datastore) BackupToFile(ctx context.Context, path string) error {
+	if err := orBackground(ctx).Err(); err != nil {
+		return err
+	}
+	return d.bolt().View(func(tx *bbolt.Tx) error {
+		return tx.CopyFile(path, defaultFileMode)
+	})
+}
+
+// CopyTo copies the whole db to a new file at newPath, to be opened as an
+// independent datastore while this one stays in use. Unlike BackupToFile it
+// never overwrites an existing file, returning an error satisfying
+// errors.Is(err, os.ErrExist), and the copy is written to a temporary file
+// synced to disk before being renamed, so that newPath holds either nothing
+// or a complete copy.
+func (d *Datastore) CopyTo(ctx context.Context, newPath string) error {
+	if err := orBackground(ctx).Err(); err != nil {
+		return err
+	}
+	if _, err := os.Lstat(newPath); err == nil {
+		return &os.PathError{Op: "copy", Path: newPath, Err: os.ErrExist}
+	}
+	f, err := os.CreateTemp(filepath.Dir(newPath), filepath.Base(newPath)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	if err := d.bolt().View(func(tx *bbolt.Tx) error {
+		_, err := tx.WriteTo(f)
+		return err
+	}); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	err = f.Chmod(defaultFileMode)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, newPath)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
@@ -0,0 +1,90 @@
+package dsbbolt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	dskey "github.com/daotl/go-datastore/key"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackup(t *testing.T) {
+	ds := newTestDatastore(t)
+	keys := benchmarkKeys(100)
+	for _, k := range keys {
+		assert.NoError(t, ds.Put(bg, k, k.Bytes()))
+	}
+
+	verify := func(t *testing.T, path string) {
+		restored, err := NewDatastore(path, nil, nil, dskey.KeyTypeBytes)
+		assert.NoError(t, err)
+		defer restored.Close()
+		for _, k := range keys {
+			v, err := restored.Get(bg, k)
+			assert.NoError(t, err)
+			assert.Equal(t, k.Bytes(), v)
+		}
+	}
+
+	t.Run("writer", func(t *testing.T) {
+		var buf bytes.Buffer
+		n, err := ds.Backup(bg, &buf)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(buf.Len()), n)
+		path := filepath.Join(t.TempDir(), "backup")
+		assert.NoError(t, os.WriteFile(path, buf.Bytes(), 0600))
+		verify(t, path)
+	})
+	t.Run("file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "backup")
+		assert.NoError(t, ds.BackupToFile(bg, path))
+		verify(t, path)
+	})
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(bg)
+		cancel()
+		var buf bytes.Buffer
+		_, err := ds.Backup(ctx, &buf)
+		assert.Equal(t, context.Canceled, err)
+		assert.Equal(t, 0, buf.Len())
+		path := filepath.Join(t.TempDir(), "backup")
+		assert.Equal(t, context.Canceled, ds.BackupToFile(ctx, path))
+		_, err = os.Stat(path)
+		assert.True(t, os.IsNotExist(err))
+	})
+}
+
+func TestCopyTo(t *testing.T) {
+	ds := newTestDatastore(t)
+	keys := benchmarkKeys(100)
+	for _, k := range keys {
+		assert.NoError(t, ds.Put(bg, k, k.Bytes()))
+	}
+	path := filepath.Join(t.TempDir(), "copy")
+	assert.NoError(t, ds.CopyTo(bg, path))
+	err := ds.CopyTo(bg, path)
+	assert.True(t, errors.Is(err, os.ErrExist), err)
+	matches, err := filepath.Glob(path + ".tmp*")
+	assert.NoError(t, err)
+	assert.Empty(t, matches)
+
+	copied, err := NewDatastore(path, nil, nil, dskey.KeyTypeBytes)
+	assert.NoError(t, err)
+	defer copied.Close()
+	// both datastores can be modified independently
+	assert.NoError(t, ds.Delete(bg, keys[0]))
+	assert.NoError(t, copied.Put(bg, keys[1], []byte("copy")))
+	v, err := copied.Get(bg, keys[0])
+	assert.NoError(t, err)
+	assert.Equal(t, keys[0].Bytes(), v)
+	v, err = ds.Get(bg, keys[1])
+	assert.NoError(t, err)
+	assert.Equal(t, keys[1].Bytes(), v)
+	has, err := ds.Has(bg, keys[0])
+	assert.NoError(t, err)
+	assert.False(t, has)
+}
@@ -0,0 +1,205 @@
+package dsbbolt
+
+import (
+	"bytes"
+	"context"
+	"sort"
+
+	"github.com/daotl/go-datastore"
+	dskey "github.com/daotl/go-datastore/key"
+	"go.etcd.io/bbolt"
+)
+
+var (
+	_ datastore.Batching = (*Datastore)(nil)
+	_ datastore.Batch    = (*WriteBatch)(nil)
+)
+
+// Batch returns a batch that buffers Put and Delete operations in memory
+// and applies all of them in a single bbolt write transaction on Commit
+func (d *Datastore) Batch(ctx context.Context) (datastore.Batch, error) {
+	return &WriteBatch{ds: d}, nil
+}
+
+// BatchWithOptions returns a batch that flushes its buffered operations to
+// the db in one write transaction as soon as it holds operations on maxOps
+// keys or maxBytes bytes of keys and values, a zero threshold is ignored.
+//
+// Each flush is atomic, but a batch that flushed before Commit is not:
+// operations flushed earlier stay persisted if a later flush fails.
+func (d *Datastore) BatchWithOptions(ctx context.Context, maxOps int, maxBytes int) (*WriteBatch, error) {
+	return &WriteBatch{ds: d, maxOps: maxOps, maxBytes: maxBytes}, nil
+}
+
+// BatchStats describes what a WriteBatch has persisted so far
+type BatchStats struct {
+	Flushes   int // number of write transactions committed
+	Committed int // number of operations committed, one per key and flush
+}
+
+type batchOp struct {
+	key    []byte
+	value  []byte
+	delete bool
+}
+
+// WriteBatch implements datastore.Batch, buffered operations are applied
+// in a single bbolt write transaction per flush. Only the last operation
+// buffered on a key is kept, so a key put or deleted several times between
+// two flushes is written once, and operations are applied in key order.
+type WriteBatch struct {
+	ds  *Datastore
+	ops map[string]batchOp // last operation buffered on each key
+
+	maxOps   int
+	maxBytes int
+	size     int // bytes of keys and values in ops
+	stats    BatchStats
+	onFlush  func(committed int)
+}
+
+// Put buffers a put of value under key
+func (b *WriteBatch) Put(ctx context.Context, key dskey.Key, value []byte) error {
+	if key.KeyType() != b.ds.ktype {
+		return ErrKeyTypeNotMatch
+	}
+	k, err := b.ds.storedKey(key)
+	if err != nil {
+		return err
+	}
+	if err := b.ds.checkEntry(k, value); err != nil {
+		return err
+	}
+	if value, err = b.ds.encodeValue(k, value); err != nil {
+		return err
+	}
+	return b.add(ctx, batchOp{key: k, value: value})
+}
+
+// Delete buffers a delete of key
+func (b *WriteBatch) Delete(ctx context.Context, key dskey.Key) error {
+	if key.KeyType() != b.ds.ktype {
+		return ErrKeyTypeNotMatch
+	}
+	k, err := b.ds.storedKey(key)
+	if err != nil {
+		return err
+	}
+	return b.add(ctx, batchOp{key: k, delete: true})
+}
+
+func (b *WriteBatch) add(ctx context.Context, op batchOp) error {
+	if b.ops == nil {
+		b.ops = make(map[string]batchOp)
+	}
+	if prev, ok := b.ops[string(op.key)]; ok {
+		b.size -= len(prev.key) + len(prev.value)
+	}
+	b.ops[string(op.key)] = op
+	b.size += len(op.key) + len(op.value)
+	if b.maxOps > 0 && len(b.ops) >= b.maxOps ||
+		b.maxBytes > 0 && b.size >= b.maxBytes {
+		return b.flush(ctx)
+	}
+	return nil
+}
+
+// Commit applies all buffered operations atomically, if any of them fails
+// none of them is persisted and the batch is left untouched
+func (b *WriteBatch) Commit(ctx context.Context) error {
+	return b.flush(ctx)
+}
+
+// OnFlush sets fn to be called after each write transaction of the batch
+// commits, with the total number of operations committed so far. It can
+// be used to report the progress of large imports.
+func (b *WriteBatch) OnFlush(fn func(committed int)) {
+	b.onFlush = fn
+}
+
+// EstimateGrowth returns the number of bytes the data of the db would grow
+// by if the buffered operations were committed: the size of the keys and
+// values put, less the size of those they overwrite or delete. It is only
+// an approximation of the growth of the db file, which also depends on how
+// full bbolt keeps its pages, on its freelist and on the memory map size
+// the file is grown to, e.g. to check it against the disk space available
+// before Commit. The estimate is negative when the operations free more
+// than they add, although the file never shrinks.
+func (b *WriteBatch) EstimateGrowth() (int64, error) {
+	if len(b.ops) == 0 {
+		return 0, nil
+	}
+	var growth int64
+	err := b.ds.bolt().View(func(tx *bbolt.Tx) error {
+		bucket, err := b.ds.openBucket(tx)
+		if err != nil {
+			return err
+		}
+		for _, op := range b.ops {
+			if !op.delete {
+				growth += int64(len(op.key) + len(op.value))
+				if b.ds.meta {
+					growth += metaHeaderSize
+				}
+			}
+			if current := bucket.Get(op.key); current != nil {
+				growth -= int64(len(op.key) + len(current))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return growth, nil
+}
+
+// Stats returns the number of flushes and operations committed so far
+func (b *WriteBatch) Stats() BatchStats {
+	return b.stats
+}
+
+func (b *WriteBatch) flush(ctx context.Context) error {
+	if len(b.ops) == 0 {
+		return nil
+	}
+	if b.ds.bolt().IsReadOnly() {
+		return ErrReadOnly
+	}
+	// writing in key order fills the pages of the B+tree one after another
+	ops := make([]batchOp, 0, len(b.ops))
+	for _, op := range b.ops {
+		ops = append(ops, op)
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		return bytes.Compare(ops[i].key, ops[j].key) < 0
+	})
+	if err := b.ds.update(ctx, func(tx *bbolt.Tx) error {
+		bucket, err := b.ds.openBucket(tx)
+		if err != nil {
+			return err
+		}
+		for _, op := range ops {
+			var err error
+			if op.delete {
+				err = b.ds.deleteStored(bucket, op.key)
+			} else {
+				err = b.ds.putStored(bucket, op.key, op.value)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	b.stats.Flushes++
+	b.stats.Committed += len(b.ops)
+	b.ops = nil
+	b.size = 0
+	if b.onFlush != nil {
+		b.onFlush(b.stats.Committed)
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package dsbbolt
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/daotl/go-datastore"
+	dskey "github.com/daotl/go-datastore/key"
+	dstest "github.com/daotl/go-datastore/test"
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/bbolt"
+)
+
+func TestBatch(t *testing.T) {
+	ds := newTestDatastore(t)
+	t.Run("put", func(t *testing.T) {
+		dstest.RunBatchTest(t, dskey.KeyTypeBytes, ds)
+	})
+	t.Run("delete", func(t *testing.T) {
+		dstest.RunBatchDeleteTest(t, dskey.KeyTypeBytes, ds)
+	})
+	t.Run("put and delete", func(t *testing.T) {
+		dstest.RunBatchPutAndDeleteTest(t, dskey.KeyTypeBytes, ds)
+	})
+	t.Run("atomic", func(t *testing.T) {
+		b, err := ds.Batch(bg)
+		assert.NoError(t, err)
+		k := dskey.NewBytesKeyFromString("batch-atomic")
+		assert.NoError(t, b.Put(bg, k, []byte("v")))
+		// bbolt rejects empty keys, so the whole commit must be rolled back
+		assert.NoError(t, b.Put(bg, dskey.EmptyBytesKey, []byte("v")))
+		assert.Error(t, b.Commit(bg))
+		_, err = ds.Get(bg, k)
+		assert.Equal(t, datastore.ErrNotFound, err)
+	})
+	t.Run("key type", func(t *testing.T) {
+		b, err := ds.Batch(bg)
+		assert.NoError(t, err)
+		assert.Equal(t, ErrKeyTypeNotMatch, b.Put(bg, dskey.NewStrKey("str"), nil))
+		assert.Equal(t, ErrKeyTypeNotMatch, b.Delete(bg, dskey.NewStrKey("str")))
+	})
+}
+
+func TestBatchDedup(t *testing.T) {
+	ds := newTestDatastore(t)
+	b, err := ds.Batch(bg)
+	assert.NoError(t, err)
+	key := dskey.NewBytesKeyFromString("key")
+	for i := 0; i < 1000; i++ {
+		assert.NoError(t, b.Put(bg, key, []byte(fmt.Sprintf("value-%d", i))))
+	}
+	deleted := dskey.NewBytesKeyFromString("deleted")
+	assert.NoError(t, ds.Put(bg, deleted, []byte("value")))
+	assert.NoError(t, b.Put(bg, deleted, []byte("value2")))
+	assert.NoError(t, b.Delete(bg, deleted))
+	assert.NoError(t, b.Commit(bg))
+
+	// a single operation per key was applied
+	assert.Equal(t, BatchStats{Flushes: 1, Committed: 2}, b.(*WriteBatch).Stats())
+	value, err := ds.Get(bg, key)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("value-999"), value)
+	_, err = ds.Get(bg, deleted)
+	assert.Equal(t, datastore.ErrNotFound, err)
+}
+
+func TestBatchWithOptions(t *testing.T) {
+	t.Run("max ops", func(t *testing.T) {
+		ds := newTestDatastore(t)
+		b, err := ds.BatchWithOptions(bg, 100, 0)
+		assert.NoError(t, err)
+		keys := benchmarkKeys(250)
+		for i, k := range keys {
+			assert.NoError(t, b.Put(bg, k, k.Bytes()))
+			assert.Equal(t, (i+1)/100, b.Stats().Flushes)
+		}
+		// the first 200 operations were flushed without a Commit
+		has, err := ds.Has(bg, keys[199])
+		assert.NoError(t, err)
+		assert.True(t, has)
+		has, err = ds.Has(bg, keys[200])
+		assert.NoError(t, err)
+		assert.False(t, has)
+
+		assert.NoError(t, b.Commit(bg))
+		assert.Equal(t, BatchStats{Flushes: 3, Committed: 250}, b.Stats())
+		for _, k := range keys {
+			v, err := ds.Get(bg, k)
+			assert.NoError(t, err)
+			assert.Equal(t, k.Bytes(), v)
+		}
+	})
+	t.Run("max bytes", func(t *testing.T) {
+		ds := newTestDatastore(t)
+		b, err := ds.BatchWithOptions(bg, 0, 1000)
+		assert.NoError(t, err)
+		value := make([]byte, 100)
+		for _, k := range benchmarkKeys(20) {
+			assert.NoError(t, b.Put(bg, k, value))
+		}
+		// 14 byte keys with 100 byte values cross 1000 bytes every 9 puts
+		assert.Equal(t, BatchStats{Flushes: 2, Committed: 18}, b.Stats())
+		assert.NoError(t, b.Commit(bg))
+		assert.Equal(t, BatchStats{Flushes: 3, Committed: 20}, b.Stats())
+	})
+	t.Run("on flush", func(t *testing.T) {
+		ds := newTestDatastore(t)
+		b, err := ds.BatchWithOptions(bg, 100, 0)
+		assert.NoError(t, err)
+		var progress []int
+		b.OnFlush(func(committed int) {
+			// the flushed operations are persisted when the callback runs
+			has, err := ds.Has(bg, benchmarkKeys(committed)[committed-1])
+			assert.NoError(t, err)
+			assert.True(t, has)
+			progress = append(progress, committed)
+		})
+		for _, k := range benchmarkKeys(1000) {
+			assert.NoError(t, b.Put(bg, k, k.Bytes()))
+		}
+		assert.NoError(t, b.Commit(bg))
+		assert.Equal(t, []int{100, 200, 300, 400, 500, 600, 700, 800, 900, 1000}, progress)
+	})
+}
+
+func benchmarkKeys(n int) []dskey.Key {
+	keys := make([]dskey.Key, n)
+	for i := range keys {
+		keys[i] = dskey.NewBytesKeyFromString(fmt.Sprintf("bench-%08d", i))
+	}
+	return keys
+}
+
+func TestBatchEstimateGrowth(t *testing.T) {
+	ds := newTestDatastore(t)
+	keys := benchmarkKeys(2000)
+	value := bytes.Repeat([]byte{'v'}, 1000)
+	size := func() (n int64) {
+		assert.NoError(t, ds.bolt().View(func(tx *bbolt.Tx) error {
+			n = tx.Size()
+			return nil
+		}))
+		return n
+	}
+
+	b, err := ds.BatchWithOptions(bg, 0, 0)
+	assert.NoError(t, err)
+	growth, err := b.EstimateGrowth()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), growth)
+	for _, k := range keys {
+		assert.NoError(t, b.Put(bg, k, value))
+	}
+	growth, err = b.EstimateGrowth()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(keys)*(len(keys[0].Bytes())+len(value))), growth)
+	before := size()
+	assert.NoError(t, b.Commit(bg))
+	// bbolt leaves room in its pages and copies the pages it modifies
+	actual := size() - before
+	assert.True(t, growth <= actual && actual <= 3*growth, "estimated %d, grew by %d", growth, actual)
+
+	// overwrites and deletes only count what they change
+	b, err = ds.BatchWithOptions(bg, 0, 0)
+	assert.NoError(t, err)
+	assert.NoError(t, b.Put(bg, keys[0], value[:500]))
+	assert.NoError(t, b.Delete(bg, keys[1]))
+	assert.NoError(t, b.Delete(bg, dskey.NewBytesKeyFromString("absent")))
+	growth, err = b.EstimateGrowth()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(-500-len(keys[1].Bytes())-len(value)), growth)
+}
+
+func BenchmarkPut10k(b *testing.B) {
+	keys := benchmarkKeys(10000)
+	val := []byte("benchmark value")
+	for i := 0; i < b.N; i++ {
+		ds, err := NewDatastore(filepath.Join(b.TempDir(), "bolt"), nil, nil, dskey.KeyTypeBytes)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for _, k := range keys {
+			if err := ds.Put(bg, k, val); err != nil {
+				b.Fatal(err)
+			}
+		}
+		ds.Close()
+	}
+}
+
+func BenchmarkBatchPut10k(b *testing.B) {
+	keys := benchmarkKeys(10000)
+	val := []byte("benchmark value")
+	for i := 0; i < b.N; i++ {
+		ds, err := NewDatastore(filepath.Join(b.TempDir(), "bolt"), nil, nil, dskey.KeyTypeBytes)
+		if err != nil {
+			b.Fatal(err)
+		}
+		batch, err := ds.Batch(bg)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for _, k := range keys {
+			if err := batch.Put(bg, k, val); err != nil {
+				b.Fatal(err)
+			}
+		}
+		if err := batch.Commit(bg); err != nil {
+			b.Fatal(err)
+		}
+		ds.Close()
+	}
+}
@@ -0,0 +1,86 @@
+package dsbbolt
+
+import (
+	"hash/fnv"
+	"math"
+	"sync/atomic"
+
+	"go.etcd.io/bbolt"
+)
+
+// defaultBloomFalsePositiveRate is the false positive rate used when
+// Config.BloomFalsePositiveRate is 0
+const defaultBloomFalsePositiveRate = 0.01
+
+// bloomFilter is a Bloom filter of stored keys, safe for concurrent use.
+// It can only tell for sure that a key was never added.
+type bloomFilter struct {
+	bits   []uint64
+	hashes uint64
+}
+
+// newBloomFilter returns a filter sized for n keys with a false positive
+// rate of p
+func newBloomFilter(n int, p float64) *bloomFilter {
+	if n < 1 {
+		n = 1
+	}
+	m := math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2))
+	k := math.Max(1, math.Round(m/float64(n)*math.Ln2))
+	return &bloomFilter{
+		bits:   make([]uint64, (uint64(m)+63)/64),
+		hashes: uint64(k),
+	}
+}
+
+// locations returns the two hashes of key the bit positions are derived from
+func (f *bloomFilter) locations(key []byte) (h1, h2 uint64) {
+	h := fnv.New64a()
+	h.Write(key)
+	sum := h.Sum64()
+	return sum, sum>>32 | sum<<32 | 1
+}
+
+func (f *bloomFilter) add(key []byte) {
+	h1, h2 := f.locations(key)
+	n := uint64(len(f.bits)) * 64
+	for i := uint64(0); i < f.hashes; i++ {
+		bit := (h1 + i*h2) % n
+		word, mask := &f.bits[bit/64], uint64(1)<<(bit%64)
+		for {
+			old := atomic.LoadUint64(word)
+			if old&mask != 0 || atomic.CompareAndSwapUint64(word, old, old|mask) {
+				break
+			}
+		}
+	}
+}
+
+// mayContain returns false if key was never added
+func (f *bloomFilter) mayContain(key []byte) bool {
+	h1, h2 := f.locations(key)
+	n := uint64(len(f.bits)) * 64
+	for i := uint64(0); i < f.hashes; i++ {
+		bit := (h1 + i*h2) % n
+		if atomic.LoadUint64(&f.bits[bit/64])&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// loadBloomFilter adds every key of the datastore bucket to f
+func (d *Datastore) loadBloomFilter(f *bloomFilter) error {
+	return d.bolt().View(func(tx *bbolt.Tx) error {
+		bucket, err := d.openBucket(tx)
+		if err != nil {
+			return err
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			if v != nil {
+				f.add(k)
+			}
+			return nil
+		})
+	})
+}
@@ -0,0 +1,49 @@
+package dsbbolt
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	dskey "github.com/daotl/go-datastore/key"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBloomFilter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bolt")
+	ds, err := NewDatastoreWithOptions(path)
+	assert.NoError(t, err)
+	keys := benchmarkKeys(2000)
+	for _, k := range keys[:1000] {
+		assert.NoError(t, ds.Put(bg, k, k.Bytes()))
+	}
+	assert.NoError(t, ds.Close())
+
+	// the first half of the keys is loaded on open, the second half put after
+	ds, err = NewDatastoreWithOptions(path, WithBloomFilter(2000, 0.01))
+	assert.NoError(t, err)
+	defer ds.Close()
+	for _, k := range keys[1000:] {
+		assert.NoError(t, ds.Put(bg, k, k.Bytes()))
+	}
+	for _, k := range keys {
+		has, err := ds.Has(bg, k)
+		assert.NoError(t, err)
+		assert.True(t, has)
+	}
+
+	txN := ds.bolt().Stats().TxN
+	for i := 0; i < 1000; i++ {
+		has, err := ds.Has(bg, dskey.NewBytesKeyFromString(fmt.Sprintf("absent-%d", i)))
+		assert.NoError(t, err)
+		assert.False(t, has)
+	}
+	// only false positives open a read transaction
+	assert.True(t, ds.bolt().Stats().TxN-txN < 50)
+
+	// deleted keys stay in the filter but are still reported absent
+	assert.NoError(t, ds.Delete(bg, keys[0]))
+	has, err := ds.Has(bg, keys[0])
+	assert.NoError(t, err)
+	assert.False(t, has)
+}
@@ -0,0 +1,43 @@
+package dsbbolt
+
+import (
+	"bytes"
+
+	"go.etcd.io/bbolt"
+)
+
+// ListBuckets returns the names of the top-level buckets of the bbolt file
+// at path, in byte order, without the bucket reserved for the metadata of
+// the package. The file is opened read-only, which waits for any process
+// holding it open for writing, such as a Datastore, to close it: use
+// Datastore.ListBuckets then.
+func ListBuckets(path string) ([][]byte, error) {
+	db, err := bbolt.Open(path, defaultFileMode, &bbolt.Options{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return listBuckets(db)
+}
+
+// ListBuckets returns the names of the top-level buckets of the db file
+// the datastore is stored in, whatever its own bucket, see ListBuckets
+func (d *Datastore) ListBuckets() ([][]byte, error) {
+	return listBuckets(d.bolt())
+}
+
+func listBuckets(db *bbolt.DB) ([][]byte, error) {
+	var names [][]byte
+	err := db.View(func(tx *bbolt.Tx) error {
+		return tx.ForEach(func(name []byte, _ *bbolt.Bucket) error {
+			if !bytes.Equal(name, metaBucket) {
+				names = append(names, copyBytes(name))
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return names, nil
+}
@@ -0,0 +1,28 @@
+package dsbbolt
+
+import (
+	"path/filepath"
+	"testing"
+
+	dskey "github.com/daotl/go-datastore/key"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListBuckets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bolt")
+	ds, err := NewDatastore(path, nil, []byte("one"), dskey.KeyTypeBytes)
+	assert.NoError(t, err)
+	_, err = ds.WithBucket([]byte("two"))
+	assert.NoError(t, err)
+	_, err = ds.WithBucket([]byte("three"))
+	assert.NoError(t, err)
+	want := [][]byte{[]byte("one"), []byte("three"), []byte("two")}
+	names, err := ds.ListBuckets()
+	assert.NoError(t, err)
+	assert.Equal(t, want, names)
+	assert.NoError(t, ds.Close())
+
+	names, err = ListBuckets(path)
+	assert.NoError(t, err)
+	assert.Equal(t, want, names)
+}
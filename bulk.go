@@ -0,0 +1,214 @@
+package dsbbolt
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/daotl/go-datastore"
+	dskey "github.com/daotl/go-datastore/key"
+	"go.etcd.io/bbolt"
+)
+
+// KeyValue is a key and its value
+type KeyValue struct {
+	Key   dskey.Key
+	Value []byte
+}
+
+// PutMany stores all entries in a single write transaction. Key types and
+// sizes are checked before the transaction starts, so either all entries
+// are written or none of them. When a key appears more than once, its last
+// entry is the one stored.
+func (d *Datastore) PutMany(ctx context.Context, entries []KeyValue) error {
+	keys := make([][]byte, len(entries))
+	for i, e := range entries {
+		if e.Key.KeyType() != d.ktype {
+			return ErrKeyTypeNotMatch
+		}
+		var err error
+		if keys[i], err = d.storedKey(e.Key); err != nil {
+			return err
+		}
+		if err := d.checkEntry(keys[i], e.Value); err != nil {
+			return err
+		}
+	}
+	if d.bolt().IsReadOnly() {
+		return ErrReadOnly
+	}
+	// writing in key order fills the pages of the B+tree one after another,
+	// the stable sort keeps the entries of a key in order
+	order := make([]int, len(entries))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return bytes.Compare(keys[order[i]], keys[order[j]]) < 0
+	})
+	return d.update(ctx, func(tx *bbolt.Tx) error {
+		bucket, err := d.openBucket(tx)
+		if err != nil {
+			return err
+		}
+		for _, i := range order {
+			value, err := d.encodeValue(keys[i], entries[i].Value)
+			if err != nil {
+				return err
+			}
+			if err := d.putStored(bucket, keys[i], value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetMany returns the values of keys read in a single read transaction,
+// aligned with keys: the value of a missing key is nil, while an empty
+// value is a non-nil empty slice. Key types are checked before the
+// transaction starts.
+func (d *Datastore) GetMany(ctx context.Context, keys []dskey.Key) ([][]byte, error) {
+	stored := make([][]byte, len(keys))
+	for i, key := range keys {
+		if key.KeyType() != d.ktype {
+			return nil, ErrKeyTypeNotMatch
+		}
+		var err error
+		if stored[i], err = d.storedKey(key); err != nil {
+			return nil, err
+		}
+	}
+	values := make([][]byte, len(keys))
+	if err := d.view(func(tx *bbolt.Tx) error {
+		bucket, err := d.openBucket(tx)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		for i, k := range stored {
+			data := bucket.Get(k)
+			if data == nil {
+				continue
+			}
+			if _, err := d.liveMeta(data, now); err == datastore.ErrNotFound {
+				continue
+			} else if err != nil {
+				return err
+			}
+			if values[i], err = d.decodeValue(k, data); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// DeletePrefix deletes in a single write transaction every key a Query
+// with the same prefix would return, every key for a nil prefix, and
+// returns how many were deleted
+func (d *Datastore) DeletePrefix(ctx context.Context, prefix dskey.Key) (int, error) {
+	if keyTypeMismatch(prefix, d.ktype) {
+		return 0, ErrKeyTypeNotMatch
+	}
+	if d.bolt().IsReadOnly() {
+		return 0, ErrReadOnly
+	}
+	start, limit, err := d.prefixBounds(prefix)
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	err = d.update(ctx, func(tx *bbolt.Tx) error {
+		bucket, err := d.openBucket(tx)
+		if err != nil {
+			return err
+		}
+		n, err = d.deleteRange(bucket, start, limit, 0)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// DeletePrefixChunked deletes every key a Query with the same prefix would
+// return like DeletePrefix, but in write transactions of up to chunkSize
+// keys each, so that other writers get the write lock in between and a
+// large prefix can be deleted without holding it for long. ctx is checked
+// between chunks. The deletion is not atomic: the chunks committed before
+// an error stay deleted, and the number of keys they deleted is returned
+// along with the error.
+func (d *Datastore) DeletePrefixChunked(ctx context.Context, prefix dskey.Key, chunkSize int) (int, error) {
+	if keyTypeMismatch(prefix, d.ktype) {
+		return 0, ErrKeyTypeNotMatch
+	}
+	if chunkSize <= 0 {
+		return 0, fmt.Errorf("invalid chunk size %d", chunkSize)
+	}
+	if d.bolt().IsReadOnly() {
+		return 0, ErrReadOnly
+	}
+	ctx = orBackground(ctx)
+	start, limit, err := d.prefixBounds(prefix)
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return deleted, err
+		}
+		var n int
+		err := d.update(ctx, func(tx *bbolt.Tx) error {
+			bucket, err := d.openBucket(tx)
+			if err != nil {
+				return err
+			}
+			n, err = d.deleteRange(bucket, start, limit, chunkSize)
+			return err
+		})
+		if err != nil {
+			return deleted, err
+		}
+		deleted += n
+		if n < chunkSize {
+			return deleted, nil
+		}
+	}
+}
+
+// deleteRange deletes the keys of bucket from start to limit excluded,
+// nil bounds being unbounded, up to max keys unless max is 0, and returns
+// how many were deleted. Nested buckets are left in place.
+func (d *Datastore) deleteRange(bucket *bbolt.Bucket, start, limit []byte, max int) (int, error) {
+	n := 0
+	c := bucket.Cursor()
+	k, v := c.First()
+	if start != nil {
+		k, v = c.Seek(start)
+	}
+	for k != nil && (limit == nil || bytes.Compare(k, limit) < 0) && (max == 0 || n < max) {
+		if v == nil && bucket.Bucket(k) != nil {
+			k, v = c.Next()
+			continue
+		}
+		// Next may skip a key after Delete, seek past the deleted key instead
+		deleted := copyBytes(k)
+		if err := d.beforeDelete(bucket, deleted, v); err != nil {
+			return n, err
+		}
+		if err := c.Delete(); err != nil {
+			return n, err
+		}
+		n++
+		k, v = c.Seek(deleted)
+	}
+	return n, nil
+}
@@ -0,0 +1,318 @@
+package dsbbolt
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"testing"
+
+	"github.com/daotl/go-datastore"
+	dskey "github.com/daotl/go-datastore/key"
+	"github.com/daotl/go-datastore/query"
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/bbolt"
+)
+
+func TestPutMany(t *testing.T) {
+	ds := newTestDatastore(t)
+	keys := benchmarkKeys(100)
+	entries := make([]KeyValue, len(keys))
+	for i, k := range keys {
+		entries[i] = KeyValue{Key: k, Value: k.Bytes()}
+	}
+	assert.NoError(t, ds.PutMany(bg, entries))
+	for _, k := range keys {
+		v, err := ds.Get(bg, k)
+		assert.NoError(t, err)
+		assert.Equal(t, k.Bytes(), v)
+	}
+
+	t.Run("atomic", func(t *testing.T) {
+		k := dskey.NewBytesKeyFromString("put-many-atomic")
+		err := ds.PutMany(bg, []KeyValue{
+			{Key: k, Value: []byte("v")},
+			{Key: dskey.NewStrKey("str"), Value: []byte("v")},
+		})
+		assert.Equal(t, ErrKeyTypeNotMatch, err)
+		_, err = ds.Get(bg, k)
+		assert.Equal(t, datastore.ErrNotFound, err)
+	})
+}
+
+func TestDeletePrefix(t *testing.T) {
+	ds := newTestDatastore(t)
+	entries := make([]KeyValue, 0, 2000)
+	for _, prefix := range []string{"evict/", "keep/"} {
+		for i := 0; i < 1000; i++ {
+			k := dskey.NewBytesKeyFromString(fmt.Sprintf("%s%04d", prefix, i))
+			entries = append(entries, KeyValue{Key: k, Value: k.Bytes()})
+		}
+	}
+	assert.NoError(t, ds.PutMany(bg, entries))
+
+	n, err := ds.DeletePrefix(bg, dskey.NewBytesKeyFromString("evict/"))
+	assert.NoError(t, err)
+	assert.Equal(t, 1000, n)
+
+	rs, err := ds.Query(bg, query.Query{KeysOnly: true})
+	assert.NoError(t, err)
+	rest, err := rs.Rest()
+	assert.NoError(t, err)
+	assert.Equal(t, 1000, len(rest))
+	for _, e := range rest {
+		assert.True(t, bytes.HasPrefix(e.Key.Bytes(), []byte("keep/")))
+	}
+
+	n, err = ds.DeletePrefix(bg, dskey.NewBytesKeyFromString("evict/"))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	n, err = ds.DeletePrefix(bg, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1000, n)
+	count, err := ds.Count(bg, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
+
+// checkedContext is a context canceled once its Err has been called n
+// times, to cancel an operation after a given number of steps
+type checkedContext struct {
+	context.Context
+	n int
+}
+
+func (c *checkedContext) Err() error {
+	if c.n--; c.n < 0 {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestDeletePrefixChunked(t *testing.T) {
+	ds := newTestDatastore(t)
+	b, err := ds.Batch(bg)
+	assert.NoError(t, err)
+	for _, k := range benchmarkKeys(10000) {
+		assert.NoError(t, b.Put(bg, k, []byte("v")))
+	}
+	keep := dskey.NewBytesKeyFromString("keep")
+	assert.NoError(t, b.Put(bg, keep, []byte("v")))
+	assert.NoError(t, b.Commit(bg))
+	prefix := dskey.NewBytesKeyFromString("bench-")
+
+	// canceled before the fourth chunk
+	n, err := ds.DeletePrefixChunked(&checkedContext{Context: bg, n: 3}, prefix, 1000)
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 3000, n)
+	left, err := ds.Count(bg, prefix)
+	assert.NoError(t, err)
+	assert.Equal(t, 7000, left)
+
+	n, err = ds.DeletePrefixChunked(bg, prefix, 1000)
+	assert.NoError(t, err)
+	assert.Equal(t, 7000, n)
+	left, err = ds.Count(bg, prefix)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, left)
+	has, err := ds.Has(bg, keep)
+	assert.NoError(t, err)
+	assert.True(t, has)
+
+	_, err = ds.DeletePrefixChunked(bg, prefix, 0)
+	assert.Error(t, err)
+
+	n, err = ds.DeletePrefixChunked(bg, nil, 1000)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+}
+
+func BenchmarkPutLoop(b *testing.B) {
+	keys := benchmarkKeys(1000)
+	val := []byte("benchmark value")
+	ds, err := NewDatastore(filepath.Join(b.TempDir(), "bolt"), nil, nil, dskey.KeyTypeBytes)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer ds.Close()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, k := range keys {
+			if err := ds.Put(bg, k, val); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkPutMany(b *testing.B) {
+	keys := benchmarkKeys(1000)
+	val := []byte("benchmark value")
+	entries := make([]KeyValue, len(keys))
+	for i, k := range keys {
+		entries[i] = KeyValue{Key: k, Value: val}
+	}
+	ds, err := NewDatastore(filepath.Join(b.TempDir(), "bolt"), nil, nil, dskey.KeyTypeBytes)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer ds.Close()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := ds.PutMany(bg, entries); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestPutManyOrder(t *testing.T) {
+	keys := benchmarkKeys(1000)
+	entries := make([]KeyValue, len(keys))
+	for i, k := range keys {
+		entries[i] = KeyValue{Key: k, Value: k.Bytes()}
+	}
+	shuffled := append([]KeyValue{}, entries...)
+	rand.New(rand.NewSource(1)).Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+	// the last entry of a key wins whatever the order of the keys
+	shuffled = append(shuffled, KeyValue{Key: keys[0], Value: []byte("last")})
+	entries[0].Value = []byte("last")
+
+	contents := func(entries []KeyValue) []query.Entry {
+		ds := newTestDatastore(t)
+		assert.NoError(t, ds.PutMany(bg, entries))
+		results, err := ds.Query(bg, query.Query{})
+		assert.NoError(t, err)
+		all, err := results.Rest()
+		assert.NoError(t, err)
+		return all
+	}
+	assert.Equal(t, contents(entries), contents(shuffled))
+}
+
+// BenchmarkPutManyOrder compares writing random keys in a single
+// transaction in their random order, as PutMany used to, to PutMany which
+// sorts them first
+func BenchmarkPutManyOrder(b *testing.B) {
+	rnd := rand.New(rand.NewSource(1))
+	entries := make([]KeyValue, 100000)
+	for i := range entries {
+		key := make([]byte, 16)
+		rnd.Read(key)
+		entries[i] = KeyValue{Key: dskey.NewBytesKey(key), Value: []byte("benchmark value")}
+	}
+	b.Run("Unsorted", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			ds, err := NewDatastore(filepath.Join(b.TempDir(), "bolt"), nil, nil, dskey.KeyTypeBytes)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if err := ds.update(bg, func(tx *bbolt.Tx) error {
+				bucket, err := ds.openBucket(tx)
+				if err != nil {
+					return err
+				}
+				for _, e := range entries {
+					if err := ds.putStored(bucket, e.Key.Bytes(), e.Value); err != nil {
+						return err
+					}
+				}
+				return nil
+			}); err != nil {
+				b.Fatal(err)
+			}
+			ds.Close()
+		}
+	})
+	b.Run("Sorted", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			ds, err := NewDatastore(filepath.Join(b.TempDir(), "bolt"), nil, nil, dskey.KeyTypeBytes)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if err := ds.PutMany(bg, entries); err != nil {
+				b.Fatal(err)
+			}
+			ds.Close()
+		}
+	})
+}
+
+func TestGetMany(t *testing.T) {
+	ds := newTestDatastore(t)
+	present := dskey.NewBytesKeyFromString("present")
+	empty := dskey.NewBytesKeyFromString("empty")
+	absent := dskey.NewBytesKeyFromString("absent")
+	assert.NoError(t, ds.Put(bg, present, []byte("value")))
+	assert.NoError(t, ds.Put(bg, empty, []byte{}))
+
+	values, err := ds.GetMany(bg, []dskey.Key{absent, present, empty, present})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{nil, []byte("value"), {}, []byte("value")}, values)
+	assert.NotNil(t, values[2])
+
+	_, err = ds.GetMany(bg, []dskey.Key{present, dskey.NewStrKey("string")})
+	assert.Equal(t, ErrKeyTypeNotMatch, err)
+}
+
+func benchmarkGetDatastore(b *testing.B, keys []dskey.Key) *Datastore {
+	entries := make([]KeyValue, len(keys))
+	for i, k := range keys {
+		entries[i] = KeyValue{Key: k, Value: []byte("benchmark value")}
+	}
+	ds, err := NewDatastore(filepath.Join(b.TempDir(), "bolt"), nil, nil, dskey.KeyTypeBytes)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := ds.PutMany(bg, entries); err != nil {
+		b.Fatal(err)
+	}
+	return ds
+}
+
+func BenchmarkGetLoop(b *testing.B) {
+	keys := benchmarkKeys(1000)
+	ds := benchmarkGetDatastore(b, keys)
+	defer ds.Close()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, k := range keys {
+			if _, err := ds.Get(bg, k); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// BenchmarkKeyTypeCheck measures the key type check of every operation,
+// to be compared with the cost per key of BenchmarkPutLoop and
+// BenchmarkGetLoop
+func BenchmarkKeyTypeCheck(b *testing.B) {
+	keys := benchmarkKeys(1000)
+	ds := &Datastore{ktype: dskey.KeyTypeBytes}
+	mismatches := 0
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if keys[i%len(keys)].KeyType() != ds.ktype {
+			mismatches++
+		}
+	}
+	if mismatches > 0 {
+		b.Fatal(ErrKeyTypeNotMatch)
+	}
+}
+
+func BenchmarkGetMany(b *testing.B) {
+	keys := benchmarkKeys(1000)
+	ds := benchmarkGetDatastore(b, keys)
+	defer ds.Close()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ds.GetMany(bg, keys); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
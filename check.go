@@ -0,0 +1,44 @@
+package dsbbolt
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/daotl/go-datastore"
+	"go.etcd.io/bbolt"
+)
+
+var _ datastore.CheckedDatastore = (*Datastore)(nil)
+
+// CheckError holds every inconsistency found by Check
+type CheckError struct {
+	Errors []error
+}
+
+func (e *CheckError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("bbolt check failed with %d errors: %s", len(e.Errors), strings.Join(msgs, "; "))
+}
+
+// Check walks every page of the db verifying the B+tree structure and the
+// freelist, and returns a *CheckError listing all the corruption found
+func (d *Datastore) Check(ctx context.Context) error {
+	var errs []error
+	if err := d.bolt().View(func(tx *bbolt.Tx) error {
+		// the channel must be drained for the check goroutine to finish
+		for err := range tx.Check() {
+			errs = append(errs, err)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return &CheckError{Errors: errs}
+	}
+	return nil
+}
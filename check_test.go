@@ -0,0 +1,70 @@
+package dsbbolt
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	dskey "github.com/daotl/go-datastore/key"
+	"github.com/stretchr/testify/assert"
+)
+
+// dropFreePage removes the last page id of the freelist of the bbolt file
+// at path, leaving that page unreachable and unfreed
+func dropFreePage(t *testing.T, path string) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	assert.NoError(t, err)
+	defer f.Close()
+
+	// meta pages start after the 16 bytes page header:
+	// magic, version, page size, flags, root bucket, freelist, pgid, txid
+	meta := make([]byte, 64)
+	_, err = f.ReadAt(meta, 16)
+	assert.NoError(t, err)
+	pageSize := int64(binary.LittleEndian.Uint32(meta[8:]))
+	var freelist, txid uint64
+	for i := int64(0); i < 2; i++ {
+		_, err = f.ReadAt(meta, i*pageSize+16)
+		assert.NoError(t, err)
+		if id := binary.LittleEndian.Uint64(meta[48:]); id >= txid {
+			txid = id
+			freelist = binary.LittleEndian.Uint64(meta[32:])
+		}
+	}
+
+	// the freelist page count follows its 8 bytes id and 2 bytes flags
+	count := make([]byte, 2)
+	_, err = f.ReadAt(count, int64(freelist)*pageSize+10)
+	assert.NoError(t, err)
+	n := binary.LittleEndian.Uint16(count)
+	if n == 0 || n == 0xFFFF {
+		t.Fatalf("unexpected freelist page count %d", n)
+	}
+	binary.LittleEndian.PutUint16(count, n-1)
+	_, err = f.WriteAt(count, int64(freelist)*pageSize+10)
+	assert.NoError(t, err)
+}
+
+func TestCheck(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bolt")
+	ds, err := NewDatastore(path, nil, nil, dskey.KeyTypeBytes)
+	assert.NoError(t, err)
+	keys := benchmarkKeys(1000)
+	for _, k := range keys[:100] {
+		assert.NoError(t, ds.Put(bg, k, make([]byte, 512)))
+	}
+	for _, k := range keys[:50] {
+		assert.NoError(t, ds.Delete(bg, k))
+	}
+	assert.NoError(t, ds.Check(bg))
+	assert.NoError(t, ds.Close())
+
+	dropFreePage(t, path)
+	ds, err = NewDatastore(path, nil, nil, dskey.KeyTypeBytes)
+	assert.NoError(t, err)
+	defer ds.Close()
+	err = ds.Check(bg)
+	assert.IsType(t, &CheckError{}, err)
+	assert.Contains(t, err.Error(), "unreachable unfreed")
+}
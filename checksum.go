@@ -0,0 +1,60 @@
+package dsbbolt
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash"
+)
+
+// ErrChecksumMismatch is returned when a stored value does not match the
+// checksum stored with it, see WithValueChecksum
+var ErrChecksumMismatch = errors.New("value checksum mismatch")
+
+// checksumSize is the size of the checksum appended to stored values
+const checksumSize = 4
+
+// checksumCodec stores values followed by their big-endian 32 bit checksum
+type checksumCodec struct {
+	newHash func() hash.Hash32
+}
+
+func (cc checksumCodec) sum(value []byte) uint32 {
+	h := cc.newHash()
+	h.Write(value)
+	return h.Sum32()
+}
+
+func (cc checksumCodec) encode(_, value []byte) ([]byte, error) {
+	stored := make([]byte, len(value), len(value)+checksumSize)
+	copy(stored, value)
+	var sum [checksumSize]byte
+	binary.BigEndian.PutUint32(sum[:], cc.sum(value))
+	return append(stored, sum[:]...), nil
+}
+
+func (cc checksumCodec) decode(_, stored []byte) ([]byte, error) {
+	if len(stored) < checksumSize {
+		return nil, ErrInvalidValue
+	}
+	n := len(stored) - checksumSize
+	if binary.BigEndian.Uint32(stored[n:]) != cc.sum(stored[:n]) {
+		return nil, ErrChecksumMismatch
+	}
+	return copyBytes(stored[:n]), nil
+}
+
+func (cc checksumCodec) size(stored []byte) (int, error) {
+	if len(stored) < checksumSize {
+		return -1, ErrInvalidValue
+	}
+	return len(stored) - checksumSize, nil
+}
+
+// unwrap strips the checksum without verifying it, sizes are read from
+// values that are not hashed
+func (cc checksumCodec) unwrap(stored []byte) ([]byte, error) {
+	if len(stored) < checksumSize {
+		return nil, ErrInvalidValue
+	}
+	return stored[:len(stored)-checksumSize], nil
+}
@@ -0,0 +1,54 @@
+package dsbbolt
+
+import (
+	"compress/flate"
+	"hash/crc32"
+	"path/filepath"
+	"testing"
+
+	dskey "github.com/daotl/go-datastore/key"
+	"github.com/daotl/go-datastore/query"
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/bbolt"
+)
+
+func TestValueChecksum(t *testing.T) {
+	for _, opts := range [][]Option{
+		{WithValueChecksum(crc32.NewIEEE)},
+		{WithValueChecksum(crc32.NewIEEE), WithMetadata(), WithCompression(FlateCompressor(flate.BestSpeed))},
+	} {
+		ds, err := NewDatastoreWithOptions(filepath.Join(t.TempDir(), "bolt"), opts...)
+		assert.NoError(t, err)
+		defer ds.Close()
+
+		key := dskey.NewBytesKeyFromString("key")
+		value := []byte("checksummed value")
+		assert.NoError(t, ds.Put(bg, key, value))
+		got, err := ds.Get(bg, key)
+		assert.NoError(t, err)
+		assert.Equal(t, value, got)
+		size, err := ds.GetSize(bg, key)
+		assert.NoError(t, err)
+		assert.Equal(t, len(value), size)
+
+		// flip a bit of the value as stored
+		assert.NoError(t, ds.bolt().Update(func(tx *bbolt.Tx) error {
+			bucket := ds.bucketOf(tx)
+			stored := copyBytes(bucket.Get(key.Bytes()))
+			stored[len(stored)-checksumSize-1] ^= 1
+			return bucket.Put(key.Bytes(), stored)
+		}))
+		_, err = ds.Get(bg, key)
+		assert.Equal(t, ErrChecksumMismatch, err)
+		// sizes are read without verifying the checksum
+		size, err = ds.GetSize(bg, key)
+		assert.NoError(t, err)
+		assert.Equal(t, len(value), size)
+		assert.Equal(t, ErrChecksumMismatch, ds.GetView(bg, key, func([]byte) error { return nil }))
+		results, err := ds.Query(bg, query.Query{})
+		assert.NoError(t, err)
+		_, err = results.Rest()
+		assert.Equal(t, ErrChecksumMismatch, err)
+		assert.NoError(t, results.Close())
+	}
+}
@@ -0,0 +1,124 @@
+package dsbbolt
+
+import (
+	"errors"
+
+	dskey "github.com/daotl/go-datastore/key"
+	"github.com/daotl/go-datastore/query"
+)
+
+// ErrInvalidValue is returned when a stored value cannot be decoded, e.g.
+// a raw value read by a datastore configured to compress values, or a value
+// encrypted with another key
+var ErrInvalidValue = errors.New("stored value cannot be decoded")
+
+// valueCodec converts values between the form callers see and the form
+// stored in bbolt under the stored key key
+type valueCodec interface {
+	encode(key, value []byte) ([]byte, error)
+	// decode returns a value that does not alias stored
+	decode(key, stored []byte) ([]byte, error)
+	// size returns the length of the decoded value of stored
+	size(stored []byte) (int, error)
+}
+
+// unwrapper is implemented by the codecs able to return the form stored by
+// the codecs applied before them without decoding the whole value, which
+// valueSize uses instead of decode
+type unwrapper interface {
+	// unwrap returns a part of stored without verifying it
+	unwrap(stored []byte) ([]byte, error)
+}
+
+// encodeValue returns value in its stored form under the stored key k,
+// codecs are applied in order
+func (d *Datastore) encodeValue(k, value []byte) ([]byte, error) {
+	var err error
+	for _, c := range d.codecs {
+		if value, err = c.encode(k, value); err != nil {
+			return nil, err
+		}
+	}
+	return value, nil
+}
+
+// viewValue returns the value stored as stored under k, sharing its memory
+// unless it has to be decoded
+func (d *Datastore) viewValue(k, stored []byte) ([]byte, error) {
+	if len(d.codecs) > 0 {
+		return d.decodeValue(k, stored)
+	}
+	if d.meta {
+		_, value, err := parseMeta(stored)
+		return value, err
+	}
+	return stored, nil
+}
+
+// decodeValue returns a copy of the value stored as stored under k
+func (d *Datastore) decodeValue(k, stored []byte) ([]byte, error) {
+	var err error
+	if d.meta {
+		if _, stored, err = parseMeta(stored); err != nil {
+			return nil, err
+		}
+	}
+	if len(d.codecs) == 0 {
+		return copyBytes(stored), nil
+	}
+	for i := len(d.codecs) - 1; i >= 0; i-- {
+		if stored, err = d.codecs[i].decode(k, stored); err != nil {
+			return nil, err
+		}
+	}
+	return stored, nil
+}
+
+// valueSize returns the length of the value stored as stored under k
+func (d *Datastore) valueSize(k, stored []byte) (int, error) {
+	var err error
+	if d.meta {
+		if _, stored, err = parseMeta(stored); err != nil {
+			return -1, err
+		}
+	}
+	if len(d.codecs) == 0 {
+		return len(stored), nil
+	}
+	// outer codecs have to be unwrapped or decoded to reach the size of
+	// the inner one
+	for i := len(d.codecs) - 1; i > 0; i-- {
+		if u, ok := d.codecs[i].(unwrapper); ok {
+			stored, err = u.unwrap(stored)
+		} else {
+			stored, err = d.codecs[i].decode(k, stored)
+		}
+		if err != nil {
+			return -1, err
+		}
+	}
+	return d.codecs[0].size(stored)
+}
+
+// toQueryEntry returns the query entry of a stored key/value pair
+func (d *Datastore) toQueryEntry(k, v []byte, keysOnly bool) (query.Entry, error) {
+	if len(d.codecs) == 0 && !d.meta {
+		return toQueryEntry(d.userKey(k), v, keysOnly), nil
+	}
+	entry := query.Entry{Key: dskey.NewBytesKey(copyBytes(d.userKey(k)))}
+	var err error
+	if d.meta {
+		var m Meta
+		if m, _, err = parseMeta(v); err != nil {
+			return query.Entry{}, err
+		}
+		entry.Expiration = m.Expiration
+	}
+	if keysOnly {
+		entry.Size, err = d.valueSize(k, v)
+	} else {
+		entry.Value, err = d.decodeValue(k, v)
+		entry.Size = len(entry.Value)
+	}
+	return entry, err
+}
@@ -0,0 +1,152 @@
+package dsbbolt
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"go.etcd.io/bbolt"
+)
+
+// compactTxMaxSize is the number of key and value bytes copied by Compact
+// in a single write transaction of the destination db
+const compactTxMaxSize = 16 << 20
+
+// Compact writes a copy of the whole db without free pages to a new file at
+// path. bbolt never shrinks its file, so this is the way to reclaim the
+// space left by deleted keys: once compacted, the new file can replace the
+// current one while the datastore is closed. Like CopyTo, it never
+// overwrites an existing file, returning an error satisfying
+// errors.Is(err, os.ErrExist), and compacts into a temporary file synced
+// to disk before being renamed, so that path holds either nothing or a
+// complete copy.
+func (d *Datastore) Compact(ctx context.Context, path string) error {
+	ctx = orBackground(ctx)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if _, err := os.Lstat(path); err == nil {
+		return &os.PathError{Op: "compact", Path: path, Err: os.ErrExist}
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	err = f.Chmod(defaultFileMode)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = compactTo(ctx, d.bolt(), tmp)
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// compactTo compacts src into the empty file at path and syncs it
+func compactTo(ctx context.Context, src *bbolt.DB, path string) error {
+	dst, err := bbolt.Open(path, defaultFileMode, &bbolt.Options{NoSync: true})
+	if err != nil {
+		return err
+	}
+	err = src.View(func(tx *bbolt.Tx) error {
+		return compact(ctx, dst, tx, compactTxMaxSize)
+	})
+	if err == nil {
+		err = dst.Sync()
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// compact copies every bucket of src into dst, committing a write
+// transaction every txMaxSize bytes. It is adapted from the compact
+// command of bbolt.
+func compact(ctx context.Context, dst *bbolt.DB, src *bbolt.Tx, txMaxSize int64) error {
+	tx, err := dst.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		tx.Rollback()
+	}()
+
+	var size int64
+	if err := walk(src, func(keys [][]byte, k, v []byte, seq uint64) error {
+		sz := int64(len(k) + len(v))
+		if size+sz > txMaxSize {
+			if err := tx.Commit(); err != nil {
+				return err
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if tx, err = dst.Begin(true); err != nil {
+				return err
+			}
+			size = 0
+		}
+		size += sz
+
+		if len(keys) == 0 {
+			b, err := tx.CreateBucket(k)
+			if err != nil {
+				return err
+			}
+			return b.SetSequence(seq)
+		}
+		b := tx.Bucket(keys[0])
+		for _, name := range keys[1:] {
+			b = b.Bucket(name)
+		}
+		// keys are copied in order, so pages can be filled up
+		b.FillPercent = 1.0
+		if v == nil {
+			nb, err := b.CreateBucket(k)
+			if err != nil {
+				return err
+			}
+			return nb.SetSequence(seq)
+		}
+		return b.Put(k, v)
+	}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// walkFunc is called for every bucket and key found by walk, keys is the
+// path of the bucket containing k and v is nil when k is a bucket
+type walkFunc func(keys [][]byte, k, v []byte, seq uint64) error
+
+// walk calls fn for every bucket and key of tx, depth first
+func walk(tx *bbolt.Tx, fn walkFunc) error {
+	return tx.ForEach(func(name []byte, b *bbolt.Bucket) error {
+		return walkBucket(b, nil, name, nil, b.Sequence(), fn)
+	})
+}
+
+func walkBucket(b *bbolt.Bucket, keys [][]byte, k, v []byte, seq uint64, fn walkFunc) error {
+	if err := fn(keys, k, v, seq); err != nil {
+		return err
+	}
+	if v != nil {
+		return nil
+	}
+	keys = append(keys, k)
+	return b.ForEach(func(k, v []byte) error {
+		if v == nil {
+			nb := b.Bucket(k)
+			return walkBucket(nb, keys, k, nil, nb.Sequence(), fn)
+		}
+		return walkBucket(b, keys, k, v, b.Sequence(), fn)
+	})
+}
@@ -0,0 +1,81 @@
+package dsbbolt
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	dskey "github.com/daotl/go-datastore/key"
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/bbolt"
+)
+
+func TestCompact(t *testing.T) {
+	ds := newTestDatastore(t)
+	nested, err := ds.WithBucket([]byte("nested"))
+	assert.NoError(t, err)
+	assert.NoError(t, ds.bolt().Update(func(tx *bbolt.Tx) error {
+		b, err := tx.Bucket([]byte("nested")).CreateBucket([]byte("child"))
+		if err != nil {
+			return err
+		}
+		if err := b.SetSequence(42); err != nil {
+			return err
+		}
+		return b.Put([]byte("k"), []byte("v"))
+	}))
+	assert.NoError(t, nested.Put(bg, dskey.NewBytesKeyFromString("n"), []byte("n")))
+
+	keys := benchmarkKeys(10000)
+	value := make([]byte, 1024)
+	b, err := ds.Batch(bg)
+	assert.NoError(t, err)
+	for _, k := range keys {
+		assert.NoError(t, b.Put(bg, k, value))
+	}
+	assert.NoError(t, b.Commit(bg))
+	b, err = ds.Batch(bg)
+	assert.NoError(t, err)
+	for _, k := range keys[100:] {
+		assert.NoError(t, b.Delete(bg, k))
+	}
+	assert.NoError(t, b.Commit(bg))
+
+	path := filepath.Join(t.TempDir(), "compacted")
+	assert.NoError(t, ds.Compact(bg, path))
+
+	before, err := ds.DiskUsage(bg)
+	assert.NoError(t, err)
+	compacted, err := NewDatastore(path, nil, nil, dskey.KeyTypeBytes)
+	assert.NoError(t, err)
+	defer compacted.Close()
+	after, err := compacted.DiskUsage(bg)
+	assert.NoError(t, err)
+	assert.True(t, after < before/4, "compacted %d bytes, original %d bytes", after, before)
+
+	for _, k := range keys[:100] {
+		v, err := compacted.Get(bg, k)
+		assert.NoError(t, err)
+		assert.Equal(t, value, v)
+	}
+	has, err := compacted.Has(bg, keys[100])
+	assert.NoError(t, err)
+	assert.False(t, has)
+	assert.NoError(t, compacted.bolt().View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("nested"))
+		assert.Equal(t, []byte("n"), b.Get([]byte("n")))
+		assert.Equal(t, uint64(42), b.Bucket([]byte("child")).Sequence())
+		assert.Equal(t, []byte("v"), b.Bucket([]byte("child")).Get([]byte("k")))
+		return nil
+	}))
+
+	// existing files, including the live db, are never overwritten
+	for _, existing := range []string{path, ds.bolt().Path()} {
+		err = ds.Compact(bg, existing)
+		assert.True(t, errors.Is(err, os.ErrExist), err)
+	}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(entries))
+}
@@ -0,0 +1,84 @@
+package dsbbolt
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"io/ioutil"
+)
+
+// Compressor compresses values before they are stored, the slices passed
+// to it must not be retained nor aliased by its results
+type Compressor interface {
+	Compress(src []byte) ([]byte, error)
+	Decompress(src []byte) ([]byte, error)
+}
+
+// FlateCompressor returns a Compressor using DEFLATE at the given level,
+// see compress/flate for the valid levels
+func FlateCompressor(level int) Compressor {
+	return flateCompressor{level: level}
+}
+
+type flateCompressor struct {
+	level int
+}
+
+func (c flateCompressor) Compress(src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, c.level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c flateCompressor) Decompress(src []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(src))
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// compressionCodec stores values compressed by a Compressor, prefixed with
+// their uncompressed length as a uvarint so their size is known without
+// decompressing them
+type compressionCodec struct {
+	c Compressor
+}
+
+func (cc compressionCodec) encode(_, value []byte) ([]byte, error) {
+	compressed, err := cc.c.Compress(value)
+	if err != nil {
+		return nil, err
+	}
+	stored := make([]byte, binary.MaxVarintLen64+len(compressed))
+	n := binary.PutUvarint(stored, uint64(len(value)))
+	n += copy(stored[n:], compressed)
+	return stored[:n], nil
+}
+
+func (cc compressionCodec) decode(_, stored []byte) ([]byte, error) {
+	size, n := binary.Uvarint(stored)
+	if n <= 0 {
+		return nil, ErrInvalidValue
+	}
+	value, err := cc.c.Decompress(stored[n:])
+	if err != nil || uint64(len(value)) != size {
+		return nil, ErrInvalidValue
+	}
+	return value, nil
+}
+
+func (cc compressionCodec) size(stored []byte) (int, error) {
+	size, n := binary.Uvarint(stored)
+	if n <= 0 {
+		return -1, ErrInvalidValue
+	}
+	return int(size), nil
+}
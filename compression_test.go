@@ -0,0 +1,78 @@
+package dsbbolt
+
+import (
+	"bytes"
+	"compress/flate"
+	"path/filepath"
+	"testing"
+
+	dskey "github.com/daotl/go-datastore/key"
+	"github.com/daotl/go-datastore/query"
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/bbolt"
+)
+
+func TestCompression(t *testing.T) {
+	ds, err := NewDatastoreWithOptions(filepath.Join(t.TempDir(), "bolt"),
+		WithCompression(FlateCompressor(flate.BestSpeed)))
+	assert.NoError(t, err)
+	defer ds.Close()
+
+	key := dskey.NewBytesKeyFromString("json")
+	value := bytes.Repeat([]byte(`{"name":"value"},`), 100)
+	assert.NoError(t, ds.Put(bg, key, value))
+
+	got, err := ds.Get(bg, key)
+	assert.NoError(t, err)
+	assert.Equal(t, value, got)
+	size, err := ds.GetSize(bg, key)
+	assert.NoError(t, err)
+	assert.Equal(t, len(value), size)
+	assert.NoError(t, ds.bolt().View(func(tx *bbolt.Tx) error {
+		stored := ds.bucketOf(tx).Get(key.Bytes())
+		assert.True(t, len(stored) < len(value), "stored %d bytes", len(stored))
+		return nil
+	}))
+
+	for _, keysOnly := range []bool{false, true} {
+		results, err := ds.Query(bg, query.Query{KeysOnly: keysOnly, ReturnsSizes: true})
+		assert.NoError(t, err)
+		entries, err := results.Rest()
+		assert.NoError(t, err)
+		if assert.Len(t, entries, 1) {
+			assert.Equal(t, len(value), entries[0].Size)
+			if !keysOnly {
+				assert.Equal(t, value, entries[0].Value)
+			}
+		}
+	}
+
+	txn, err := ds.NewTransaction(bg, false)
+	assert.NoError(t, err)
+	txnKey := dskey.NewBytesKeyFromString("txn")
+	assert.NoError(t, txn.Put(bg, txnKey, value))
+	got, err = txn.Get(bg, txnKey)
+	assert.NoError(t, err)
+	assert.Equal(t, value, got)
+	size, err = txn.GetSize(bg, txnKey)
+	assert.NoError(t, err)
+	assert.Equal(t, len(value), size)
+	assert.NoError(t, txn.Commit(bg))
+
+	batch, err := ds.Batch(bg)
+	assert.NoError(t, err)
+	batchKey := dskey.NewBytesKeyFromString("batch")
+	assert.NoError(t, batch.Put(bg, batchKey, value))
+	assert.NoError(t, batch.Commit(bg))
+	got, err = ds.Get(bg, batchKey)
+	assert.NoError(t, err)
+	assert.Equal(t, value, got)
+
+	// values written without compression cannot be read back
+	rawKey := dskey.NewBytesKeyFromString("raw")
+	assert.NoError(t, ds.bolt().Update(func(tx *bbolt.Tx) error {
+		return ds.bucketOf(tx).Put(rawKey.Bytes(), []byte("raw"))
+	}))
+	_, err = ds.Get(bg, rawKey)
+	assert.Equal(t, ErrInvalidValue, err)
+}
@@ -0,0 +1,435 @@
+package dsbbolt
+
+import (
+	"crypto/cipher"
+	"hash"
+	"os"
+	"time"
+
+	dskey "github.com/daotl/go-datastore/key"
+	"go.etcd.io/bbolt"
+)
+
+// defaultFileMode is the mode of db files created when Config.FileMode is 0
+const defaultFileMode = os.FileMode(0640)
+
+// defaultReadTxMaxAge is the age of pooled read transactions used when
+// Config.ReadTxMaxAge is 0
+const defaultReadTxMaxAge = 100 * time.Millisecond
+
+// Config holds the settings used to open a Datastore
+type Config struct {
+	// BoltOptions is passed to bbolt.Open, nil uses the bbolt defaults with
+	// the map freelist
+	BoltOptions *bbolt.Options
+	// FreelistType overrides the freelist type of BoltOptions when not
+	// empty, see WithFreelistType
+	FreelistType bbolt.FreelistType
+	// PageSize overrides the page size of BoltOptions when not 0, see
+	// WithPageSize
+	PageSize int
+	// Bucket is the bucket keys are stored in, nil uses "datastore"
+	Bucket []byte
+	// BucketPath is a path of nested buckets, from a top-level bucket down
+	// to the bucket keys are stored in. It takes precedence over Bucket.
+	BucketPath [][]byte
+	// KeyType is the type of the keys, only dskey.KeyTypeBytes is supported
+	KeyType dskey.KeyType
+	// FileMode is the mode the db file is created with, 0 uses 0640
+	FileMode os.FileMode
+	// InclusiveRangeEnd makes queries include keys equal to query.Range.End,
+	// by default the end of a range is exclusive
+	InclusiveRangeEnd bool
+	// RangeComparator orders keys for query.Range bounds instead of the
+	// byte order, see WithRangeComparator
+	RangeComparator func(a, b []byte) int
+	// Compressor compresses stored values, nil stores them uncompressed
+	Compressor Compressor
+	// AEAD encrypts stored values, nil stores them in plaintext. Keys are
+	// always stored in plaintext so that prefix and range queries work.
+	AEAD cipher.AEAD
+	// ValueChecksum returns the hash stored values are checksummed with,
+	// nil stores no checksum. See WithValueChecksum.
+	ValueChecksum func() hash.Hash32
+	// Metadata prefixes stored values with their write time and version,
+	// see GetWithMeta
+	Metadata bool
+	// ReapInterval is the interval the reaper deletes expired keys at, 0
+	// leaves it stopped. It requires Metadata.
+	ReapInterval time.Duration
+	// MaxRetries is the number of times Update and View retry a
+	// transaction failing with a retryable error, 0 disables retries
+	MaxRetries int
+	// RetryBackoff is the wait before the first retry, doubled each retry
+	RetryBackoff time.Duration
+	// OpenRetries is the number of times opening the db is retried while
+	// its file lock is held, 0 disables retries. See WithOpenRetry.
+	OpenRetries int
+	// OpenRetryDelay is the wait before the first retry of opening the db,
+	// doubled each retry
+	OpenRetryDelay time.Duration
+	// SyncEvery is the number of committed writes after which a db opened
+	// with NoSync is synced, 0 leaves syncing to Sync. See WithSyncEvery.
+	SyncEvery int
+	// MaxValueSize is the largest value that can be put, 0 for no limit
+	MaxValueSize int
+	// ReadTxPoolSize is the number of read transactions kept open for Get,
+	// Has, GetSize and GetMany to reuse, 0 disables pooling. See
+	// WithReadTxPool.
+	ReadTxPoolSize int
+	// ReadTxMaxAge is the age past which a pooled read transaction is
+	// replaced, 0 uses 100ms
+	ReadTxMaxAge time.Duration
+	// ReadTxMaxReads is the number of reads after which a pooled read
+	// transaction is replaced, 0 for no limit
+	ReadTxMaxReads int
+	// BloomFilterKeys is the number of keys the Bloom filter of Has, Get and
+	// GetSize is sized for, 0 disables the filter. See WithBloomFilter.
+	BloomFilterKeys int
+	// BloomFalsePositiveRate is the false positive rate of the Bloom filter
+	// once it holds BloomFilterKeys keys, 0 uses 1%
+	BloomFalsePositiveRate float64
+	// MetricsHook is notified of every operation, nil disables metrics
+	MetricsHook MetricsHook
+	// Logger receives the log messages of the datastore, nil disables
+	// logging
+	Logger Logger
+	// Index computes the secondary index key of the values put, nil
+	// disables the index. See WithIndex.
+	Index IndexFunc
+	// KeyTransform returns the bytes a key is stored under, nil stores the
+	// bytes of the key. See WithKeyTransform.
+	KeyTransform func(dskey.Key) ([]byte, error)
+	// Tombstones records the deleted keys, see WithTombstones
+	Tombstones bool
+	// QueryCacheEntries is the number of query results cached, 0 disables
+	// the cache. See WithQueryCache.
+	QueryCacheEntries int
+	// QueryCacheBytes is the size of the keys and values of the query
+	// results cached, 0 disables the cache
+	QueryCacheBytes int
+}
+
+// Option sets a field of the Config used by NewDatastoreWithOptions
+type Option func(*Config)
+
+// WithBucket sets the bucket keys are stored in
+func WithBucket(name []byte) Option {
+	return func(cfg *Config) {
+		cfg.Bucket = name
+	}
+}
+
+// WithBucketPath sets the path of nested buckets keys are stored in
+func WithBucketPath(path ...[]byte) Option {
+	return func(cfg *Config) {
+		cfg.BucketPath = path
+	}
+}
+
+// WithKeyType sets the type of the keys
+func WithKeyType(keytype dskey.KeyType) Option {
+	return func(cfg *Config) {
+		cfg.KeyType = keytype
+	}
+}
+
+// WithBoltOptions sets the options passed to bbolt.Open
+func WithBoltOptions(opts *bbolt.Options) Option {
+	return func(cfg *Config) {
+		cfg.BoltOptions = opts
+	}
+}
+
+// WithFreelistType sets the type of freelist bbolt tracks free pages with.
+// The map freelist, used by default, is much faster than the array one
+// bbolt defaults to when a large db has many free pages.
+func WithFreelistType(t bbolt.FreelistType) Option {
+	return func(cfg *Config) {
+		cfg.FreelistType = t
+	}
+}
+
+// WithPageSize sets the page size of the db file when it is created, an
+// existing file keeping the page size it was created with. It must be a
+// power of two of at least 512, and should be a multiple of the OS page
+// size, which bbolt uses by default: e.g. 16KB on systems with 16KB pages.
+func WithPageSize(n int) Option {
+	return func(cfg *Config) {
+		cfg.PageSize = n
+	}
+}
+
+// boltOptions returns the options cfg opens the db with
+func (cfg *Config) boltOptions() *bbolt.Options {
+	if cfg.BoltOptions != nil && cfg.FreelistType == "" && cfg.PageSize == 0 {
+		return cfg.BoltOptions
+	}
+	opts := *bbolt.DefaultOptions
+	opts.FreelistType = bbolt.FreelistMapType
+	if cfg.BoltOptions != nil {
+		opts = *cfg.BoltOptions
+	}
+	if cfg.FreelistType != "" {
+		opts.FreelistType = cfg.FreelistType
+	}
+	if cfg.PageSize != 0 {
+		opts.PageSize = cfg.PageSize
+	}
+	return &opts
+}
+
+// mmapStep is the size bbolt grows its memory map by once it is larger
+// than that, smaller maps doubling in size
+const mmapStep = 1 << 30
+
+// RecommendedOptions returns bbolt options for a db expected to hold
+// expectedBytes bytes of keys and values, to be passed to NewDatastore or
+// WithBoltOptions. InitialMmapSize is set to the map size bbolt would grow
+// to for twice that, pages being about half full after random inserts, so
+// that the db does not remap, and block writes on open read transactions,
+// while it grows to its expected size. NoGrowSync is left off, as skipping
+// the truncate call when growing the file is not safe on ext3 and ext4.
+// The map freelist is used, as when no options are passed.
+func RecommendedOptions(expectedBytes int64) *bbolt.Options {
+	opts := *bbolt.DefaultOptions
+	opts.NoGrowSync = false
+	opts.FreelistType = bbolt.FreelistMapType
+	size := int64(32 << 10)
+	for size < 2*expectedBytes && size < mmapStep {
+		size *= 2
+	}
+	if 2*expectedBytes > size {
+		size = (2*expectedBytes + mmapStep - 1) / mmapStep * mmapStep
+	}
+	opts.InitialMmapSize = int(size)
+	return &opts
+}
+
+// WithFileMode sets the mode the db file is created with
+func WithFileMode(mode os.FileMode) Option {
+	return func(cfg *Config) {
+		cfg.FileMode = mode
+	}
+}
+
+// WithInclusiveRangeEnd makes queries include keys equal to query.Range.End
+func WithInclusiveRangeEnd() Option {
+	return func(cfg *Config) {
+		cfg.InclusiveRangeEnd = true
+	}
+}
+
+// WithRangeComparator makes queries compare keys to query.Range bounds
+// with cmp, which returns a negative number, 0 or a positive number when a
+// is lower than, equal to or greater than b. Keys are still stored and
+// returned in byte order, and since cmp may not follow it, a query with a
+// range reads every key of its prefix to check it against the range.
+func WithRangeComparator(cmp func(a, b []byte) int) Option {
+	return func(cfg *Config) {
+		cfg.RangeComparator = cmp
+	}
+}
+
+// WithCompression makes the datastore compress values with c
+func WithCompression(c Compressor) Option {
+	return func(cfg *Config) {
+		cfg.Compressor = c
+	}
+}
+
+// WithEncryption makes the datastore encrypt values with aead, see NewAESGCM.
+// Values are compressed before they are encrypted, and bound to the stored
+// key they are put under: a value moved to another key fails to decrypt.
+func WithEncryption(aead cipher.AEAD) Option {
+	return func(cfg *Config) {
+		cfg.AEAD = aead
+	}
+}
+
+// WithValueChecksum appends to every stored value its checksum computed
+// by a hash returned by newHash, e.g. crc32.NewIEEE, and verifies it when
+// the value is read, so that a corrupted value is reported with
+// ErrChecksumMismatch instead of being returned. The checksum covers the
+// value as stored, compressed and encrypted if configured, but not the
+// metadata header. Sizes, from GetSize and KeysOnly queries, are read
+// without verifying it. Values put without a checksum cannot be read once
+// it is enabled.
+func WithValueChecksum(newHash func() hash.Hash32) Option {
+	return func(cfg *Config) {
+		cfg.ValueChecksum = newHash
+	}
+}
+
+// WithMetadata makes the datastore store the write time and version of
+// values, see GetWithMeta
+func WithMetadata() Option {
+	return func(cfg *Config) {
+		cfg.Metadata = true
+	}
+}
+
+// WithReapInterval starts the reaper deleting expired keys every interval,
+// see StartReaper
+func WithReapInterval(interval time.Duration) Option {
+	return func(cfg *Config) {
+		cfg.ReapInterval = interval
+	}
+}
+
+// WithRetry makes Update and View retry a transaction failing with a
+// retryable error up to maxRetries times, waiting backoff before the first
+// retry and twice as long before each next one
+func WithRetry(maxRetries int, backoff time.Duration) Option {
+	return func(cfg *Config) {
+		cfg.MaxRetries = maxRetries
+		cfg.RetryBackoff = backoff
+	}
+}
+
+// WithOpenRetry makes opening the db retry up to attempts times while its
+// file lock is held, e.g. by a previous process still shutting down during
+// a rolling restart, waiting delay before the first retry and twice as
+// long before each next one. Each attempt tries the lock once, or waits
+// up to the Timeout of the bbolt options if one is set.
+func WithOpenRetry(attempts int, delay time.Duration) Option {
+	return func(cfg *Config) {
+		cfg.OpenRetries = attempts
+		cfg.OpenRetryDelay = delay
+	}
+}
+
+// WithSyncEvery syncs a db opened with bbolt's NoSync every n puts and
+// deletes, counted once their transaction commits, bounding the writes a
+// crash can lose while sparing a fsync per commit. The commit reaching n
+// writes pays for the sync, which covers every write committed before it.
+// It has no effect without NoSync, each commit being synced already.
+func WithSyncEvery(n int) Option {
+	return func(cfg *Config) {
+		cfg.SyncEvery = n
+	}
+}
+
+// WithMaxValueSize makes puts of values larger than n bytes fail with
+// ErrValueTooLarge
+func WithMaxValueSize(n int) Option {
+	return func(cfg *Config) {
+		cfg.MaxValueSize = n
+	}
+}
+
+// WithReadTxPool makes Get, Has, GetSize and GetMany reuse up to size read
+// transactions kept open, instead of opening one per call. A pooled
+// transaction is replaced once it is maxAge old or has served maxReads
+// reads, a 0 maxAge using 100ms and a 0 maxReads meaning no read limit.
+//
+// Writes of the datastore roll back the idle pooled transactions, so a
+// read that follows a write sees it. Reads running concurrently with a
+// write may still return to the pool a transaction that predates it, and
+// later reads may then miss the write for up to maxAge, or maxReads reads.
+// Open transactions also keep bbolt from reusing the pages freed since they
+// were opened, and delay writes that need to grow the memory map of the db
+// file until they are rolled back: idle transactions are rolled back every
+// maxAge to bound these effects.
+func WithReadTxPool(size int, maxAge time.Duration, maxReads int) Option {
+	return func(cfg *Config) {
+		cfg.ReadTxPoolSize = size
+		cfg.ReadTxMaxAge = maxAge
+		cfg.ReadTxMaxReads = maxReads
+	}
+}
+
+// WithBloomFilter makes Has, Get and GetSize check an in-memory Bloom
+// filter of the stored keys before reading the db, so that lookups of
+// absent keys mostly return without a read transaction. The filter is
+// sized for keys keys with a false positive rate of fpRate, 0 using 1%,
+// and loaded by scanning the bucket when the datastore is opened.
+//
+// Keys are added to the filter as they are put but never removed, so
+// deleted keys only cost a db read like without the filter, and a filter
+// that outgrows its size only lets more lookups through. Keys put through
+// the bbolt transaction of BoltTx are not added: they may be reported
+// absent until the datastore is reopened.
+func WithBloomFilter(keys int, fpRate float64) Option {
+	return func(cfg *Config) {
+		cfg.BloomFilterKeys = keys
+		cfg.BloomFalsePositiveRate = fpRate
+	}
+}
+
+// WithMetricsHook sets the hook notified of every operation
+func WithMetricsHook(hook MetricsHook) Option {
+	return func(cfg *Config) {
+		cfg.MetricsHook = hook
+	}
+}
+
+// NewDatastoreWithOptions instantiates a datastore stored at path,
+// keys are bytes keys unless WithKeyType says otherwise
+func NewDatastoreWithOptions(path string, opts ...Option) (*Datastore, error) {
+	cfg := Config{KeyType: dskey.KeyTypeBytes}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return NewDatastoreWithConfig(path, cfg)
+}
+
+// WithLogger sets the logger receiving the transaction lifecycle at debug
+// level, and the errors that cannot be returned, such as those of Discard
+// or of the background reaper, as warnings
+func WithLogger(logger Logger) Option {
+	return func(cfg *Config) {
+		cfg.Logger = logger
+	}
+}
+
+// WithIndex maintains a secondary index of the datastore bucket, holding
+// every key under the index key fn returns for its value, which
+// QueryByIndex looks up. The index is updated in the transaction of every
+// write, making writes slower. Keys written before the index was
+// configured are not indexed until they are written again.
+func WithIndex(fn IndexFunc) Option {
+	return func(cfg *Config) {
+		cfg.Index = fn
+	}
+}
+
+// WithKeyTransform stores every key under the bytes fn returns for it
+// instead of its own bytes, e.g. to normalize keys or to hash keys too long
+// for a page down to a fixed size. The error of fn is returned by the
+// operation given the key. Prefixes and range bounds of queries are
+// transformed like keys, so they only select the expected keys when fn
+// preserves prefixes and order, like lowercasing does; a hash does not, and
+// then only the exact keys can be looked up. Queries and iterators return
+// the stored, transformed keys.
+func WithKeyTransform(fn func(dskey.Key) ([]byte, error)) Option {
+	return func(cfg *Config) {
+		cfg.KeyTransform = fn
+	}
+}
+
+// WithTombstones records the time every key is deleted at, so that
+// deletions can be replicated: see QueryTombstones, and PurgeTombstones to
+// remove old tombstones. Deleted keys are removed from the bucket as usual
+// and read as not found, while their tombstones are kept aside until they
+// are put again. Keys deleted through BoltTx leave no tombstone.
+func WithTombstones() Option {
+	return func(cfg *Config) {
+		cfg.Tombstones = true
+	}
+}
+
+// WithQueryCache caches the entries returned by up to entries recent
+// queries, holding up to bytes of keys and values, so that repeating a
+// query returns them without reading the db. The results of a query are
+// cached once they are read to the end. The whole cache is cleared when a
+// write to the bucket commits, and writes made through BoltTx or the bbolt
+// db directly are not seen. Queries with filters or order functions, and
+// results holding keys with an expiration, are not cached. Cached results
+// are copied, so it pays off for small results queried often.
+func WithQueryCache(entries, bytes int) Option {
+	return func(cfg *Config) {
+		cfg.QueryCacheEntries = entries
+		cfg.QueryCacheBytes = bytes
+	}
+}
@@ -0,0 +1,384 @@
+package dsbbolt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/daotl/go-datastore"
+	dskey "github.com/daotl/go-datastore/key"
+	"github.com/daotl/go-datastore/query"
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/bbolt"
+)
+
+func TestConfigFileMode(t *testing.T) {
+	tests := []struct {
+		name string
+		mode os.FileMode
+		want os.FileMode
+	}{
+		{"Default", 0, defaultFileMode},
+		{"Restrictive", 0600, 0600},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "bolt")
+			ds, err := NewDatastoreWithConfig(path, Config{KeyType: dskey.KeyTypeBytes, FileMode: tt.mode})
+			assert.NoError(t, err)
+			defer ds.Close()
+			fi, err := os.Stat(path)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, fi.Mode().Perm())
+		})
+	}
+}
+
+func TestNewDatastoreWithOptions(t *testing.T) {
+	open := func(t *testing.T, opts ...Option) (*Datastore, string) {
+		path := filepath.Join(t.TempDir(), "bolt")
+		ds, err := NewDatastoreWithOptions(path, opts...)
+		assert.NoError(t, err)
+		t.Cleanup(func() {
+			ds.Close()
+		})
+		return ds, path
+	}
+
+	t.Run("defaults", func(t *testing.T) {
+		ds, _ := open(t)
+		assert.Equal(t, [][]byte{defaultBucket}, ds.bucket)
+		assert.Equal(t, dskey.KeyTypeBytes, ds.ktype)
+		assert.Equal(t, bbolt.FreelistMapType, ds.bolt().FreelistType)
+	})
+	t.Run("WithBucket", func(t *testing.T) {
+		ds, _ := open(t, WithBucket([]byte("custom")))
+		assert.Equal(t, [][]byte{[]byte("custom")}, ds.bucket)
+	})
+	t.Run("WithKeyType", func(t *testing.T) {
+		_, err := NewDatastoreWithOptions(filepath.Join(t.TempDir(), "bolt"), WithKeyType(dskey.KeyTypeString))
+		assert.Equal(t, ErrKeyTypeNotMatch, err)
+	})
+	t.Run("WithBoltOptions", func(t *testing.T) {
+		ds, _ := open(t, WithBoltOptions(&bbolt.Options{NoSync: true}))
+		assert.True(t, ds.bolt().NoSync)
+	})
+	t.Run("WithFreelistType", func(t *testing.T) {
+		ds, _ := open(t, WithBoltOptions(&bbolt.Options{NoSync: true}), WithFreelistType(bbolt.FreelistArrayType))
+		assert.Equal(t, bbolt.FreelistArrayType, ds.bolt().FreelistType)
+		assert.True(t, ds.bolt().NoSync)
+	})
+	t.Run("WithFileMode", func(t *testing.T) {
+		_, path := open(t, WithFileMode(0600))
+		fi, err := os.Stat(path)
+		assert.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	})
+}
+
+func TestPageSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bolt")
+	ds, err := NewDatastoreWithOptions(path, WithPageSize(16<<10))
+	assert.NoError(t, err)
+	assert.Equal(t, 16<<10, ds.bolt().Info().PageSize)
+	assert.NoError(t, ds.Put(bg, dskey.NewBytesKeyFromString("foo"), []byte("bar")))
+	assert.NoError(t, ds.Close())
+
+	// the page size only applies when the file is created
+	ds, err = NewDatastoreWithOptions(path, WithPageSize(4<<10))
+	assert.NoError(t, err)
+	defer ds.Close()
+	assert.Equal(t, 16<<10, ds.bolt().Info().PageSize)
+	value, err := ds.Get(bg, dskey.NewBytesKeyFromString("foo"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("bar"), value)
+
+	for _, n := range []int{-4096, 256, 1000, 12 << 10} {
+		_, err := NewDatastoreWithOptions(filepath.Join(t.TempDir(), "bolt"), WithPageSize(n))
+		assert.True(t, errors.Is(err, ErrInvalidPageSize), "page size %d", n)
+	}
+}
+
+func TestFreelistChurn(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bolt")
+	ds, err := NewDatastoreWithOptions(path, WithFreelistType(bbolt.FreelistMapType))
+	assert.NoError(t, err)
+	keys := benchmarkKeys(2000)
+	value := bytes.Repeat([]byte{'v'}, 1000)
+	for round := 0; round < 5; round++ {
+		var entries []KeyValue
+		for _, k := range keys {
+			entries = append(entries, KeyValue{Key: k, Value: append([]byte{byte(round)}, value...)})
+		}
+		assert.NoError(t, ds.PutMany(bg, entries))
+		// free the pages of every other key
+		for i := round % 2; i < len(keys); i += 2 {
+			assert.NoError(t, ds.Delete(bg, keys[i]))
+		}
+	}
+	assert.NoError(t, ds.Close())
+
+	ds, err = NewDatastoreWithOptions(path, WithFreelistType(bbolt.FreelistMapType))
+	assert.NoError(t, err)
+	defer ds.Close()
+	n, err := ds.Count(bg, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, len(keys)/2, n)
+	for i, k := range keys {
+		v, err := ds.Get(bg, k)
+		if i%2 == 0 {
+			assert.Equal(t, datastore.ErrNotFound, err)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, append([]byte{4}, value...), v)
+	}
+	assert.NoError(t, ds.Check(bg))
+}
+
+func TestBucketPath(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bolt")
+	raw, err := NewDatastoreWithOptions(path, WithBucketPath([]byte("blocks"), []byte("raw")))
+	assert.NoError(t, err)
+	defer raw.Close()
+
+	for _, k := range []string{"a", "b", "c"} {
+		assert.NoError(t, raw.Put(bg, dskey.NewBytesKeyFromString(k), []byte("raw-"+k)))
+	}
+	assert.NoError(t, raw.bolt().Update(func(tx *bbolt.Tx) error {
+		cbor, err := tx.Bucket([]byte("blocks")).CreateBucket([]byte("cbor"))
+		if err != nil {
+			return err
+		}
+		return cbor.Put([]byte("a"), []byte("cbor-a"))
+	}))
+
+	v, err := raw.Get(bg, dskey.NewBytesKeyFromString("a"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("raw-a"), v)
+
+	rs, err := raw.Query(bg, query.Query{Prefix: dskey.EmptyBytesKey})
+	assert.NoError(t, err)
+	entries, err := rs.Rest()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(entries))
+
+	// the parent bucket only holds nested buckets, which queries skip
+	blocks, err := raw.WithBucket([]byte("blocks"))
+	assert.NoError(t, err)
+	rs, err = blocks.Query(bg, query.Query{})
+	assert.NoError(t, err)
+	entries, err = rs.Rest()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(entries))
+}
+
+func TestInclusiveRangeEnd(t *testing.T) {
+	keys := []string{"a", "b", "c", "c\x00", "d"}
+	rng := query.Range{Start: dskey.NewBytesKeyFromString("b"), End: dskey.NewBytesKeyFromString("c")}
+	tests := []struct {
+		name string
+		opts []Option
+		want []string
+	}{
+		{"exclusive", nil, []string{"b"}},
+		{"inclusive", []Option{WithInclusiveRangeEnd()}, []string{"b", "c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds, err := NewDatastoreWithOptions(filepath.Join(t.TempDir(), "bolt"), tt.opts...)
+			assert.NoError(t, err)
+			defer ds.Close()
+			for _, k := range keys {
+				assert.NoError(t, ds.Put(bg, dskey.NewBytesKeyFromString(k), []byte(k)))
+			}
+			for _, orders := range [][]query.Order{nil, {query.OrderByKeyDescending{}}} {
+				rs, err := ds.Query(bg, query.Query{Range: rng, Orders: orders})
+				assert.NoError(t, err)
+				entries, err := rs.Rest()
+				assert.NoError(t, err)
+				got := make([]string, len(entries))
+				for i, e := range entries {
+					got[i] = string(e.Key.Bytes())
+				}
+				if orders != nil {
+					for i, j := 0, len(got)-1; i < j; i, j = i+1, j-1 {
+						got[i], got[j] = got[j], got[i]
+					}
+				}
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+// compareBigEndian compares a and b as big-endian unsigned integers
+func compareBigEndian(a, b []byte) int {
+	a, b = bytes.TrimLeft(a, "\x00"), bytes.TrimLeft(b, "\x00")
+	if len(a) != len(b) {
+		if len(a) < len(b) {
+			return -1
+		}
+		return 1
+	}
+	return bytes.Compare(a, b)
+}
+
+func TestRangeComparator(t *testing.T) {
+	ds, err := NewDatastoreWithOptions(filepath.Join(t.TempDir(), "bolt"),
+		WithRangeComparator(compareBigEndian), WithInclusiveRangeEnd())
+	assert.NoError(t, err)
+	defer ds.Close()
+	// in byte order: 256, 1, 65536, 2, 255
+	for _, n := range [][]byte{{1, 0}, {1}, {1, 0, 0}, {2}, {0xff}} {
+		assert.NoError(t, ds.Put(bg, dskey.NewBytesKey(n), n))
+	}
+
+	rs, err := ds.Query(bg, query.Query{Range: query.Range{
+		Start: dskey.NewBytesKey([]byte{2}),
+		End:   dskey.NewBytesKey([]byte{1, 0}),
+	}})
+	assert.NoError(t, err)
+	entries, err := rs.Rest()
+	assert.NoError(t, err)
+	// 2, 255 and 256, in byte order
+	assert.Equal(t, []dskey.Key{
+		dskey.NewBytesKey([]byte{1, 0}),
+		dskey.NewBytesKey([]byte{2}),
+		dskey.NewBytesKey([]byte{0xff}),
+	}, query.EntryKeys(entries))
+
+	rs, err = ds.Query(bg, query.Query{
+		Range: query.Range{Start: dskey.NewBytesKey([]byte{2})},
+		Limit: 2,
+	})
+	assert.NoError(t, err)
+	entries, err = rs.Rest()
+	assert.NoError(t, err)
+	assert.Equal(t, []dskey.Key{
+		dskey.NewBytesKey([]byte{1, 0}),
+		dskey.NewBytesKey([]byte{1, 0, 0}),
+	}, query.EntryKeys(entries))
+}
+
+func TestMaxValueSize(t *testing.T) {
+	ds, err := NewDatastoreWithOptions(filepath.Join(t.TempDir(), "bolt"), WithMaxValueSize(16))
+	assert.NoError(t, err)
+	defer ds.Close()
+	key := dskey.NewBytesKeyFromString("key")
+
+	assert.NoError(t, ds.Put(bg, key, make([]byte, 16)))
+	assert.Equal(t, ErrValueTooLarge, ds.Put(bg, key, make([]byte, 17)))
+	assert.Equal(t, ErrValueTooLarge, ds.PutMany(bg, []KeyValue{{Key: key, Value: make([]byte, 17)}}))
+	batch, err := ds.Batch(bg)
+	assert.NoError(t, err)
+	assert.Equal(t, ErrValueTooLarge, batch.Put(bg, key, make([]byte, 17)))
+	txn, err := ds.NewTransaction(bg, false)
+	assert.NoError(t, err)
+	assert.Equal(t, ErrValueTooLarge, txn.Put(bg, key, make([]byte, 17)))
+	txn.Discard(bg)
+
+	large := dskey.NewBytesKey(make([]byte, bbolt.MaxKeySize+1))
+	assert.Equal(t, bbolt.ErrKeyTooLarge, ds.Put(bg, large, nil))
+
+	value, err := ds.Get(bg, key)
+	assert.NoError(t, err)
+	assert.Len(t, value, 16)
+}
+
+func TestKeyTransform(t *testing.T) {
+	errEmpty := errors.New("empty key")
+	hashKey := func(key dskey.Key) ([]byte, error) {
+		if len(key.Bytes()) == 0 {
+			return nil, errEmpty
+		}
+		sum := sha256.Sum256(key.Bytes())
+		return sum[:], nil
+	}
+	ds, err := NewDatastoreWithOptions(filepath.Join(t.TempDir(), "bolt"), WithKeyTransform(hashKey))
+	assert.NoError(t, err)
+	defer ds.Close()
+
+	long := dskey.NewBytesKey(bytes.Repeat([]byte("k"), bbolt.MaxKeySize+1))
+	assert.NoError(t, ds.Put(bg, long, []byte("long")))
+	value, err := ds.Get(bg, long)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("long"), value)
+	has, err := ds.Has(bg, long)
+	assert.NoError(t, err)
+	assert.True(t, has)
+	size, err := ds.GetSize(bg, long)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, size)
+
+	// the key is stored hashed
+	rs, err := ds.Query(bg, query.Query{KeysOnly: true})
+	assert.NoError(t, err)
+	entries, err := rs.Rest()
+	assert.NoError(t, err)
+	stored, _ := hashKey(long)
+	assert.Equal(t, []dskey.Key{dskey.NewBytesKey(stored)}, query.EntryKeys(entries))
+
+	assert.NoError(t, ds.Delete(bg, long))
+	_, err = ds.Get(bg, long)
+	assert.Equal(t, datastore.ErrNotFound, err)
+
+	empty := dskey.NewBytesKey([]byte{})
+	assert.Equal(t, errEmpty, ds.Put(bg, empty, nil))
+	_, err = ds.Get(bg, empty)
+	assert.Equal(t, errEmpty, err)
+	assert.Equal(t, errEmpty, ds.Delete(bg, empty))
+}
+
+func TestKeyTransformPrefix(t *testing.T) {
+	lower := func(key dskey.Key) ([]byte, error) {
+		return bytes.ToLower(key.Bytes()), nil
+	}
+	ds, err := NewDatastoreWithOptions(filepath.Join(t.TempDir(), "bolt"), WithKeyTransform(lower))
+	assert.NoError(t, err)
+	defer ds.Close()
+
+	assert.NoError(t, ds.Put(bg, dskey.NewBytesKeyFromString("Users/Alice"), []byte("a")))
+	assert.NoError(t, ds.Put(bg, dskey.NewBytesKeyFromString("users/BOB"), []byte("b")))
+	value, err := ds.Get(bg, dskey.NewBytesKeyFromString("USERS/alice"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("a"), value)
+
+	rs, err := ds.Query(bg, query.Query{Prefix: dskey.NewBytesKeyFromString("USERS/"), KeysOnly: true})
+	assert.NoError(t, err)
+	entries, err := rs.Rest()
+	assert.NoError(t, err)
+	assert.Equal(t, []dskey.Key{
+		dskey.NewBytesKeyFromString("users/alice"),
+		dskey.NewBytesKeyFromString("users/bob"),
+	}, query.EntryKeys(entries))
+	n, err := ds.Count(bg, dskey.NewBytesKeyFromString("Users/"))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+}
+
+func TestRecommendedOptions(t *testing.T) {
+	for _, c := range []struct {
+		expectedBytes int64
+		mmapSize      int
+	}{
+		{0, 32 << 10},
+		{10 << 10, 32 << 10},
+		{100 << 10, 256 << 10},
+		{300 << 20, 1 << 30},
+		{1 << 30, 2 << 30},
+		{5<<30 + 1, 11 << 30},
+	} {
+		opts := RecommendedOptions(c.expectedBytes)
+		assert.Equal(t, c.mmapSize, opts.InitialMmapSize, c.expectedBytes)
+		assert.False(t, opts.NoGrowSync)
+	}
+
+	ds, err := NewDatastore(filepath.Join(t.TempDir(), "bolt"), RecommendedOptions(1<<20), nil, dskey.KeyTypeBytes)
+	assert.NoError(t, err)
+	assert.NoError(t, ds.Put(bg, dskey.NewBytesKeyFromString("key"), []byte("value")))
+	assert.NoError(t, ds.Close())
+}
@@ -0,0 +1,63 @@
+package dsbbolt
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/daotl/go-datastore"
+	dskey "github.com/daotl/go-datastore/key"
+	"go.etcd.io/bbolt"
+)
+
+// Count returns the number of keys a Query with the same prefix would
+// return, a nil prefix counting all keys, without reading their values
+func (d *Datastore) Count(ctx context.Context, prefix dskey.Key) (int, error) {
+	ctx = orBackground(ctx)
+	if keyTypeMismatch(prefix, d.ktype) {
+		return 0, ErrKeyTypeNotMatch
+	}
+	start, limit, err := d.prefixBounds(prefix)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	err = d.bolt().View(func(tx *bbolt.Tx) error {
+		bucket, err := d.openBucket(tx)
+		if err != nil {
+			return err
+		}
+		if start == nil && !d.meta {
+			// the bucket stats count every key, unless nested buckets add
+			// their own keys to the count
+			if stats := bucket.Stats(); stats.BucketN == 1 {
+				n = stats.KeyN
+				return nil
+			}
+		}
+		now := time.Now()
+		c := bucket.Cursor()
+		k, v := c.First()
+		if start != nil {
+			k, v = c.Seek(start)
+		}
+		for steps := 0; k != nil && (limit == nil || bytes.Compare(k, limit) < 0); steps++ {
+			if steps%queryCheckInterval == 0 {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+			}
+			if v != nil {
+				if _, err := d.liveMeta(v, now); err != datastore.ErrNotFound {
+					n++
+				}
+			}
+			k, v = c.Next()
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
@@ -0,0 +1,51 @@
+package dsbbolt
+
+import (
+	"testing"
+
+	dskey "github.com/daotl/go-datastore/key"
+	"github.com/daotl/go-datastore/query"
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/bbolt"
+)
+
+func TestCount(t *testing.T) {
+	ds := newTestDatastore(t)
+	var entries []KeyValue
+	for _, k := range benchmarkKeys(1000) {
+		entries = append(entries, KeyValue{Key: k, Value: []byte("value")})
+	}
+	entries = append(entries, KeyValue{Key: dskey.NewBytesKeyFromString("other"), Value: []byte("value")})
+	assert.NoError(t, ds.PutMany(bg, entries))
+
+	queryCount := func(prefix dskey.Key) int {
+		results, err := ds.Query(bg, query.Query{Prefix: prefix, KeysOnly: true})
+		assert.NoError(t, err)
+		all, err := results.Rest()
+		assert.NoError(t, err)
+		return len(all)
+	}
+	for _, prefix := range []dskey.Key{
+		nil,
+		dskey.NewBytesKeyFromString("bench-"),
+		dskey.NewBytesKeyFromString("bench-0000012"),
+		dskey.NewBytesKeyFromString("missing"),
+	} {
+		n, err := ds.Count(bg, prefix)
+		assert.NoError(t, err)
+		assert.Equal(t, queryCount(prefix), n, "prefix %v", prefix)
+	}
+
+	// nested buckets are not counted
+	assert.NoError(t, ds.bolt().Update(func(tx *bbolt.Tx) error {
+		_, err := ds.bucketOf(tx).CreateBucket([]byte("nested"))
+		return err
+	}))
+	n, err := ds.Count(bg, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, len(entries), n)
+
+	n, err = ds.Scoped([]byte("bench-0000001")).Count(bg, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, n)
+}
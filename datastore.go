@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"os"
+	"time"
 
 	"github.com/daotl/go-datastore"
 	dskey "github.com/daotl/go-datastore/key"
@@ -12,103 +14,627 @@ import (
 	"go.etcd.io/bbolt"
 )
 
-var ErrKeyTypeNotMatch = errors.New("key type does not match")
+var (
+	ErrKeyTypeNotMatch    = errors.New("key type does not match")
+	ErrReadOnly           = errors.New("datastore is read-only")
+	ErrBucketNotFound     = errors.New("bucket not found")
+	ErrInvalidBucket      = errors.New("invalid bucket name")
+	ErrValueTooLarge      = errors.New("value too large")
+	ErrTxnClosed          = errors.New("transaction is closed")
+	ErrDBNotOwned         = errors.New("db is not owned by the datastore")
+	ErrScanBudgetExceeded = errors.New("query scan budget exceeded")
+	ErrInvalidPageSize    = errors.New("invalid page size")
+)
 
 var (
 	defaultBucket                        = []byte("datastore")
 	_             datastore.TxnDatastore = (*Datastore)(nil)
 )
 
+// metaBucket is the top-level bucket reserved for the metadata of the
+// package itself
+var metaBucket = []byte("__dsbbolt__")
+
+// queryCheckInterval is the number of cursor steps between two checks of
+// the query context
+const queryCheckInterval = 256
+
 // Datastore implements a daotl datastore
-// backed by a bbolt db, only byteskey is supported now
+// backed by a bbolt db, only byteskey is supported now.
+// Its methods treat a nil context as context.Background(). Writes waiting
+// for another write transaction to end return the error of their context
+// if it is done before their own transaction can begin.
 type Datastore struct {
-	db     *bbolt.DB
-	bucket []byte // only use one bucket?
+	db     *dbHandle // shared with the datastores returned by WithBucket
+	bucket [][]byte  // path of nested buckets, keys are stored in the last one
 	ktype  dskey.KeyType
+	ownsDB bool // whether Close should close db
+
+	scope        []byte                // prefix of the stored keys, see Scoped
+	inclusiveEnd bool                  // whether query.Range.End is part of the range
+	rangeCmp     func(a, b []byte) int // nil to compare range bounds bytewise
+	metrics      MetricsHook           // nil when metrics are disabled
+	codecs       []valueCodec
+	meta         bool // whether stored values have a metadata header
+	reaper       *reaper
+	maxRetries   int
+	retryBackoff time.Duration
+	maxValueSize int          // 0 when values are only limited by bbolt
+	readPool     *readTxPool  // nil when reads open their own transaction
+	bloom        *bloomFilter // nil when lookups always read the db
+	logger       Logger
+	index        IndexFunc                       // nil when there is no secondary index
+	keyTransform func(dskey.Key) ([]byte, error) // nil when keys are stored as is
+	tombstones   bool
+	queryCache   *queryCache // nil when query results are not cached
 }
 
-// Sync is not required for boltdb, so no op
-func (d *Datastore) Sync(ctx context.Context, prefix dskey.Key) error {
+// Sync flushes the db file to disk when the db was opened with NoSync,
+// otherwise bbolt syncs on every commit and Sync is a no-op. The whole file
+// is synced whatever the prefix, and the writes counted for WithSyncEvery
+// start over.
+func (d *Datastore) Sync(ctx context.Context, prefix dskey.Key) (err error) {
+	if !d.bolt().NoSync || d.bolt().IsReadOnly() {
+		return nil
+	}
+	if d.metrics != nil {
+		defer d.observe(OpSync, time.Now(), &err)
+	}
+	if err = d.bolt().Sync(); err != nil {
+		return err
+	}
+	d.db.resetWrites()
 	return nil
 }
 
 // NewDatastore is used to instantiate our datastore
 func NewDatastore(path string, opts *bbolt.Options, bucket []byte, keytype dskey.KeyType) (*Datastore, error) {
-	if keytype != dskey.KeyTypeBytes {
+	return NewDatastoreWithConfig(path, Config{
+		BoltOptions: opts,
+		Bucket:      bucket,
+		KeyType:     keytype,
+	})
+}
+
+// NewDatastoreWithConfig instantiates a datastore stored at path with cfg
+func NewDatastoreWithConfig(path string, cfg Config) (*Datastore, error) {
+	if cfg.KeyType != dskey.KeyTypeBytes {
 		return nil, ErrKeyTypeNotMatch
 	}
-	db, err := bbolt.Open(path, os.FileMode(0640), opts)
+	if n := cfg.PageSize; n != 0 && (n < 512 || n&(n-1) != 0) {
+		return nil, fmt.Errorf("%w %d: must be a power of two of at least 512", ErrInvalidPageSize, n)
+	}
+	mode := cfg.FileMode
+	if mode == 0 {
+		mode = defaultFileMode
+	}
+	opts := cfg.boltOptions()
+	db, err := openWithRetry(path, mode, opts, cfg.OpenRetries, cfg.OpenRetryDelay)
 	if err != nil {
 		return nil, err
 	}
-	if bucket == nil {
-		bucket = defaultBucket
+	bucketPath := cfg.BucketPath
+	if len(bucketPath) == 0 && cfg.Bucket != nil {
+		bucketPath = [][]byte{cfg.Bucket}
 	}
-	if err := db.Update(func(tx *bbolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists(bucket)
-		return err
-	}); err != nil {
+	ds, err := newDatastore(db, bucketPath, cfg.KeyType)
+	if err != nil {
 		db.Close()
 		return nil, err
 	}
-	ds := &Datastore{db: db, bucket: bucket, ktype: keytype}
+	ds.ownsDB = true
+	ds.db.path, ds.db.mode, ds.db.opts = path, mode, opts
+	ds.inclusiveEnd = cfg.InclusiveRangeEnd
+	ds.rangeCmp = cfg.RangeComparator
+	ds.maxValueSize = cfg.MaxValueSize
+	if cfg.SyncEvery > 0 && db.NoSync {
+		ds.db.syncs = &syncPolicy{n: cfg.SyncEvery}
+	}
+	ds.metrics = cfg.MetricsHook
+	if cfg.Logger != nil {
+		ds.logger = cfg.Logger
+	}
+	ds.meta = cfg.Metadata
+	ds.index = cfg.Index
+	ds.keyTransform = cfg.KeyTransform
+	ds.tombstones = cfg.Tombstones
+	if cfg.QueryCacheEntries > 0 && cfg.QueryCacheBytes > 0 {
+		ds.queryCache = newQueryCache(cfg.QueryCacheEntries, cfg.QueryCacheBytes)
+	}
+	ds.maxRetries, ds.retryBackoff = cfg.MaxRetries, cfg.RetryBackoff
+	if cfg.Compressor != nil {
+		ds.codecs = append(ds.codecs, compressionCodec{cfg.Compressor})
+	}
+	if cfg.AEAD != nil {
+		ds.codecs = append(ds.codecs, encryptionCodec{cfg.AEAD})
+	}
+	if cfg.ValueChecksum != nil {
+		ds.codecs = append(ds.codecs, checksumCodec{cfg.ValueChecksum})
+	}
+	if cfg.BloomFilterKeys > 0 {
+		fpRate := cfg.BloomFalsePositiveRate
+		if fpRate <= 0 {
+			fpRate = defaultBloomFalsePositiveRate
+		}
+		bloom := newBloomFilter(cfg.BloomFilterKeys, fpRate)
+		if err := ds.loadBloomFilter(bloom); err != nil {
+			db.Close()
+			return nil, err
+		}
+		ds.bloom = bloom
+	}
+	if cfg.ReapInterval > 0 {
+		if err := ds.StartReaper(cfg.ReapInterval); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	if cfg.ReadTxPoolSize > 0 {
+		maxAge := cfg.ReadTxMaxAge
+		if maxAge <= 0 {
+			maxAge = defaultReadTxMaxAge
+		}
+		ds.readPool = newReadTxPool(db, cfg.ReadTxPoolSize, maxAge, cfg.ReadTxMaxReads)
+	}
 	return ds, nil
 }
 
+// NewDatastoreWithDB instantiates a datastore on top of an already opened
+// bbolt db, creating the bucket if absent. The db remains owned by the
+// caller: Close on the returned Datastore does not close it, and the caller
+// must not close the db while the Datastore is still in use.
+func NewDatastoreWithDB(db *bbolt.DB, bucket []byte, keytype dskey.KeyType) (*Datastore, error) {
+	if keytype != dskey.KeyTypeBytes {
+		return nil, ErrKeyTypeNotMatch
+	}
+	var bucketPath [][]byte
+	if bucket != nil {
+		bucketPath = [][]byte{bucket}
+	}
+	return newDatastore(db, bucketPath, keytype)
+}
+
+// NewMultiBucketDatastore opens the db stored at path once and returns a
+// Datastore for each of the top-level buckets, keyed by bucket name, all
+// sharing the db like the datastores returned by WithBucket. The buckets
+// that do not exist are all created in the same transaction. The returned
+// function closes the db, the Close of the datastores does not.
+func NewMultiBucketDatastore(path string, opts *bbolt.Options, buckets [][]byte, keytype dskey.KeyType) (map[string]*Datastore, func() error, error) {
+	if keytype != dskey.KeyTypeBytes {
+		return nil, nil, ErrKeyTypeNotMatch
+	}
+	if len(buckets) == 0 {
+		return nil, nil, fmt.Errorf("%w: no buckets", ErrInvalidBucket)
+	}
+	bucketPaths := make([][][]byte, len(buckets))
+	for i, name := range buckets {
+		bucketPaths[i] = [][]byte{copyBytes(name)}
+		if err := validateBucketPath(bucketPaths[i]); err != nil {
+			return nil, nil, err
+		}
+	}
+	cfg := Config{BoltOptions: opts}
+	opts = cfg.boltOptions()
+	db, err := bbolt.Open(path, defaultFileMode, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := ensureBuckets(db, bucketPaths, keytype); err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	root := &Datastore{db: newDBHandle(db), ktype: keytype, ownsDB: true, reaper: &reaper{}, logger: nopLogger{}}
+	root.db.path, root.db.mode, root.db.opts = path, defaultFileMode, opts
+	views := make(map[string]*Datastore, len(buckets))
+	for _, bucketPath := range bucketPaths {
+		view := *root
+		view.bucket = bucketPath
+		view.ownsDB = false
+		view.reaper = &reaper{}
+		views[string(bucketPath[0])] = &view
+	}
+	return views, root.Close, nil
+}
+
+func newDatastore(db *bbolt.DB, bucketPath [][]byte, keytype dskey.KeyType) (*Datastore, error) {
+	if len(bucketPath) == 0 {
+		bucketPath = [][]byte{defaultBucket}
+	}
+	if err := validateBucketPath(bucketPath); err != nil {
+		return nil, err
+	}
+	if err := ensureBucket(db, bucketPath, keytype); err != nil {
+		return nil, err
+	}
+	return &Datastore{db: newDBHandle(db), bucket: bucketPath, ktype: keytype, reaper: &reaper{}, logger: nopLogger{}}, nil
+}
+
+// validateBucketPath checks that the buckets of bucketPath have a name and
+// that the top-level one is not reserved
+func validateBucketPath(bucketPath [][]byte) error {
+	for _, name := range bucketPath {
+		if len(name) == 0 {
+			return fmt.Errorf("%w: empty name", ErrInvalidBucket)
+		}
+	}
+	if bytes.Equal(bucketPath[0], metaBucket) {
+		return fmt.Errorf("%w: %q is reserved", ErrInvalidBucket, metaBucket)
+	}
+	return nil
+}
+
+// ensureBucket creates the nested buckets of bucketPath if they do not exist,
+// a read-only db cannot create buckets so it must already contain them. The
+// key type of the bucket is recorded when it is created, and later checked
+// to be ktype.
+func ensureBucket(db *bbolt.DB, bucketPath [][]byte, ktype dskey.KeyType) error {
+	return ensureBuckets(db, [][][]byte{bucketPath}, ktype)
+}
+
+// ensureBuckets is ensureBucket for each of bucketPaths, all in the same
+// transaction
+func ensureBuckets(db *bbolt.DB, bucketPaths [][][]byte, ktype dskey.KeyType) error {
+	if db.IsReadOnly() {
+		return db.View(func(tx *bbolt.Tx) error {
+			for _, bucketPath := range bucketPaths {
+				if lookupBucket(tx, bucketPath) == nil {
+					return ErrBucketNotFound
+				}
+				if err := checkKeyType(tx, bucketPath, ktype); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	return db.Update(func(tx *bbolt.Tx) error {
+		for _, bucketPath := range bucketPaths {
+			b, err := tx.CreateBucketIfNotExists(bucketPath[0])
+			for _, name := range bucketPath[1:] {
+				if err != nil {
+					return err
+				}
+				b, err = b.CreateBucketIfNotExists(name)
+			}
+			if err != nil {
+				return err
+			}
+			if err := checkKeyType(tx, bucketPath, ktype); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// lookupBucket returns the last bucket of bucketPath, or nil if any bucket
+// along the path does not exist
+func lookupBucket(tx *bbolt.Tx, bucketPath [][]byte) *bbolt.Bucket {
+	b := tx.Bucket(bucketPath[0])
+	for _, name := range bucketPath[1:] {
+		if b == nil {
+			return nil
+		}
+		b = b.Bucket(name)
+	}
+	return b
+}
+
+// bucketOf returns the bucket the datastore keys are stored in within tx,
+// or nil if it does not exist
+func (d *Datastore) bucketOf(tx *bbolt.Tx) *bbolt.Bucket {
+	return lookupBucket(tx, d.bucket)
+}
+
+// openBucket returns the bucket the datastore keys are stored in within
+// tx, or ErrBucketNotFound if it was deleted
+func (d *Datastore) openBucket(tx *bbolt.Tx) (*bbolt.Bucket, error) {
+	if b := d.bucketOf(tx); b != nil {
+		return b, nil
+	}
+	return nil, ErrBucketNotFound
+}
+
+// WithBucket returns a Datastore that shares the underlying bbolt db with d
+// but stores its keys in the top-level bucket with the given name, creating
+// the bucket if it does not exist. Closing the returned Datastore does not
+// close the shared db, it is closed when d is closed.
+func (d *Datastore) WithBucket(name []byte) (*Datastore, error) {
+	bucketPath := [][]byte{copyBytes(name)}
+	if err := validateBucketPath(bucketPath); err != nil {
+		return nil, err
+	}
+	if err := ensureBucket(d.bolt(), bucketPath, d.ktype); err != nil {
+		return nil, err
+	}
+	view := *d
+	view.bucket = bucketPath
+	view.ownsDB = false
+	view.reaper = &reaper{}
+	// the filter only holds the keys of the parent bucket
+	view.bloom = nil
+	view.index = nil
+	view.queryCache = nil
+	return &view, nil
+}
+
+// Bucket returns a copy of the name of the bucket the datastore stores its
+// keys in, the innermost one when it is nested, see BucketPath
+func (d *Datastore) Bucket() []byte {
+	return copyBytes(d.bucket[len(d.bucket)-1])
+}
+
+// BucketPath returns a copy of the names of the nested buckets leading to
+// the bucket of the datastore, outermost first
+func (d *Datastore) BucketPath() [][]byte {
+	path := make([][]byte, len(d.bucket))
+	for i, name := range d.bucket {
+		path[i] = copyBytes(name)
+	}
+	return path
+}
+
+// KeyType returns the type of the keys of the datastore
+func (d *Datastore) KeyType() dskey.KeyType {
+	return d.ktype
+}
+
 // Put is used to store something in our underlying datastore
-func (d *Datastore) Put(ctx context.Context, key dskey.Key, value []byte) error {
+func (d *Datastore) Put(ctx context.Context, key dskey.Key, value []byte) (err error) {
+	if d.metrics != nil {
+		defer d.observe(OpPut, time.Now(), &err)
+	}
+	if key.KeyType() != d.ktype {
+		return ErrKeyTypeNotMatch
+	}
+	if d.bolt().IsReadOnly() {
+		return ErrReadOnly
+	}
+	k, err := d.storedKey(key)
+	if err != nil {
+		return err
+	}
+	if err := d.checkEntry(k, value); err != nil {
+		return err
+	}
+	if value, err = d.encodeValue(k, value); err != nil {
+		return err
+	}
+	return d.update(ctx, func(tx *bbolt.Tx) error {
+		bucket, err := d.openBucket(tx)
+		if err != nil {
+			return err
+		}
+		return d.putStored(bucket, k, value)
+	})
+}
+
+// PutCoalesced stores value under key like Put, but through bbolt's
+// DB.Batch: concurrent calls are coalesced into shared write transactions,
+// trading a delay of up to the MaxBatchDelay of the db for much fewer
+// commits and fsyncs when many goroutines write at once. A failing call may
+// make other calls of the same batch rerun in a transaction of their own.
+// Like the other writes, it waits for the open write transactions of the
+// datastore first, or returns the error of ctx if it is done before; the
+// write token is not held during the batch, so that calls still coalesce.
+func (d *Datastore) PutCoalesced(ctx context.Context, key dskey.Key, value []byte) (err error) {
+	if d.metrics != nil {
+		defer d.observe(OpPut, time.Now(), &err)
+	}
 	if key.KeyType() != d.ktype {
 		return ErrKeyTypeNotMatch
 	}
-	return d.db.Update(func(tx *bbolt.Tx) error {
-		return tx.Bucket(d.bucket).Put(key.Bytes(), value)
+	if d.bolt().IsReadOnly() {
+		return ErrReadOnly
+	}
+	k, err := d.storedKey(key)
+	if err != nil {
+		return err
+	}
+	if err := d.checkEntry(k, value); err != nil {
+		return err
+	}
+	if value, err = d.encodeValue(k, value); err != nil {
+		return err
+	}
+	ctx = orBackground(ctx)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := d.db.lockWrites(ctx); err != nil {
+		return err
+	}
+	d.db.unlockWrites()
+	d.releaseReads()
+	// fn may run more than once, it must only depend on the transaction
+	return d.bolt().Batch(func(tx *bbolt.Tx) error {
+		bucket, err := d.openBucket(tx)
+		if err != nil {
+			return err
+		}
+		return d.putStored(bucket, k, value)
 	})
 }
 
+// checkEntry returns an error if the stored key k or value cannot be stored,
+// bbolt.ErrKeyTooLarge for a key over bbolt.MaxKeySize or ErrValueTooLarge
+// for a value over the size set with WithMaxValueSize
+func (d *Datastore) checkEntry(k, value []byte) error {
+	if len(k) > bbolt.MaxKeySize {
+		return bbolt.ErrKeyTooLarge
+	}
+	if d.maxValueSize > 0 && len(value) > d.maxValueSize {
+		return ErrValueTooLarge
+	}
+	return nil
+}
+
 // Delete removes a key/value pair from our datastore
-func (d *Datastore) Delete(ctx context.Context, key dskey.Key) error {
+func (d *Datastore) Delete(ctx context.Context, key dskey.Key) (err error) {
+	if d.metrics != nil {
+		defer d.observe(OpDelete, time.Now(), &err)
+	}
 	if key.KeyType() != d.ktype {
 		return ErrKeyTypeNotMatch
 	}
-	return d.db.Update(func(tx *bbolt.Tx) error {
-		return tx.Bucket(d.bucket).Delete(key.Bytes())
+	if d.bolt().IsReadOnly() {
+		return ErrReadOnly
+	}
+	k, err := d.storedKey(key)
+	if err != nil {
+		return err
+	}
+	return d.update(ctx, func(tx *bbolt.Tx) error {
+		bucket, err := d.openBucket(tx)
+		if err != nil {
+			return err
+		}
+		return d.deleteStored(bucket, k)
 	})
 }
 
-// Get is used to retrieve a value from the datastore
-func (d *Datastore) Get(ctx context.Context, key dskey.Key) ([]byte, error) {
+// Get is used to retrieve a value from the datastore. It reflects every
+// write committed before it is called, unless a read transaction pool or a
+// Bloom filter is configured: see WithReadTxPool and WithBloomFilter for
+// the writes they may miss, and GetFresh for a read that never does.
+func (d *Datastore) Get(ctx context.Context, key dskey.Key) (value []byte, err error) {
+	if d.metrics != nil {
+		defer d.observe(OpGet, time.Now(), &err)
+	}
 	if key.KeyType() != d.ktype {
 		return nil, ErrKeyTypeNotMatch
 	}
 	var result []byte
-	if err := d.db.View(func(tx *bbolt.Tx) error {
-		data := tx.Bucket(d.bucket).Get(key.Bytes())
-		if data == nil {
-			return datastore.ErrNotFound
-		}
-		result = copyBytes(data)
-		return nil
+	if err := d.getStored(key, func(k, data []byte) (err error) {
+		result, err = d.decodeValue(k, data)
+		return err
 	}); err != nil {
 		return nil, err
 	}
 	return result, nil
 }
 
-// Has returns whether the key is present in our datastore
-func (d *Datastore) Has(ctx context.Context, key dskey.Key) (bool, error) {
+// GetView runs fn on the value of key without copying it out of the memory
+// map of the db, for callers that do not keep the value, e.g. to serialize
+// it right away. The value is only valid until fn returns and must neither
+// be retained nor modified. Compressed or encrypted values are decoded
+// into a new buffer first. The error of fn is returned as is.
+func (d *Datastore) GetView(ctx context.Context, key dskey.Key, fn func(value []byte) error) (err error) {
+	if d.metrics != nil {
+		defer d.observe(OpGet, time.Now(), &err)
+	}
+	if key.KeyType() != d.ktype {
+		return ErrKeyTypeNotMatch
+	}
+	return d.getStored(key, func(k, data []byte) error {
+		value, err := d.viewValue(k, data)
+		if err != nil {
+			return err
+		}
+		return fn(value)
+	})
+}
+
+// GetFresh is Get bypassing the read transaction pool and the Bloom
+// filter, if configured: it always opens a new read transaction, so that
+// it reflects the latest committed state of the db, e.g. after a write
+// made through BoltTx or the bbolt db directly.
+func (d *Datastore) GetFresh(ctx context.Context, key dskey.Key) (value []byte, err error) {
+	if d.metrics != nil {
+		defer d.observe(OpGet, time.Now(), &err)
+	}
+	if key.KeyType() != d.ktype {
+		return nil, ErrKeyTypeNotMatch
+	}
+	k, err := d.storedKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.lookupStored(k, d.bolt().View, func(k, data []byte) (err error) {
+		value, err = d.decodeValue(k, data)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// getStored runs fn on the stored value of key within a read transaction,
+// the value is only valid until fn returns. Expired values are not found
+// and get deleted.
+func (d *Datastore) getStored(key dskey.Key, fn func(k, data []byte) error) error {
+	k, err := d.storedKey(key)
+	if err != nil {
+		return err
+	}
+	if d.bloom != nil && !d.bloom.mayContain(k) {
+		return datastore.ErrNotFound
+	}
+	return d.lookupStored(k, d.view, fn)
+}
+
+// lookupStored is getStored for the stored key k, within the read
+// transaction of view
+func (d *Datastore) lookupStored(k []byte, view func(func(*bbolt.Tx) error) error, fn func(k, data []byte) error) error {
+	expired := false
+	err := view(func(tx *bbolt.Tx) error {
+		bucket, err := d.openBucket(tx)
+		if err != nil {
+			return err
+		}
+		data := bucket.Get(k)
+		if data == nil {
+			return datastore.ErrNotFound
+		}
+		if _, err := d.liveMeta(data, time.Now()); err != nil {
+			expired = err == datastore.ErrNotFound
+			return err
+		}
+		return fn(k, data)
+	})
+	if expired {
+		d.deleteExpired(k)
+	}
+	return err
+}
+
+// Has returns whether the key is present in our datastore, without
+// reading its value. It may miss writes like Get.
+func (d *Datastore) Has(ctx context.Context, key dskey.Key) (exists bool, err error) {
+	if d.metrics != nil {
+		defer d.observe(OpHas, time.Now(), &err)
+	}
 	if key.KeyType() != d.ktype {
 		return false, ErrKeyTypeNotMatch
 	}
-	return datastore.GetBackedHas(ctx, d, key)
+	switch err := d.getStored(key, func(_, _ []byte) error { return nil }); err {
+	case nil:
+		return true, nil
+	case datastore.ErrNotFound:
+		return false, nil
+	default:
+		return false, err
+	}
 }
 
-// GetSize returns the size of the value referenced by key
-func (d *Datastore) GetSize(ctx context.Context, key dskey.Key) (int, error) {
+// GetSize returns the size of the value referenced by key, measured on
+// the stored value without copying it like the Size of KeysOnly queries:
+// values both compressed and encrypted are decrypted, and checksums are not
+// verified.
+func (d *Datastore) GetSize(ctx context.Context, key dskey.Key) (size int, err error) {
+	if d.metrics != nil {
+		defer d.observe(OpGetSize, time.Now(), &err)
+	}
 	if key.KeyType() != d.ktype {
 		return -1, ErrKeyTypeNotMatch
 	}
-
-	return datastore.GetBackedSize(ctx, d, key)
+	if err := d.getStored(key, func(k, data []byte) (err error) {
+		size, err = d.valueSize(k, data)
+		return err
+	}); err != nil {
+		return -1, err
+	}
+	return size, nil
 }
 
 // return true if type mismatch
@@ -119,33 +645,54 @@ func keyTypeMismatch(q dskey.Key, keyType dskey.KeyType) bool {
 	return false
 }
 
-func queryWithCursor(cursor *bbolt.Cursor, q query.Query, ktype dskey.KeyType, closef func() error) (query.Results, error) {
+// queryOptions are the settings of a query that query.Query cannot carry
+type queryOptions struct {
+	after   dskey.Key // if not nil, start strictly after this key
+	maxScan int       // if not 0, fail once more keys have to be read
+	since   time.Time // if not zero, only keys written after it
+}
+
+// queryWithCursor runs q over cursor according to opts. closef is called
+// when the results are closed.
+func (d *Datastore) queryWithCursor(ctx context.Context, cursor *bbolt.Cursor, q query.Query, opts queryOptions, closef func() error) (query.Results, error) {
+	ctx = orBackground(ctx)
+	after := opts.after
+	ktype := d.ktype
 	if keyTypeMismatch(q.Prefix, ktype) ||
+		keyTypeMismatch(after, ktype) ||
 		keyTypeMismatch(q.Range.Start, ktype) ||
 		keyTypeMismatch(q.Range.End, ktype) {
 		return nil, ErrKeyTypeNotMatch
 	}
 
 	qNaive := q // copy of q
-	var cursorStart []byte
-	var cursorEnd []byte
+	if q.Prefix != nil && ktype == dskey.KeyTypeString {
+		// not supported now
+		return nil, ErrKeyTypeNotMatch
+	}
+	cursorStart, cursorEnd, err := d.prefixBounds(q.Prefix)
+	if err != nil {
+		return nil, err
+	}
 
-	if q.Prefix != nil {
-		switch ktype {
-		case dskey.KeyTypeBytes:
-			cursorStart, cursorEnd = bytesPrefix(q.Prefix.Bytes())
-		case dskey.KeyTypeString:
-			// not supported now
-			return nil, ErrKeyTypeNotMatch
+	// a custom comparator does not follow the byte order of the cursor, so
+	// the range can only be checked key by key within the prefix
+	var inRange func(k []byte) bool
+	if d.rangeCmp != nil && (q.Range.Start != nil || q.Range.End != nil) {
+		if inRange, err = d.rangeFilter(q.Range); err != nil {
+			return nil, err
 		}
 	}
 
 	// cursor starting from max(prefix, range.start)
-	if q.Range.Start != nil {
+	if q.Range.Start != nil && inRange == nil {
 		rangeStartKey := q.Range.Start
 		switch ktype {
 		case dskey.KeyTypeBytes:
-			rangeStartBytes := rangeStartKey.Bytes()
+			rangeStartBytes, err := d.storedKey(rangeStartKey)
+			if err != nil {
+				return nil, err
+			}
 			if len(cursorStart) == 0 || bytes.Compare(cursorStart, rangeStartBytes) < 0 {
 				cursorStart = rangeStartBytes
 			}
@@ -155,12 +702,31 @@ func queryWithCursor(cursor *bbolt.Cursor, q query.Query, ktype dskey.KeyType, c
 		}
 	}
 
+	// after+0x00 is the smallest key greater than after
+	if after != nil {
+		afterBytes, err := d.storedKey(after)
+		if err != nil {
+			return nil, err
+		}
+		afterBytes = append(afterBytes, 0x00)
+		if len(cursorStart) == 0 || bytes.Compare(cursorStart, afterBytes) < 0 {
+			cursorStart = afterBytes
+		}
+	}
+
 	// cursor end with min(prefix limit, range.end)
-	if q.Range.End != nil {
+	if q.Range.End != nil && inRange == nil {
 		rangeEndKey := q.Range.End
 		switch ktype {
 		case dskey.KeyTypeBytes:
-			rangeEndBytes := rangeEndKey.Bytes()
+			rangeEndBytes, err := d.storedKey(rangeEndKey)
+			if err != nil {
+				return nil, err
+			}
+			if d.inclusiveEnd {
+				// End+0x00 is the smallest key greater than End
+				rangeEndBytes = append(rangeEndBytes, 0x00)
+			}
 			if len(cursorEnd) == 0 || bytes.Compare(rangeEndBytes, cursorEnd) < 0 {
 				cursorEnd = rangeEndBytes
 			}
@@ -170,6 +736,23 @@ func queryWithCursor(cursor *bbolt.Cursor, q query.Query, ktype dskey.KeyType, c
 		}
 	}
 
+	// key comparisons and ranges among the filters narrow the cursor bounds
+	// like Range, so they need not be applied to every key
+	qNaive.Filters = nil
+	for _, f := range q.Filters {
+		start, end, ok := d.filterBounds(f)
+		if !ok {
+			qNaive.Filters = append(qNaive.Filters, f)
+			continue
+		}
+		if start != nil && (len(cursorStart) == 0 || bytes.Compare(cursorStart, start) < 0) {
+			cursorStart = start
+		}
+		if end != nil && (len(cursorEnd) == 0 || bytes.Compare(end, cursorEnd) < 0) {
+			cursorEnd = end
+		}
+	}
+
 	firstKv := func() ([]byte, []byte) {
 		if len(cursorStart) == 0 {
 			return cursor.First()
@@ -217,22 +800,74 @@ func queryWithCursor(cursor *bbolt.Cursor, q query.Query, ktype dskey.KeyType, c
 	qNaive.Prefix = nil
 	qNaive.Range = query.Range{}
 
+	// without filters or orders left to apply naively, the cursor can skip
+	// the offset and stop at the limit itself
+	skip, limit := 0, 0
+	if len(qNaive.Filters) == 0 && len(qNaive.Orders) == 0 {
+		skip, limit = q.Offset, q.Limit
+		qNaive.Offset, qNaive.Limit = 0, 0
+	}
+
+	now := time.Now()
 	started := false
+	done := false
+	steps := 0
+	scanned := 0
+	emitted := 0
 	results := query.ResultsFromIterator(q, query.Iterator{
 		Next: func() (query.Result, bool) {
-			var k, v []byte
-			if !started {
-				k, v = firstKv()
-				started = true
-			} else {
-				k, v = next()
-			}
-			if validate(k) == false {
-				return query.Result{}, false
+			for {
+				if done || limit > 0 && emitted >= limit {
+					done = true
+					return query.Result{}, false
+				}
+				if steps%queryCheckInterval == 0 {
+					if err := ctx.Err(); err != nil {
+						done = true
+						return query.Result{Error: err}, true
+					}
+				}
+				steps++
+				var k, v []byte
+				if !started {
+					k, v = firstKv()
+					started = true
+				} else {
+					k, v = next()
+				}
+				// nested buckets show up as keys with a nil value, skip them
+				for v == nil && k != nil && cursor.Bucket().Bucket(k) != nil {
+					k, v = next()
+				}
+				if validate(k) == false {
+					done = true
+					return query.Result{}, false
+				}
+				if scanned++; opts.maxScan > 0 && scanned > opts.maxScan {
+					done = true
+					return query.Result{Error: ErrScanBudgetExceeded}, true
+				}
+				m, err := d.liveMeta(v, now)
+				if err == datastore.ErrNotFound {
+					continue
+				}
+				if !opts.since.IsZero() && !m.Written.After(opts.since) {
+					continue
+				}
+				if inRange != nil && !inRange(d.userKey(k)) {
+					continue
+				}
+				if skip > 0 {
+					skip--
+					continue
+				}
+				emitted++
+				entry, err := d.toQueryEntry(k, v, q.KeysOnly)
+				if err != nil {
+					return query.Result{Error: err}, true
+				}
+				return query.Result{Entry: entry}, true
 			}
-			return query.Result{
-				Entry: toQueryEntry(k, v, q.KeysOnly),
-			}, true
 		},
 		Close: func() error {
 			if closef != nil {
@@ -246,33 +881,291 @@ func queryWithCursor(cursor *bbolt.Cursor, q query.Query, ktype dskey.KeyType, c
 	return results, nil
 }
 
+// filterBounds returns the stored keys bounding the keys matched by a key
+// comparison or key range filter, start included and end excluded, a nil
+// bound meaning unbounded. ok is false for other filters, which cannot be
+// turned into cursor bounds.
+func (d *Datastore) filterBounds(f query.Filter) (start, end []byte, ok bool) {
+	switch pf := f.(type) {
+	case *query.FilterKeyCompare:
+		f = *pf
+	case *query.FilterKeyRange:
+		f = *pf
+	}
+	switch f := f.(type) {
+	case query.FilterKeyCompare:
+		if f.Key == nil || keyTypeMismatch(f.Key, d.ktype) {
+			return nil, nil, false
+		}
+		// k+0x00 is the smallest key greater than k
+		k, err := d.storedKey(f.Key)
+		if err != nil {
+			return nil, nil, false
+		}
+		switch f.Op {
+		case query.Equal:
+			start, end = k, append(copyBytes(k), 0x00)
+		case query.GreaterThan:
+			start = append(k, 0x00)
+		case query.GreaterThanOrEqual:
+			start = k
+		case query.LessThan:
+			end = k
+		case query.LessThanOrEqual:
+			end = append(k, 0x00)
+		default:
+			return nil, nil, false
+		}
+	case query.FilterKeyRange:
+		if keyTypeMismatch(f.Range.Start, d.ktype) || keyTypeMismatch(f.Range.End, d.ktype) {
+			return nil, nil, false
+		}
+		var err error
+		if f.Range.Start != nil {
+			if start, err = d.storedKey(f.Range.Start); err != nil {
+				return nil, nil, false
+			}
+		}
+		if f.Range.End != nil {
+			if end, err = d.storedKey(f.Range.End); err != nil {
+				return nil, nil, false
+			}
+		}
+	default:
+		return nil, nil, false
+	}
+	if end != nil && len(end) == 0 {
+		// no key is below the empty key, but an empty cursor end is unbounded
+		return nil, nil, false
+	}
+	return start, end, true
+}
+
+// rangeFilter returns whether a key is within r according to d.rangeCmp
+func (d *Datastore) rangeFilter(r query.Range) (func(k []byte) bool, error) {
+	var start, end []byte
+	var err error
+	if r.Start != nil {
+		if start, err = d.transformedKey(r.Start); err != nil {
+			return nil, err
+		}
+	}
+	if r.End != nil {
+		if end, err = d.transformedKey(r.End); err != nil {
+			return nil, err
+		}
+	}
+	return func(k []byte) bool {
+		if start != nil && d.rangeCmp(k, start) < 0 {
+			return false
+		}
+		if end != nil {
+			c := d.rangeCmp(k, end)
+			return c < 0 || c == 0 && d.inclusiveEnd
+		}
+		return true
+	}, nil
+}
+
 // Query performs a complex search query on the underlying datastore
 // For more information see :
 // https://github.com/ipfs/go-datastore/blob/aa9190c18f1576be98e974359fd08c64ca0b5a94/examples/fs.go#L96
 // https://github.com/etcd-io/bbolt#prefix-scans
-func (d *Datastore) Query(ctx context.Context, q query.Query) (query.Results, error) {
-	var results query.Results
-	tx, err := d.db.Begin(false)
+//
+// Results are streamed from a cursor: the read transaction is held open
+// until the results are exhausted or closed, so callers must always Close
+// the results of a query they don't read to the end. The duration reported
+// to the metrics hook covers setting up the query, not reading its results.
+//
+// KeysOnly queries neither copy nor decode values: the Size of their
+// entries comes from the length of the stored value, which bbolt knows
+// without reading the pages of the value, or from the header of compressed
+// values. Only values both compressed and encrypted have to be decrypted,
+// checksums are neither computed nor verified.
+func (d *Datastore) Query(ctx context.Context, q query.Query) (_ query.Results, err error) {
+	if d.metrics != nil {
+		defer d.observe(OpQuery, time.Now(), &err)
+	}
+	if d.queryCache != nil && cacheable(q) {
+		return d.cachedQuery(ctx, q)
+	}
+	return d.query(ctx, q, queryOptions{})
+}
+
+// QueryAfter returns up to limit keys with the given prefix that are
+// greater than after, in ascending order, a limit of 0 meaning no limit.
+// Passing the last key of a page as after returns the next page, seeking
+// straight to it rather than skipping the previous pages like Offset. A
+// nil after starts from the first key. Results must be closed like those
+// of Query.
+func (d *Datastore) QueryAfter(ctx context.Context, prefix, after dskey.Key, limit int) (_ query.Results, err error) {
+	if d.metrics != nil {
+		defer d.observe(OpQuery, time.Now(), &err)
+	}
+	return d.query(ctx, query.Query{Prefix: prefix, Limit: limit}, queryOptions{after: after})
+}
+
+// QueryWithBudget runs q like Query, but reads at most maxScan keys: once
+// more would have to be read, the results end with ErrScanBudgetExceeded.
+// Unlike Limit, which bounds the number of results, the budget bounds the
+// work of the query, including the keys dropped by filters or an offset.
+func (d *Datastore) QueryWithBudget(ctx context.Context, q query.Query, maxScan int) (_ query.Results, err error) {
+	if d.metrics != nil {
+		defer d.observe(OpQuery, time.Now(), &err)
+	}
+	if maxScan <= 0 {
+		return nil, fmt.Errorf("invalid scan budget %d", maxScan)
+	}
+	return d.query(ctx, q, queryOptions{maxScan: maxScan})
+}
+
+// filterKeySuffix is a query filter passing the entries whose key ends
+// with suffix
+type filterKeySuffix struct {
+	suffix []byte
+}
+
+func (f filterKeySuffix) Filter(e query.Entry) bool {
+	return bytes.HasSuffix(e.Key.Bytes(), f.suffix)
+}
+
+func (f filterKeySuffix) String() string {
+	return fmt.Sprintf("SUFFIX(%q)", f.suffix)
+}
+
+// QueryBySuffix returns the entries whose key ends with suffix, in key
+// order. Keys cannot be looked up by suffix, so it is a full scan reading
+// every key of the datastore.
+func (d *Datastore) QueryBySuffix(ctx context.Context, suffix []byte) (_ query.Results, err error) {
+	if d.metrics != nil {
+		defer d.observe(OpQuery, time.Now(), &err)
+	}
+	q := query.Query{Filters: []query.Filter{filterKeySuffix{copyBytes(suffix)}}}
+	return d.query(ctx, q, queryOptions{})
+}
+
+// keySeparator separates the namespaces of hierarchical keys
+const keySeparator = '/'
+
+// filterChildren is a query filter passing the entries whose key is
+// prefix followed by a single segment without keySeparator
+type filterChildren struct {
+	prefix []byte
+}
+
+func (f filterChildren) Filter(e query.Entry) bool {
+	k := e.Key.Bytes()
+	return len(k) > len(f.prefix) && bytes.HasPrefix(k, f.prefix) &&
+		bytes.IndexByte(k[len(f.prefix):], keySeparator) < 0
+}
+
+func (f filterChildren) String() string {
+	return fmt.Sprintf("CHILDREN(%q)", f.prefix)
+}
+
+// ChildrenOf returns the entries whose key is a direct child of parent, in
+// key order, like listing a directory: for parent "a" it returns "a/b" and
+// "a/c" but not "a/b/d". A nil or empty parent returns the top-level keys.
+// The descendants further down are read and skipped, so listing a parent
+// with a deep subtree reads all of it.
+func (d *Datastore) ChildrenOf(ctx context.Context, parent dskey.Key) (_ query.Results, err error) {
+	if d.metrics != nil {
+		defer d.observe(OpQuery, time.Now(), &err)
+	}
+	if keyTypeMismatch(parent, d.ktype) {
+		return nil, ErrKeyTypeNotMatch
+	}
+	var prefix []byte
+	if parent != nil {
+		prefix = copyBytes(parent.Bytes())
+	}
+	if len(prefix) > 0 && prefix[len(prefix)-1] != keySeparator {
+		prefix = append(prefix, keySeparator)
+	}
+	q := query.Query{Filters: []query.Filter{filterChildren{prefix}}}
+	if len(prefix) > 0 {
+		q.Prefix = parent
+	}
+	return d.query(ctx, q, queryOptions{})
+}
+
+// QuerySince returns the entries written after since, in key order, e.g.
+// to sync incrementally the keys put since the last sync. It requires
+// metadata to be enabled with WithMetadata and reads the write time from
+// the header of every value, so it is a full scan reading every key of the
+// datastore whatever the number of keys returned. Deleted keys are not
+// reported, see WithTombstones.
+func (d *Datastore) QuerySince(ctx context.Context, since time.Time) (_ query.Results, err error) {
+	if d.metrics != nil {
+		defer d.observe(OpQuery, time.Now(), &err)
+	}
+	if !d.meta {
+		return nil, ErrNoMetadata
+	}
+	return d.query(ctx, query.Query{}, queryOptions{since: since})
+}
+
+func (d *Datastore) query(ctx context.Context, q query.Query, opts queryOptions) (query.Results, error) {
+	tx, err := d.bolt().Begin(false)
 	if err != nil {
 		return nil, err
 	}
-	bucket := tx.Bucket(d.bucket)
+	bucket, err := d.openBucket(tx)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
 	cursor := bucket.Cursor()
-	results, err = queryWithCursor(cursor, q, d.ktype, func() error {
+	closed := false
+	results, err := d.queryWithCursor(ctx, cursor, q, opts, func() error {
+		// the iterator close func may be called more than once
+		if closed {
+			return nil
+		}
+		closed = true
 		return tx.Rollback()
 	})
-
-	return results, err
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return results, nil
 }
 
-// Batch returns a basic batched bolt datastore wrapper
-// it is a temporary method until we implement a proper
-// transactional batched datastore
-//func (d *Datastore) Batch(ctx context.Context) (datastore.Batch, error) {
-//	return datastore.NewBasicBatch(d), nil
-//}
-
-// Close is used to close the underlying datastore
+// Close is used to close the underlying datastore and stop its reaper,
+// the db is left open for a Datastore that does not own it. Closing the db
+// stops the reapers of the datastores returned by WithBucket, and waits
+// for the open transactions, iterators and query results to be closed, so
+// it must not be called while holding one. Closing again is a no-op.
 func (d *Datastore) Close() error {
-	return d.db.Close()
+	d.StopReaper()
+	if !d.ownsDB {
+		return nil
+	}
+	h := d.db
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil
+	}
+	h.closed = true
+	h.watch.close()
+	for _, view := range h.views {
+		view.StopReaper()
+	}
+	// wait for the open transactions to be committed or discarded, later
+	// ones fail on the closed db
+	h.txns.Lock()
+	defer h.txns.Unlock()
+	if d.readPool != nil {
+		// bbolt waits for open transactions to close the db
+		d.readPool.close()
+	}
+	if err := d.bolt().Close(); err != nil {
+		return err
+	}
+	if h.temp {
+		return os.Remove(h.path)
+	}
+	return nil
 }
@@ -3,10 +3,13 @@ package dsbbolt
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"path/filepath"
 	"reflect"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/daotl/go-datastore"
 	dskey "github.com/daotl/go-datastore/key"
@@ -53,7 +56,7 @@ func Test_Datastore(t *testing.T) {
 	ctx := context.Background()
 	t.Run("cursor", func(t *testing.T) {
 		bucketName := []byte("test_bucket")
-		err := ds.db.Update(func(tx *bbolt.Tx) error {
+		err := ds.bolt().Update(func(tx *bbolt.Tx) error {
 			b, _ := tx.CreateBucketIfNotExists(bucketName)
 			err := b.Put([]byte("sa"), []byte("sa"))
 			if err != nil {
@@ -68,7 +71,7 @@ func Test_Datastore(t *testing.T) {
 		if err != nil {
 			t.Error(err)
 		}
-		ds.db.View(func(tx *bbolt.Tx) error {
+		ds.bolt().View(func(tx *bbolt.Tx) error {
 			b := tx.Bucket(bucketName)
 			cur := b.Cursor()
 			for k, v := cur.Seek([]byte{}); k != nil; k, v = cur.Next() {
@@ -79,8 +82,8 @@ func Test_Datastore(t *testing.T) {
 	})
 
 	t.Run("nil key", func(t *testing.T) {
-		err := ds.db.Update(func(tx *bbolt.Tx) error {
-			return tx.Bucket(ds.bucket).Put([]byte{}, []byte("sd"))
+		err := ds.bolt().Update(func(tx *bbolt.Tx) error {
+			return ds.bucketOf(tx).Put([]byte{}, []byte("sd"))
 		})
 		if err == nil {
 			t.Error("expected err")
@@ -240,6 +243,49 @@ func TestTxn(t *testing.T) {
 	tx1.Discard(ctx)
 }
 
+func newTestDatastore(t *testing.T) *Datastore {
+	t.Helper()
+	ds, err := NewDatastore(filepath.Join(t.TempDir(), "bolt"), nil, nil, dskey.KeyTypeBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ds.Close()
+	})
+	return ds
+}
+
+func TestTxnGet(t *testing.T) {
+	ds := newTestDatastore(t)
+	k := dskey.NewBytesKeyFromString("txn-get")
+	val := []byte("txn-get-value")
+
+	t.Run("committed", func(t *testing.T) {
+		tx, err := ds.NewTransaction(bg, false)
+		assert.NoError(t, err)
+		assert.NoError(t, tx.Put(bg, k, val))
+		assert.NoError(t, tx.Commit(bg))
+
+		rtx, err := ds.NewTransaction(bg, true)
+		assert.NoError(t, err)
+		defer rtx.Discard(bg)
+		v, err := rtx.Get(bg, k)
+		assert.NoError(t, err)
+		assert.Equal(t, val, v)
+	})
+
+	t.Run("read your writes", func(t *testing.T) {
+		k2 := dskey.NewBytesKeyFromString("txn-get-uncommitted")
+		tx, err := ds.NewTransaction(bg, false)
+		assert.NoError(t, err)
+		defer tx.Discard(bg)
+		assert.NoError(t, tx.Put(bg, k2, val))
+		v, err := tx.Get(bg, k2)
+		assert.NoError(t, err)
+		assert.Equal(t, val, v)
+	})
+}
+
 func TestSuite(t *testing.T) {
 	tmpFile := filepath.Join(t.TempDir(), "bolt")
 	ds, err := NewDatastore(tmpFile, nil, nil, dskey.KeyTypeBytes)
@@ -254,3 +300,1073 @@ func TestSuite(t *testing.T) {
 	}(ds)
 	dstest.SubtestAll(t, dskey.KeyTypeBytes, ds)
 }
+
+func TestWithBucket(t *testing.T) {
+	ds := newTestDatastore(t)
+	blocks, err := ds.WithBucket([]byte("blocks"))
+	assert.NoError(t, err)
+	pins, err := ds.WithBucket([]byte("pins"))
+	assert.NoError(t, err)
+
+	k := dskey.NewBytesKeyFromString("shared-key")
+	assert.NoError(t, blocks.Put(bg, k, []byte("block")))
+	assert.NoError(t, pins.Put(bg, k, []byte("pin")))
+
+	v, err := blocks.Get(bg, k)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("block"), v)
+	v, err = pins.Get(bg, k)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("pin"), v)
+	_, err = ds.Get(bg, k)
+	assert.Equal(t, datastore.ErrNotFound, err)
+
+	// closing a view must leave the shared db open
+	assert.NoError(t, blocks.Close())
+	v, err = pins.Get(bg, k)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("pin"), v)
+}
+
+func TestQueryContextCancel(t *testing.T) {
+	ds := newTestDatastore(t)
+	b, err := ds.Batch(bg)
+	assert.NoError(t, err)
+	for _, k := range benchmarkKeys(10000) {
+		assert.NoError(t, b.Put(bg, k, []byte("v")))
+	}
+	assert.NoError(t, b.Commit(bg))
+
+	ctx, cancel := context.WithTimeout(bg, time.Millisecond)
+	defer cancel()
+	<-ctx.Done()
+
+	rs, err := ds.Query(ctx, query.Query{})
+	assert.NoError(t, err)
+	defer rs.Close()
+	entries, err := rs.Rest()
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.True(t, len(entries) < 10000)
+}
+
+func TestQueryCloseTwice(t *testing.T) {
+	ds := newTestDatastore(t)
+	assert.NoError(t, ds.Put(bg, dskey.NewBytesKeyFromString("a"), []byte("a")))
+	rs, err := ds.Query(bg, query.Query{})
+	assert.NoError(t, err)
+	_, err = rs.Rest()
+	assert.NoError(t, err)
+	assert.NoError(t, rs.Close())
+	assert.NoError(t, rs.Close())
+
+	// a rejected query must not leave its read transaction open
+	_, err = ds.Query(bg, query.Query{Prefix: dskey.NewStrKey("str")})
+	assert.Equal(t, ErrKeyTypeNotMatch, err)
+	assert.Equal(t, 0, ds.bolt().Stats().OpenTxN)
+}
+
+func benchmarkDatastoreWithKeys(b *testing.B, n int, value []byte) *Datastore {
+	b.Helper()
+	ds, err := NewDatastore(filepath.Join(b.TempDir(), "bolt"), nil, nil, dskey.KeyTypeBytes)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() {
+		ds.Close()
+	})
+	batch, err := ds.Batch(bg)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, k := range benchmarkKeys(n) {
+		if err := batch.Put(bg, k, value); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := batch.Commit(bg); err != nil {
+		b.Fatal(err)
+	}
+	return ds
+}
+
+// BenchmarkQueryStream walks 1M keys one result at a time
+func BenchmarkQueryStream(b *testing.B) {
+	ds := benchmarkDatastoreWithKeys(b, 1000000, []byte("benchmark value"))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rs, err := ds.Query(bg, query.Query{})
+		if err != nil {
+			b.Fatal(err)
+		}
+		for {
+			r, ok := rs.NextSync()
+			if !ok {
+				break
+			}
+			if r.Error != nil {
+				b.Fatal(r.Error)
+			}
+		}
+		rs.Close()
+	}
+}
+
+// BenchmarkQueryRest materializes 1M keys before using them
+func BenchmarkQueryRest(b *testing.B) {
+	ds := benchmarkDatastoreWithKeys(b, 1000000, []byte("benchmark value"))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rs, err := ds.Query(bg, query.Query{})
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := rs.Rest(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestNewDatastoreWithDB(t *testing.T) {
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "bolt"), 0600, nil)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	ds, err := NewDatastoreWithDB(db, nil, dskey.KeyTypeBytes)
+	assert.NoError(t, err)
+	k := dskey.NewBytesKeyFromString("external")
+	assert.NoError(t, ds.Put(bg, k, []byte("db")))
+	assert.NoError(t, ds.Close())
+
+	// the externally owned db must still be usable after Close
+	assert.NoError(t, db.View(func(tx *bbolt.Tx) error {
+		assert.Equal(t, []byte("db"), tx.Bucket(defaultBucket).Get(k.Bytes()))
+		return nil
+	}))
+
+	_, err = NewDatastoreWithDB(db, nil, dskey.KeyTypeString)
+	assert.Equal(t, ErrKeyTypeNotMatch, err)
+}
+
+func TestNewMultiBucketDatastore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bolt")
+	names := [][]byte{[]byte("blocks"), []byte("meta"), []byte("index")}
+	views, closeDB, err := NewMultiBucketDatastore(path, nil, names, dskey.KeyTypeBytes)
+	assert.NoError(t, err)
+	assert.Len(t, views, 3)
+
+	k := dskey.NewBytesKeyFromString("shared-key")
+	for name, ds := range views {
+		assert.NoError(t, ds.Put(bg, k, []byte(name)))
+	}
+	for name, ds := range views {
+		v, err := ds.Get(bg, k)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte(name), v)
+	}
+	assert.NoError(t, views["meta"].Close())
+	_, err = views["index"].Get(bg, k)
+	assert.NoError(t, err)
+
+	// a single close releases the file lock
+	assert.NoError(t, closeDB())
+	_, err = views["blocks"].Get(bg, k)
+	assert.Equal(t, bbolt.ErrDatabaseNotOpen, err)
+	views, closeDB, err = NewMultiBucketDatastore(path, nil, names[:1], dskey.KeyTypeBytes)
+	assert.NoError(t, err)
+	v, err := views["blocks"].Get(bg, k)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("blocks"), v)
+	assert.NoError(t, closeDB())
+
+	_, _, err = NewMultiBucketDatastore(path, nil, [][]byte{[]byte("blocks"), metaBucket}, dskey.KeyTypeBytes)
+	assert.True(t, errors.Is(err, ErrInvalidBucket))
+	_, _, err = NewMultiBucketDatastore(path, nil, names, dskey.KeyTypeString)
+	assert.Equal(t, ErrKeyTypeNotMatch, err)
+}
+
+func TestReadOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bolt")
+	k := dskey.NewBytesKeyFromString("read-only")
+	ds, err := NewDatastore(path, nil, nil, dskey.KeyTypeBytes)
+	assert.NoError(t, err)
+	assert.NoError(t, ds.Put(bg, k, []byte("v")))
+	assert.NoError(t, ds.Close())
+
+	ro, err := NewDatastore(path, &bbolt.Options{ReadOnly: true}, nil, dskey.KeyTypeBytes)
+	assert.NoError(t, err)
+	defer ro.Close()
+
+	v, err := ro.Get(bg, k)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("v"), v)
+	assert.Equal(t, ErrReadOnly, ro.Put(bg, k, []byte("w")))
+	assert.Equal(t, ErrReadOnly, ro.Delete(bg, k))
+	_, err = ro.NewTransaction(bg, false)
+	assert.Equal(t, ErrReadOnly, err)
+	b, err := ro.Batch(bg)
+	assert.NoError(t, err)
+	assert.NoError(t, b.Put(bg, k, []byte("w")))
+	assert.Equal(t, ErrReadOnly, b.Commit(bg))
+	_, err = ro.WithBucket([]byte("missing"))
+	assert.Equal(t, ErrBucketNotFound, err)
+
+	tx, err := ro.NewTransaction(bg, true)
+	assert.NoError(t, err)
+	v, err = tx.Get(bg, k)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("v"), v)
+	tx.Discard(bg)
+}
+
+func TestQueryLimitOffset(t *testing.T) {
+	ds := newTestDatastore(t)
+	keys := benchmarkKeys(100)
+	for _, k := range keys {
+		assert.NoError(t, ds.Put(bg, k, k.Bytes()))
+	}
+	tests := []struct {
+		name          string
+		q             query.Query
+		first, length int
+	}{
+		{"limit", query.Query{Limit: 10}, 0, 10},
+		{"offset", query.Query{Offset: 95}, 95, 5},
+		{"offset and limit", query.Query{Offset: 20, Limit: 10}, 20, 10},
+		{"offset past end", query.Query{Offset: 200, Limit: 10}, 0, 0},
+		{"descending", query.Query{Orders: []query.Order{query.OrderByKeyDescending{}}, Offset: 1, Limit: 2}, 98, 2},
+		{"filtered", query.Query{Filters: []query.Filter{query.FilterKeyCompare{Op: query.GreaterThanOrEqual, Key: keys[50]}}, Offset: 5, Limit: 3}, 55, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs, err := ds.Query(bg, tt.q)
+			assert.NoError(t, err)
+			entries, err := rs.Rest()
+			assert.NoError(t, err)
+			assert.Equal(t, tt.length, len(entries))
+			if len(entries) > 0 {
+				assert.Equal(t, keys[tt.first].Bytes(), entries[0].Key.Bytes())
+			}
+		})
+	}
+}
+
+// BenchmarkQueryOffsetLimit pages 10 results from the middle of 1M keys
+func BenchmarkQueryOffsetLimit(b *testing.B) {
+	ds := benchmarkDatastoreWithKeys(b, 1000000, []byte("benchmark value"))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rs, err := ds.Query(bg, query.Query{Offset: 500000, Limit: 10})
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := rs.Rest(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestQueryDescending(t *testing.T) {
+	ds := newTestDatastore(t)
+	for _, s := range []string{"a", "b0", "b1", "b2", "b3", "b4", "c", "\xff\xff0", "\xff\xff1"} {
+		assert.NoError(t, ds.Put(bg, dskey.NewBytesKeyFromString(s), []byte(s)))
+	}
+	tests := []struct {
+		name string
+		q    query.Query
+	}{
+		{"all", query.Query{}},
+		{"prefix", query.Query{Prefix: dskey.NewBytesKeyFromString("b")}},
+		{"prefix at end", query.Query{Prefix: dskey.NewBytesKeyFromString("\xff\xff")}},
+		{"range", query.Query{Range: query.Range{Start: dskey.NewBytesKeyFromString("b1"), End: dskey.NewBytesKeyFromString("b4")}}},
+		{"range past end", query.Query{Range: query.Range{Start: dskey.NewBytesKeyFromString("b3"), End: dskey.NewBytesKeyFromString("zz")}}},
+		{"prefix and range", query.Query{Prefix: dskey.NewBytesKeyFromString("b"), Range: query.Range{Start: dskey.NewBytesKeyFromString("b2")}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs, err := ds.Query(bg, tt.q)
+			assert.NoError(t, err)
+			forward, err := rs.Rest()
+			assert.NoError(t, err)
+			assert.NotEmpty(t, forward)
+
+			q := tt.q
+			q.Orders = []query.Order{query.OrderByKeyDescending{}}
+			rs, err = ds.Query(bg, q)
+			assert.NoError(t, err)
+			backward, err := rs.Rest()
+			assert.NoError(t, err)
+
+			assert.Equal(t, len(forward), len(backward))
+			for i := range forward {
+				assert.Equal(t, forward[i].Key.Bytes(), backward[len(backward)-1-i].Key.Bytes())
+			}
+		})
+	}
+}
+
+func TestSyncNoSync(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bolt")
+	ds, err := NewDatastore(path, &bbolt.Options{NoSync: true}, nil, dskey.KeyTypeBytes)
+	assert.NoError(t, err)
+	key := dskey.NewBytesKeyFromString("foo")
+	assert.NoError(t, ds.Put(bg, key, []byte("bar")))
+	assert.NoError(t, ds.Sync(bg, key))
+	assert.NoError(t, ds.Close())
+
+	ds, err = NewDatastore(path, nil, nil, dskey.KeyTypeBytes)
+	assert.NoError(t, err)
+	defer ds.Close()
+	value, err := ds.Get(bg, key)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("bar"), value)
+	assert.NoError(t, ds.Sync(bg, key))
+}
+
+func TestBoltTx(t *testing.T) {
+	ds := newTestDatastore(t)
+	key := dskey.NewBytesKeyFromString("foo")
+	txn, err := ds.NewTransaction(bg, false)
+	assert.NoError(t, err)
+	assert.NoError(t, txn.Put(bg, key, []byte("bar")))
+	side, err := txn.(BoltTxn).BoltTx().CreateBucket([]byte("side"))
+	assert.NoError(t, err)
+	assert.NoError(t, side.Put([]byte("k"), []byte("v")))
+	assert.NoError(t, txn.Commit(bg))
+
+	assert.NoError(t, ds.bolt().View(func(tx *bbolt.Tx) error {
+		assert.Equal(t, []byte("v"), tx.Bucket([]byte("side")).Get([]byte("k")))
+		return nil
+	}))
+	value, err := ds.Get(bg, key)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("bar"), value)
+}
+
+func TestTxnForEach(t *testing.T) {
+	ds := newTestDatastore(t)
+	keys := benchmarkKeys(1000)
+	for _, k := range keys {
+		assert.NoError(t, ds.Put(bg, k, k.Bytes()))
+	}
+	other := dskey.NewBytesKeyFromString("other")
+	assert.NoError(t, ds.Put(bg, other, nil))
+
+	txn, err := ds.NewTransaction(bg, false)
+	assert.NoError(t, err)
+	i := 0
+	assert.NoError(t, txn.(BoltTxn).ForEach(bg, dskey.NewBytesKeyFromString("bench-"), func(key dskey.Key, value []byte) (bool, error) {
+		assert.Equal(t, keys[i], key)
+		assert.Equal(t, key.Bytes(), value)
+		i++
+		return i%2 == 1, nil
+	}))
+	assert.Equal(t, len(keys), i)
+	assert.NoError(t, txn.Commit(bg))
+	for i, k := range keys {
+		has, err := ds.Has(bg, k)
+		assert.NoError(t, err)
+		assert.Equal(t, i%2 == 1, has)
+	}
+	has, err := ds.Has(bg, other)
+	assert.NoError(t, err)
+	assert.True(t, has)
+
+	// errors stop the scan, read-only transactions cannot delete
+	txn, err = ds.NewTransaction(bg, true)
+	assert.NoError(t, err)
+	defer txn.Discard(bg)
+	errStop := errors.New("stop")
+	n := 0
+	assert.Equal(t, errStop, txn.(BoltTxn).ForEach(bg, nil, func(dskey.Key, []byte) (bool, error) {
+		n++
+		return false, errStop
+	}))
+	assert.Equal(t, 1, n)
+	assert.Equal(t, bbolt.ErrTxNotWritable, txn.(BoltTxn).ForEach(bg, nil, func(dskey.Key, []byte) (bool, error) {
+		return true, nil
+	}))
+}
+
+func TestTxnStats(t *testing.T) {
+	ds := newTestDatastore(t)
+	txn, err := ds.NewTransaction(bg, false)
+	assert.NoError(t, err)
+	id := txn.(BoltTxn).ID()
+	assert.NotZero(t, id)
+	for _, k := range benchmarkKeys(100) {
+		assert.NoError(t, txn.Put(bg, k, make([]byte, 100)))
+	}
+	assert.NoError(t, txn.Commit(bg))
+	assert.Equal(t, id, txn.(BoltTxn).ID())
+	assert.True(t, txn.(BoltTxn).Stats().PageCount > 0)
+	assert.True(t, txn.(BoltTxn).Stats().Write > 0)
+
+	// read-only transactions allocate no pages
+	txn, err = ds.NewTransaction(bg, true)
+	assert.NoError(t, err)
+	_, err = txn.Get(bg, benchmarkKeys(1)[0])
+	assert.NoError(t, err)
+	txn.Discard(bg)
+	assert.Equal(t, 0, txn.(BoltTxn).Stats().PageCount)
+}
+
+func TestTxnClosed(t *testing.T) {
+	ds := newTestDatastore(t)
+	txn, err := ds.NewTransaction(bg, false)
+	assert.NoError(t, err)
+	defer txn.Discard(bg)
+	assert.NoError(t, txn.Put(bg, dskey.NewBytesKeyFromString("foo"), []byte("bar")))
+	assert.NoError(t, txn.Commit(bg))
+	txn.Discard(bg)
+	assert.NoError(t, txn.(BoltTxn).LastError())
+	assert.Equal(t, ErrTxnClosed, txn.Commit(bg))
+
+	txn, err = ds.NewTransaction(bg, true)
+	assert.NoError(t, err)
+	txn.Discard(bg)
+	txn.Discard(bg)
+	assert.NoError(t, txn.(BoltTxn).LastError())
+	assert.Equal(t, ErrTxnClosed, txn.Commit(bg))
+}
+
+func BenchmarkGetSize(b *testing.B) {
+	ds, err := NewDatastore(filepath.Join(b.TempDir(), "bolt"), nil, nil, dskey.KeyTypeBytes)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer ds.Close()
+	key := dskey.NewBytesKeyFromString("large")
+	if err := ds.Put(bg, key, make([]byte, 1<<20)); err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("GetBackedSize", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := datastore.GetBackedSize(bg, ds, key); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("GetSize", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := ds.GetSize(bg, key); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkHas(b *testing.B) {
+	ds, err := NewDatastore(filepath.Join(b.TempDir(), "bolt"), nil, nil, dskey.KeyTypeBytes)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer ds.Close()
+	key := dskey.NewBytesKeyFromString("large")
+	if err := ds.Put(bg, key, make([]byte, 1<<20)); err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("GetBackedHas", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := datastore.GetBackedHas(bg, ds, key); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("Has", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := ds.Has(bg, key); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestInvalidBucket(t *testing.T) {
+	tests := []struct {
+		name    string
+		bucket  []byte
+		wantErr string
+	}{
+		{"Empty", []byte{}, "invalid bucket name: empty name"},
+		{"Reserved", metaBucket, `invalid bucket name: "__dsbbolt__" is reserved`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewDatastore(filepath.Join(t.TempDir(), "bolt"), nil, tt.bucket, dskey.KeyTypeBytes)
+			assert.True(t, errors.Is(err, ErrInvalidBucket))
+			assert.EqualError(t, err, tt.wantErr)
+
+			ds := newTestDatastore(t)
+			_, err = ds.WithBucket(tt.bucket)
+			assert.True(t, errors.Is(err, ErrInvalidBucket))
+		})
+	}
+
+	_, err := NewDatastoreWithOptions(filepath.Join(t.TempDir(), "bolt"),
+		WithBucketPath([]byte("parent"), nil))
+	assert.True(t, errors.Is(err, ErrInvalidBucket))
+}
+
+func TestBucketDeleted(t *testing.T) {
+	ds := newTestDatastore(t)
+	key := dskey.NewBytesKeyFromString("foo")
+	assert.NoError(t, ds.Put(bg, key, []byte("bar")))
+	assert.NoError(t, ds.bolt().Update(func(tx *bbolt.Tx) error {
+		return tx.DeleteBucket(defaultBucket)
+	}))
+
+	_, err := ds.Get(bg, key)
+	assert.Equal(t, ErrBucketNotFound, err)
+	_, err = ds.Has(bg, key)
+	assert.Equal(t, ErrBucketNotFound, err)
+	_, err = ds.GetSize(bg, key)
+	assert.Equal(t, ErrBucketNotFound, err)
+	assert.Equal(t, ErrBucketNotFound, ds.Put(bg, key, []byte("bar")))
+	assert.Equal(t, ErrBucketNotFound, ds.Delete(bg, key))
+	_, err = ds.Query(bg, query.Query{})
+	assert.Equal(t, ErrBucketNotFound, err)
+	for _, readOnly := range []bool{true, false} {
+		_, err = ds.NewTransaction(bg, readOnly)
+		assert.Equal(t, ErrBucketNotFound, err)
+	}
+	// the failed transactions were rolled back
+	assert.Equal(t, 0, ds.bolt().Stats().OpenTxN)
+	assert.NoError(t, ds.bolt().Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucket(defaultBucket)
+		return err
+	}))
+}
+
+func TestQueryAfter(t *testing.T) {
+	ds := newTestDatastore(t)
+	keys := benchmarkKeys(1000)
+	entries := make([]KeyValue, len(keys))
+	for i, k := range keys {
+		entries[i] = KeyValue{Key: k, Value: []byte("value")}
+	}
+	assert.NoError(t, ds.PutMany(bg, entries))
+	assert.NoError(t, ds.Put(bg, dskey.NewBytesKeyFromString("other"), []byte("value")))
+
+	prefix := dskey.NewBytesKeyFromString("bench-")
+	var got []dskey.Key
+	var after dskey.Key
+	for pages := 0; ; pages++ {
+		results, err := ds.QueryAfter(bg, prefix, after, 100)
+		assert.NoError(t, err)
+		page, err := results.Rest()
+		assert.NoError(t, err)
+		if len(page) == 0 {
+			assert.Equal(t, 10, pages)
+			break
+		}
+		assert.Len(t, page, 100)
+		got = append(got, query.EntryKeys(page)...)
+		after = page[len(page)-1].Key
+	}
+	assert.Equal(t, keys, got)
+}
+
+func TestPutCoalesced(t *testing.T) {
+	ds := newTestDatastore(t)
+	keys := benchmarkKeys(100)
+	var wg sync.WaitGroup
+	for _, k := range keys {
+		wg.Add(1)
+		go func(k dskey.Key) {
+			defer wg.Done()
+			assert.NoError(t, ds.PutCoalesced(bg, k, k.Bytes()))
+		}(k)
+	}
+	wg.Wait()
+	for _, k := range keys {
+		v, err := ds.Get(bg, k)
+		assert.NoError(t, err)
+		assert.Equal(t, k.Bytes(), v)
+	}
+	assert.Equal(t, ErrKeyTypeNotMatch, ds.PutCoalesced(bg, dskey.NewStrKey("key"), nil))
+
+	canceled, cancel := context.WithCancel(bg)
+	cancel()
+	assert.Equal(t, context.Canceled, ds.PutCoalesced(canceled, keys[0], nil))
+	// waits for the open write transaction like Put
+	txn, err := ds.NewTransaction(bg, false)
+	assert.NoError(t, err)
+	timeout, cancel := context.WithTimeout(bg, 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, ds.PutCoalesced(timeout, keys[0], nil))
+	txn.Discard(bg)
+	v, err := ds.Get(bg, keys[0])
+	assert.NoError(t, err)
+	assert.Equal(t, keys[0].Bytes(), v)
+}
+
+func BenchmarkPutConcurrent(b *testing.B) {
+	for _, bb := range []struct {
+		name string
+		put  func(ds *Datastore, key dskey.Key) error
+	}{
+		{"Put", func(ds *Datastore, key dskey.Key) error { return ds.Put(bg, key, key.Bytes()) }},
+		{"PutCoalesced", func(ds *Datastore, key dskey.Key) error { return ds.PutCoalesced(bg, key, key.Bytes()) }},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			ds, err := NewDatastore(filepath.Join(b.TempDir(), "bolt"), nil, nil, dskey.KeyTypeBytes)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer ds.Close()
+			keys := benchmarkKeys(b.N)
+			b.ResetTimer()
+			// 100 writers
+			var wg sync.WaitGroup
+			for w := 0; w < 100; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+					for i := w; i < b.N; i += 100 {
+						if err := bb.put(ds, keys[i]); err != nil {
+							b.Error(err)
+							return
+						}
+					}
+				}(w)
+			}
+			wg.Wait()
+		})
+	}
+}
+
+func TestPersistedKeyType(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bolt")
+	ds, err := NewDatastore(path, nil, nil, dskey.KeyTypeBytes)
+	assert.NoError(t, err)
+	_, err = ds.WithBucket([]byte("other"))
+	assert.NoError(t, err)
+	assert.NoError(t, ds.Close())
+
+	_, err = NewDatastore(path, nil, nil, dskey.KeyTypeString)
+	assert.Equal(t, ErrKeyTypeNotMatch, err)
+
+	// a bucket recorded with another key type cannot be opened
+	db, err := bbolt.Open(path, defaultFileMode, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, db.Update(func(tx *bbolt.Tx) error {
+		types := tx.Bucket(metaBucket).Bucket(keyTypesBucket)
+		assert.Equal(t, []byte{byte(dskey.KeyTypeBytes)}, types.Get(bucketPathKey([][]byte{[]byte("other")})))
+		return types.Put(bucketPathKey([][]byte{defaultBucket}), []byte{byte(dskey.KeyTypeString)})
+	}))
+	_, err = NewDatastoreWithDB(db, nil, dskey.KeyTypeBytes)
+	assert.Equal(t, ErrKeyTypeNotMatch, err)
+	ds, err = NewDatastoreWithDB(db, []byte("other"), dskey.KeyTypeBytes)
+	assert.NoError(t, err)
+	_, err = ds.WithBucket(defaultBucket)
+	assert.Equal(t, ErrKeyTypeNotMatch, err)
+	assert.NoError(t, db.Close())
+}
+
+// stepContext counts the calls to Err, which queries make every
+// queryCheckInterval cursor steps
+type stepContext struct {
+	context.Context
+	checks int
+}
+
+func (c *stepContext) Err() error {
+	c.checks++
+	return c.Context.Err()
+}
+
+func TestQueryKeyFilters(t *testing.T) {
+	ds := newTestDatastore(t)
+	keys := benchmarkKeys(10000)
+	var entries []KeyValue
+	for _, k := range keys {
+		entries = append(entries, KeyValue{Key: k, Value: k.Bytes()})
+	}
+	assert.NoError(t, ds.PutMany(bg, entries))
+
+	for _, c := range []struct {
+		name    string
+		filters []query.Filter
+		orders  []query.Order
+		want    []dskey.Key
+	}{
+		{"Compare", []query.Filter{
+			query.FilterKeyCompare{Op: query.GreaterThan, Key: keys[5000]},
+			&query.FilterKeyCompare{Op: query.LessThanOrEqual, Key: keys[5100]},
+		}, nil, keys[5001:5101]},
+		{"Equal", []query.Filter{
+			query.FilterKeyCompare{Op: query.Equal, Key: keys[42]},
+		}, nil, keys[42:43]},
+		{"Range", []query.Filter{
+			query.FilterKeyRange{Range: query.Range{Start: keys[9000], End: keys[9050]}},
+		}, []query.Order{query.OrderByKeyDescending{}}, reverseKeys(keys[9000:9050])},
+		{"Mixed", []query.Filter{
+			query.FilterKeyCompare{Op: query.GreaterThanOrEqual, Key: keys[100]},
+			query.FilterKeyCompare{Op: query.LessThan, Key: keys[110]},
+			query.FilterKeyCompare{Op: query.NotEqual, Key: keys[105]},
+		}, nil, append(append([]dskey.Key{}, keys[100:105]...), keys[106:110]...)},
+		{"Empty", []query.Filter{
+			query.FilterKeyCompare{Op: query.GreaterThan, Key: keys[200]},
+			query.FilterKeyCompare{Op: query.LessThan, Key: keys[100]},
+		}, nil, nil},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := &stepContext{Context: bg}
+			results, err := ds.Query(ctx, query.Query{Filters: c.filters, Orders: c.orders, KeysOnly: true})
+			assert.NoError(t, err)
+			all, err := results.Rest()
+			assert.NoError(t, err)
+			var got []dskey.Key
+			for _, e := range all {
+				got = append(got, e.Key)
+			}
+			assert.Equal(t, c.want, got)
+			// only the keys within the bounds are scanned
+			assert.Equal(t, 1, ctx.checks)
+		})
+	}
+}
+
+func reverseKeys(keys []dskey.Key) []dskey.Key {
+	reversed := make([]dskey.Key, len(keys))
+	for i, k := range keys {
+		reversed[len(keys)-1-i] = k
+	}
+	return reversed
+}
+
+func TestNilContext(t *testing.T) {
+	ds := newTestDatastore(t)
+	var ctx context.Context // nil
+	key := dskey.NewBytesKeyFromString("key")
+	assert.NotPanics(t, func() {
+		assert.NoError(t, ds.Put(ctx, key, []byte("value")))
+		value, err := ds.Get(ctx, key)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("value"), value)
+
+		results, err := ds.Query(ctx, query.Query{})
+		assert.NoError(t, err)
+		all, err := results.Rest()
+		assert.NoError(t, err)
+		assert.Len(t, all, 1)
+		n, err := ds.Count(ctx, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, n)
+		it, err := ds.Iterator(ctx, nil)
+		assert.NoError(t, err)
+		assert.True(t, it.Next())
+		assert.NoError(t, it.Close())
+		assert.NoError(t, ds.View(ctx, func(txn datastore.Txn) error {
+			_, err := txn.Get(ctx, key)
+			return err
+		}))
+
+		assert.NoError(t, ds.Delete(ctx, key))
+		_, err = ds.Get(ctx, key)
+		assert.Equal(t, datastore.ErrNotFound, err)
+	})
+}
+
+func TestQueryKeysOnly(t *testing.T) {
+	ds := newTestDatastore(t)
+	key := dskey.NewBytesKeyFromString("large")
+	assert.NoError(t, ds.Put(bg, key, make([]byte, 1<<20)))
+	results, err := ds.Query(bg, query.Query{KeysOnly: true, ReturnsSizes: true})
+	assert.NoError(t, err)
+	all, err := results.Rest()
+	assert.NoError(t, err)
+	assert.Equal(t, []query.Entry{{Key: key, Size: 1 << 20}}, all)
+}
+
+func BenchmarkQueryKeysOnly(b *testing.B) {
+	ds, err := NewDatastore(filepath.Join(b.TempDir(), "bolt"), nil, nil, dskey.KeyTypeBytes)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer ds.Close()
+	for _, k := range benchmarkKeys(100) {
+		if err := ds.Put(bg, k, make([]byte, 1<<20)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	for _, keysOnly := range []bool{false, true} {
+		b.Run(fmt.Sprintf("KeysOnly=%v", keysOnly), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				results, err := ds.Query(bg, query.Query{KeysOnly: keysOnly})
+				if err != nil {
+					b.Fatal(err)
+				}
+				all, err := results.Rest()
+				if err != nil || len(all) != 100 {
+					b.Fatal(err, len(all))
+				}
+			}
+		})
+	}
+}
+
+func TestAccessors(t *testing.T) {
+	ds, err := NewDatastore(filepath.Join(t.TempDir(), "bolt"), nil, []byte("bucket"), dskey.KeyTypeBytes)
+	assert.NoError(t, err)
+	defer ds.Close()
+	assert.Equal(t, []byte("bucket"), ds.Bucket())
+	assert.Equal(t, [][]byte{[]byte("bucket")}, ds.BucketPath())
+	assert.Equal(t, dskey.KeyTypeBytes, ds.KeyType())
+	// the accessors return copies
+	ds.Bucket()[0] = 'x'
+	ds.BucketPath()[0][0] = 'x'
+	assert.Equal(t, []byte("bucket"), ds.Bucket())
+
+	nested, err := NewDatastoreWithOptions(filepath.Join(t.TempDir(), "bolt"), WithBucketPath([]byte("a"), []byte("b")))
+	assert.NoError(t, err)
+	defer nested.Close()
+	assert.Equal(t, []byte("b"), nested.Bucket())
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("b")}, nested.BucketPath())
+	def := newTestDatastore(t)
+	assert.Equal(t, defaultBucket, def.Bucket())
+}
+
+func TestCloseWaitsForTxn(t *testing.T) {
+	ds, err := NewDatastoreWithOptions(filepath.Join(t.TempDir(), "bolt"), WithMetadata())
+	assert.NoError(t, err)
+	view, err := ds.WithBucket([]byte("view"))
+	assert.NoError(t, err)
+	assert.NoError(t, view.StartReaper(time.Millisecond))
+
+	txn, err := ds.NewTransaction(bg, false)
+	assert.NoError(t, err)
+	closed := make(chan error)
+	go func() {
+		closed <- ds.Close()
+	}()
+	select {
+	case <-closed:
+		t.Fatal("Close returned before the transaction was committed")
+	case <-time.After(50 * time.Millisecond):
+	}
+	assert.NoError(t, txn.Put(bg, dskey.NewBytesKeyFromString("key"), []byte("value")))
+	assert.NoError(t, txn.Commit(bg))
+	assert.NoError(t, <-closed)
+
+	// the reaper of the view was stopped along with the db
+	assert.Nil(t, view.reaper.stop)
+	assert.NoError(t, ds.Close())
+	_, err = ds.NewTransaction(bg, true)
+	assert.Equal(t, bbolt.ErrDatabaseNotOpen, err)
+}
+
+func TestQueryWithBudget(t *testing.T) {
+	ds := newTestDatastore(t)
+	keys := benchmarkKeys(10000)
+	var entries []KeyValue
+	for _, k := range keys {
+		entries = append(entries, KeyValue{Key: k, Value: k.Bytes()})
+	}
+	assert.NoError(t, ds.PutMany(bg, entries))
+
+	// a filter matching a single key has to read all of them
+	results, err := ds.QueryWithBudget(bg, query.Query{
+		Filters: []query.Filter{query.FilterValueCompare{Op: query.Equal, Value: keys[9999].Bytes()}},
+	}, 100)
+	assert.NoError(t, err)
+	_, err = results.Rest()
+	assert.Equal(t, ErrScanBudgetExceeded, err)
+	assert.NoError(t, results.Close())
+
+	// a query within its budget is not affected
+	results, err = ds.QueryWithBudget(bg, query.Query{Prefix: dskey.NewBytesKeyFromString("bench-000000")}, 100)
+	assert.NoError(t, err)
+	all, err := results.Rest()
+	assert.NoError(t, err)
+	assert.Len(t, all, 100)
+
+	_, err = ds.QueryWithBudget(bg, query.Query{}, 0)
+	assert.Error(t, err)
+}
+
+func TestQueryBySuffix(t *testing.T) {
+	ds := newTestDatastore(t)
+	for _, k := range []string{"a/shard-1", "b/shard-2", "c/shard-1", "shard-1/d", "shard-1"} {
+		assert.NoError(t, ds.Put(bg, dskey.NewBytesKeyFromString(k), []byte(k)))
+	}
+	results, err := ds.QueryBySuffix(bg, []byte("shard-1"))
+	assert.NoError(t, err)
+	entries, err := results.Rest()
+	assert.NoError(t, err)
+	var keys []string
+	for _, e := range entries {
+		keys = append(keys, e.Key.String())
+		assert.Equal(t, e.Key.Bytes(), e.Value)
+	}
+	assert.Equal(t, []string{"a/shard-1", "c/shard-1", "shard-1"}, keys)
+}
+
+func TestChildrenOf(t *testing.T) {
+	childKeys := func(results query.Results, err error) []string {
+		assert.NoError(t, err)
+		entries, err := results.Rest()
+		assert.NoError(t, err)
+		var keys []string
+		for _, e := range entries {
+			keys = append(keys, e.Key.String())
+		}
+		return keys
+	}
+
+	ds := newTestDatastore(t)
+	for _, k := range []string{"a", "a/b", "a/c", "a/b/d", "ab", "ab/c", "b"} {
+		assert.NoError(t, ds.Put(bg, dskey.NewBytesKeyFromString(k), []byte(k)))
+	}
+	assert.Equal(t, []string{"a/b", "a/c"}, childKeys(ds.ChildrenOf(bg, dskey.NewBytesKeyFromString("a"))))
+	assert.Equal(t, []string{"a/b/d"}, childKeys(ds.ChildrenOf(bg, dskey.NewBytesKeyFromString("a/b/"))))
+	assert.Equal(t, []string{"a", "ab", "b"}, childKeys(ds.ChildrenOf(bg, nil)))
+	_, err := ds.ChildrenOf(bg, dskey.NewStrKey("/a"))
+	assert.Equal(t, ErrKeyTypeNotMatch, err)
+}
+
+func TestQuerySince(t *testing.T) {
+	ds, err := NewDatastoreWithOptions(filepath.Join(t.TempDir(), "bolt"), WithMetadata())
+	assert.NoError(t, err)
+	defer ds.Close()
+	for _, k := range []string{"a", "b", "d"} {
+		assert.NoError(t, ds.Put(bg, dskey.NewBytesKeyFromString(k), []byte("old")))
+	}
+	time.Sleep(time.Millisecond)
+	midpoint := time.Now()
+	time.Sleep(time.Millisecond)
+	for _, k := range []string{"c", "a"} {
+		assert.NoError(t, ds.Put(bg, dskey.NewBytesKeyFromString(k), []byte("new")))
+	}
+
+	results, err := ds.QuerySince(bg, midpoint)
+	assert.NoError(t, err)
+	entries, err := results.Rest()
+	assert.NoError(t, err)
+	assert.Equal(t, []dskey.Key{
+		dskey.NewBytesKeyFromString("a"),
+		dskey.NewBytesKeyFromString("c"),
+	}, query.EntryKeys(entries))
+	for _, e := range entries {
+		assert.Equal(t, []byte("new"), e.Value)
+	}
+
+	results, err = ds.QuerySince(bg, time.Time{})
+	assert.NoError(t, err)
+	entries, err = results.Rest()
+	assert.NoError(t, err)
+	assert.Len(t, entries, 4)
+
+	_, err = newTestDatastore(t).QuerySince(bg, midpoint)
+	assert.Equal(t, ErrNoMetadata, err)
+}
+
+func TestGetView(t *testing.T) {
+	for _, opts := range [][]Option{nil, {WithMetadata()}} {
+		ds, err := NewDatastoreWithOptions(filepath.Join(t.TempDir(), "bolt"), opts...)
+		assert.NoError(t, err)
+		defer ds.Close()
+		large, small := dskey.NewBytesKeyFromString("large"), dskey.NewBytesKeyFromString("small")
+		value := bytes.Repeat([]byte("0123456789abcdef"), 1<<16)
+		assert.NoError(t, ds.Put(bg, large, value))
+		assert.NoError(t, ds.Put(bg, small, []byte("v")))
+
+		assert.NoError(t, ds.GetView(bg, large, func(v []byte) error {
+			assert.True(t, bytes.Equal(value, v))
+			return nil
+		}))
+		errStop := errors.New("stop")
+		assert.Equal(t, errStop, ds.GetView(bg, large, func([]byte) error { return errStop }))
+		assert.Equal(t, datastore.ErrNotFound, ds.GetView(bg, dskey.NewBytesKeyFromString("absent"),
+			func([]byte) error { return nil }))
+
+		// the value is not copied whatever its size, unlike Get
+		view := func(key dskey.Key) float64 {
+			return testing.AllocsPerRun(10, func() {
+				ds.GetView(bg, key, func([]byte) error { return nil })
+			})
+		}
+		assert.Equal(t, view(small), view(large))
+		get := testing.AllocsPerRun(10, func() { ds.Get(bg, large) })
+		assert.True(t, view(large) < get)
+	}
+}
+
+func TestGetFresh(t *testing.T) {
+	ds, err := NewDatastoreWithOptions(filepath.Join(t.TempDir(), "bolt"),
+		WithReadTxPool(1, time.Hour, 0), WithBloomFilter(100, 0.01),
+		// the direct writes below must not wait for the pooled transaction
+		// to remap the db
+		WithBoltOptions(&bbolt.Options{InitialMmapSize: 1 << 20}))
+	assert.NoError(t, err)
+	defer ds.Close()
+	old, added := dskey.NewBytesKeyFromString("old"), dskey.NewBytesKeyFromString("added")
+	assert.NoError(t, ds.Put(bg, old, []byte("v1")))
+	value, err := ds.Get(bg, old)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("v1"), value)
+
+	// writes made directly on the db are missed by the pooled transaction
+	// and the Bloom filter
+	assert.NoError(t, ds.bolt().Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(defaultBucket)
+		if err := bucket.Put(old.Bytes(), []byte("v2")); err != nil {
+			return err
+		}
+		return bucket.Put(added.Bytes(), []byte("v1"))
+	}))
+	value, err = ds.Get(bg, old)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("v1"), value)
+	_, err = ds.Get(bg, added)
+	assert.Equal(t, datastore.ErrNotFound, err)
+
+	value, err = ds.GetFresh(bg, old)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("v2"), value)
+	value, err = ds.GetFresh(bg, added)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("v1"), value)
+	_, err = ds.GetFresh(bg, dskey.NewBytesKeyFromString("absent"))
+	assert.Equal(t, datastore.ErrNotFound, err)
+}
+
+func TestWriteContext(t *testing.T) {
+	ds := newTestDatastore(t)
+	key := dskey.NewBytesKeyFromString("foo")
+	held, err := ds.NewTransaction(bg, false)
+	assert.NoError(t, err)
+
+	// writes give up waiting for the held transaction with their context
+	ctx, cancel := context.WithTimeout(bg, 20*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, ds.Put(ctx, key, []byte("bar")))
+	_, err = ds.NewTransaction(ctx, false)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	b, err := ds.Batch(ctx)
+	assert.NoError(t, err)
+	assert.NoError(t, b.Put(ctx, key, []byte("bar")))
+	assert.Equal(t, context.DeadlineExceeded, b.Commit(ctx))
+	// reads are not blocked
+	_, err = ds.Get(ctx, key)
+	assert.Equal(t, datastore.ErrNotFound, err)
+
+	done := make(chan error)
+	go func() {
+		done <- ds.Put(bg, key, []byte("bar"))
+	}()
+	time.Sleep(10 * time.Millisecond)
+	held.Discard(bg)
+	assert.NoError(t, <-done)
+	assert.NoError(t, b.Commit(bg))
+}
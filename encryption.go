@@ -0,0 +1,54 @@
+package dsbbolt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+)
+
+// NewAESGCM returns an AES-GCM AEAD for WithEncryption, key must be 16, 24
+// or 32 bytes long to select AES-128, AES-192 or AES-256
+func NewAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptionCodec stores values sealed by an AEAD, prefixed with the
+// random nonce they were sealed with. The stored key is authenticated as
+// additional data, so that a value copied under another key fails to
+// decrypt.
+type encryptionCodec struct {
+	aead cipher.AEAD
+}
+
+func (ec encryptionCodec) encode(key, value []byte) ([]byte, error) {
+	nonceSize := ec.aead.NonceSize()
+	stored := make([]byte, nonceSize, nonceSize+len(value)+ec.aead.Overhead())
+	if _, err := rand.Read(stored); err != nil {
+		return nil, err
+	}
+	return ec.aead.Seal(stored, stored, value, key), nil
+}
+
+func (ec encryptionCodec) decode(key, stored []byte) ([]byte, error) {
+	nonceSize := ec.aead.NonceSize()
+	if len(stored) < nonceSize+ec.aead.Overhead() {
+		return nil, ErrInvalidValue
+	}
+	value, err := ec.aead.Open(nil, stored[:nonceSize], stored[nonceSize:], key)
+	if err != nil {
+		return nil, ErrInvalidValue
+	}
+	return value, nil
+}
+
+func (ec encryptionCodec) size(stored []byte) (int, error) {
+	size := len(stored) - ec.aead.NonceSize() - ec.aead.Overhead()
+	if size < 0 {
+		return -1, ErrInvalidValue
+	}
+	return size, nil
+}
@@ -0,0 +1,91 @@
+package dsbbolt
+
+import (
+	"bytes"
+	"compress/flate"
+	"path/filepath"
+	"testing"
+
+	dskey "github.com/daotl/go-datastore/key"
+	"github.com/daotl/go-datastore/query"
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/bbolt"
+)
+
+func TestEncryption(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bolt")
+	aead, err := NewAESGCM(bytes.Repeat([]byte{1}, 32))
+	assert.NoError(t, err)
+	ds, err := NewDatastoreWithOptions(path, WithEncryption(aead))
+	assert.NoError(t, err)
+
+	key := dskey.NewBytesKeyFromString("secret/a")
+	value := []byte("plaintext value")
+	assert.NoError(t, ds.Put(bg, key, value))
+	got, err := ds.Get(bg, key)
+	assert.NoError(t, err)
+	assert.Equal(t, value, got)
+	size, err := ds.GetSize(bg, key)
+	assert.NoError(t, err)
+	assert.Equal(t, len(value), size)
+	assert.NoError(t, ds.bolt().View(func(tx *bbolt.Tx) error {
+		stored := ds.bucketOf(tx).Get(key.Bytes())
+		assert.False(t, bytes.Contains(stored, value))
+		return nil
+	}))
+
+	// keys stay in plaintext, prefix queries still work
+	results, err := ds.Query(bg, query.Query{Prefix: dskey.NewBytesKeyFromString("secret")})
+	assert.NoError(t, err)
+	entries, err := results.Rest()
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, value, entries[0].Value)
+		assert.Equal(t, len(value), entries[0].Size)
+	}
+
+	// a value copied under another key does not authenticate
+	other := dskey.NewBytesKeyFromString("secret/b")
+	assert.NoError(t, ds.bolt().Update(func(tx *bbolt.Tx) error {
+		bucket := ds.bucketOf(tx)
+		return bucket.Put(other.Bytes(), copyBytes(bucket.Get(key.Bytes())))
+	}))
+	_, err = ds.Get(bg, other)
+	assert.Equal(t, ErrInvalidValue, err)
+	assert.NoError(t, ds.Delete(bg, other))
+	assert.NoError(t, ds.Close())
+
+	wrong, err := NewAESGCM(bytes.Repeat([]byte{2}, 32))
+	assert.NoError(t, err)
+	ds, err = NewDatastoreWithOptions(path, WithEncryption(wrong))
+	assert.NoError(t, err)
+	_, err = ds.Get(bg, key)
+	assert.Equal(t, ErrInvalidValue, err)
+	assert.NoError(t, ds.Close())
+}
+
+func TestEncryptionWithCompression(t *testing.T) {
+	aead, err := NewAESGCM(bytes.Repeat([]byte{1}, 16))
+	assert.NoError(t, err)
+	ds, err := NewDatastoreWithOptions(filepath.Join(t.TempDir(), "bolt"),
+		WithCompression(FlateCompressor(flate.BestSpeed)), WithEncryption(aead))
+	assert.NoError(t, err)
+	defer ds.Close()
+
+	key := dskey.NewBytesKeyFromString("key")
+	value := bytes.Repeat([]byte("compressible"), 100)
+	assert.NoError(t, ds.Put(bg, key, value))
+	got, err := ds.Get(bg, key)
+	assert.NoError(t, err)
+	assert.Equal(t, value, got)
+	size, err := ds.GetSize(bg, key)
+	assert.NoError(t, err)
+	assert.Equal(t, len(value), size)
+	results, err := ds.Query(bg, query.Query{KeysOnly: true, ReturnsSizes: true})
+	assert.NoError(t, err)
+	entries, err := results.Rest()
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, len(value), entries[0].Size)
+	}
+}
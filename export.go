@@ -0,0 +1,158 @@
+package dsbbolt
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+
+	dskey "github.com/daotl/go-datastore/key"
+	"go.etcd.io/bbolt"
+)
+
+// ErrInvalidDump is returned by RestoreFrom when a record cannot be read
+var ErrInvalidDump = errors.New("invalid dump record")
+
+// importBatchOps is the number of entries Import writes per transaction
+const importBatchOps = 1000
+
+// jsonEntry is a line of the JSONL format of Export and Import, []byte
+// fields are encoded in base64 by encoding/json
+type jsonEntry struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// Export writes every key and value of the datastore to w as JSON lines of
+// the form {"key":"<base64>","value":"<base64>"}, streaming them from a
+// cursor in a single read transaction. Values are written decoded, so an
+// export can be imported into a datastore configured differently.
+func (d *Datastore) Export(ctx context.Context, w io.Writer) error {
+	it, err := d.Iterator(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	for it.Next() {
+		if err := enc.Encode(jsonEntry{Key: it.Key().Bytes(), Value: it.Value()}); err != nil {
+			return err
+		}
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// Import reads JSON lines written by Export from r and puts their entries
+// into the datastore, importBatchOps entries per write transaction. An
+// Import that fails leaves the entries of the transactions committed
+// before the failure in place.
+func (d *Datastore) Import(ctx context.Context, r io.Reader) error {
+	ctx = orBackground(ctx)
+	batch, err := d.BatchWithOptions(ctx, importBatchOps, 0)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(r)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var e jsonEntry
+		if err := dec.Decode(&e); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if err := batch.Put(ctx, dskey.NewBytesKey(e.Key), e.Value); err != nil {
+			return err
+		}
+	}
+	return batch.Commit(ctx)
+}
+
+// DumpTo writes every key and value of the datastore to w as records of
+// the key length as a uvarint, the key, the value length as a uvarint and
+// the value, streaming them from a cursor in a single read transaction
+// like Export. It is a compact binary alternative to Export, go-datastore
+// defining no dump format of its own, read back by RestoreFrom.
+func (d *Datastore) DumpTo(ctx context.Context, w io.Writer) error {
+	it, err := d.Iterator(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+	bw := bufio.NewWriter(w)
+	var buf []byte
+	for it.Next() {
+		key, value := it.Key().Bytes(), it.Value()
+		buf = appendUvarint(buf[:0], uint64(len(key)))
+		buf = append(buf, key...)
+		buf = appendUvarint(buf, uint64(len(value)))
+		if _, err := bw.Write(buf); err != nil {
+			return err
+		}
+		if _, err := bw.Write(value); err != nil {
+			return err
+		}
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// RestoreFrom reads the records written by DumpTo from r and puts their
+// entries into the datastore, in batches like Import. A RestoreFrom that
+// fails leaves the entries of the transactions committed before the
+// failure in place.
+func (d *Datastore) RestoreFrom(ctx context.Context, r io.Reader) error {
+	ctx = orBackground(ctx)
+	batch, err := d.BatchWithOptions(ctx, importBatchOps, 0)
+	if err != nil {
+		return err
+	}
+	br := bufio.NewReader(r)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		key, err := readDumpField(br, bbolt.MaxKeySize)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		value, err := readDumpField(br, bbolt.MaxValueSize)
+		if err == io.EOF {
+			return ErrInvalidDump
+		} else if err != nil {
+			return err
+		}
+		if err := batch.Put(ctx, dskey.NewBytesKey(key), value); err != nil {
+			return err
+		}
+	}
+	return batch.Commit(ctx)
+}
+
+// readDumpField reads a uvarint length of at most max followed by as many
+// bytes, io.EOF is only returned when r is at its end
+func readDumpField(r *bufio.Reader, max int) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err == io.EOF {
+		return nil, io.EOF
+	} else if err != nil || n > uint64(max) {
+		return nil, ErrInvalidDump
+	}
+	field := make([]byte, n)
+	if _, err := io.ReadFull(r, field); err != nil {
+		return nil, ErrInvalidDump
+	}
+	return field, nil
+}
@@ -0,0 +1,76 @@
+package dsbbolt
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	dskey "github.com/daotl/go-datastore/key"
+	"github.com/daotl/go-datastore/query"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportImport(t *testing.T) {
+	src := newTestDatastore(t)
+	var entries []KeyValue
+	for i, k := range benchmarkKeys(2500) {
+		entries = append(entries, KeyValue{Key: k, Value: []byte{byte(i), 0, 0xff}})
+	}
+	entries = append(entries, KeyValue{Key: dskey.NewBytesKey([]byte{0, 0xff}), Value: []byte{}})
+	assert.NoError(t, src.PutMany(bg, entries))
+
+	var buf bytes.Buffer
+	assert.NoError(t, src.Export(bg, &buf))
+	assert.Equal(t, len(entries), strings.Count(buf.String(), "\n"))
+	assert.True(t, strings.HasPrefix(buf.String(), `{"key":"AP8=","value":""}`+"\n"))
+
+	dst := newTestDatastore(t)
+	assert.NoError(t, dst.Import(bg, &buf))
+	for _, ds := range []*Datastore{src, dst} {
+		results, err := ds.Query(bg, query.Query{})
+		assert.NoError(t, err)
+		got, err := results.Rest()
+		assert.NoError(t, err)
+		if assert.Len(t, got, len(entries)) {
+			assert.Equal(t, entries[len(entries)-1].Key, got[0].Key)
+			assert.Equal(t, []byte{}, got[0].Value)
+			for i, e := range entries[:len(entries)-1] {
+				assert.Equal(t, e.Key, got[i+1].Key)
+				assert.Equal(t, e.Value, got[i+1].Value)
+			}
+		}
+	}
+
+	assert.Error(t, dst.Import(bg, strings.NewReader(`{"key":`)))
+}
+
+func TestDumpRestore(t *testing.T) {
+	src := newTestDatastore(t)
+	var entries []KeyValue
+	for i, k := range benchmarkKeys(2500) {
+		entries = append(entries, KeyValue{Key: k, Value: bytes.Repeat([]byte{byte(i)}, i%300)})
+	}
+	assert.NoError(t, src.PutMany(bg, entries))
+
+	var buf bytes.Buffer
+	assert.NoError(t, src.DumpTo(bg, &buf))
+	dump := buf.Bytes()
+	// the first record, of key bench-00000000 and an empty value
+	assert.Equal(t, append(append([]byte{14}, "bench-00000000"...), 0), dump[:16])
+
+	dst := newTestDatastore(t)
+	assert.NoError(t, dst.RestoreFrom(bg, bytes.NewReader(dump)))
+	results, err := dst.Query(bg, query.Query{})
+	assert.NoError(t, err)
+	got, err := results.Rest()
+	assert.NoError(t, err)
+	if assert.Len(t, got, len(entries)) {
+		for i, e := range entries {
+			assert.Equal(t, e.Key, got[i].Key)
+			assert.Equal(t, e.Value, got[i].Value)
+		}
+	}
+
+	assert.Equal(t, ErrInvalidDump, dst.RestoreFrom(bg, bytes.NewReader(dump[:len(dump)-1])))
+	assert.Equal(t, ErrInvalidDump, dst.RestoreFrom(bg, bytes.NewReader(dump[:15])))
+}
@@ -0,0 +1,196 @@
+package dsbbolt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/daotl/go-datastore"
+	"github.com/daotl/go-datastore/query"
+	"go.etcd.io/bbolt"
+)
+
+// ErrNoIndex is returned by QueryByIndex when no IndexFunc is configured
+var ErrNoIndex = errors.New("datastore has no index")
+
+// IndexFunc returns the key value is indexed under in the secondary index
+// of a datastore, nil or empty to leave it out of the index. key is the
+// key as seen by the datastore writing it, without the prefix of Scoped.
+// It must only depend on key and value, see WithIndex.
+type IndexFunc func(key, value []byte) []byte
+
+// indexesBucket is the bucket of metaBucket holding the secondary indexes,
+// a bucket per datastore bucket keyed by bucketPathKey. An index holds a
+// bucket per index key, holding the stored keys indexed under it.
+var indexesBucket = []byte("indexes")
+
+// indexBucket returns the index of the datastore, nil if it does not exist
+// and create is false
+func (d *Datastore) indexBucket(tx *bbolt.Tx, create bool) (*bbolt.Bucket, error) {
+	name := bucketPathKey(d.bucket)
+	if !create {
+		b := tx.Bucket(metaBucket)
+		if b != nil {
+			b = b.Bucket(indexesBucket)
+		}
+		if b != nil {
+			b = b.Bucket(name)
+		}
+		return b, nil
+	}
+	b, err := tx.CreateBucketIfNotExists(metaBucket)
+	if err == nil {
+		b, err = b.CreateBucketIfNotExists(indexesBucket)
+	}
+	if err == nil {
+		b, err = b.CreateBucketIfNotExists(name)
+	}
+	return b, err
+}
+
+// deleteIndex deletes the index of the datastore if it exists
+func (d *Datastore) deleteIndex(tx *bbolt.Tx) error {
+	b := tx.Bucket(metaBucket)
+	if b != nil {
+		b = b.Bucket(indexesBucket)
+	}
+	if b == nil || b.Bucket(bucketPathKey(d.bucket)) == nil {
+		return nil
+	}
+	return b.DeleteBucket(bucketPathKey(d.bucket))
+}
+
+// indexKey returns the index key of the value stored as stored under the
+// stored key k, nil if it is not indexed or cannot be decoded
+func (d *Datastore) indexKey(k, stored []byte) []byte {
+	if stored == nil {
+		return nil
+	}
+	value, err := d.decodeValue(k, stored)
+	if err != nil {
+		return nil
+	}
+	if ik := d.index(d.userKey(k), value); len(ik) > 0 {
+		return ik
+	}
+	return nil
+}
+
+// reindex moves the stored key k in the index from the index key of prev
+// to the one of next, the values stored before and after a write, nil when
+// the key is absent
+func (d *Datastore) reindex(bucket *bbolt.Bucket, k, prev, next []byte) error {
+	from, to := d.indexKey(k, prev), d.indexKey(k, next)
+	if bytes.Equal(from, to) {
+		return nil
+	}
+	index, err := d.indexBucket(bucket.Tx(), to != nil)
+	if err != nil || index == nil {
+		return err
+	}
+	if from != nil {
+		if b := index.Bucket(from); b != nil {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+	}
+	if to != nil {
+		b, err := index.CreateBucketIfNotExists(to)
+		if err != nil {
+			return err
+		}
+		return b.Put(k, []byte{})
+	}
+	return nil
+}
+
+// deleteStored deletes the stored key k from bucket and from the index
+func (d *Datastore) deleteStored(bucket *bbolt.Bucket, k []byte) error {
+	if err := d.beforeDelete(bucket, k, bucket.Get(k)); err != nil {
+		return err
+	}
+	return bucket.Delete(k)
+}
+
+// beforeDelete removes the stored key k holding v from the index, leaves
+// a tombstone and notifies its watchers, before the caller deletes it from
+// bucket
+func (d *Datastore) beforeDelete(bucket *bbolt.Bucket, k, v []byte) error {
+	if d.index != nil {
+		if err := d.reindex(bucket, k, v, nil); err != nil {
+			return err
+		}
+	}
+	if d.tombstones && v != nil {
+		if err := d.bury(bucket.Tx(), k); err != nil {
+			return err
+		}
+	}
+	d.wrote(bucket.Tx(), EventDelete, k)
+	return nil
+}
+
+// QueryByIndex returns the entries whose index key, as returned by the
+// IndexFunc set with WithIndex, starts with indexPrefix, ordered by index
+// key and then by key. The entries are collected in a single read
+// transaction before being returned.
+//
+// Entries left stale in the index, e.g. by writes made through the bbolt
+// transaction of BoltTx, are checked against the current values and
+// skipped. Keys put before the index was configured are not indexed until
+// they are put again.
+func (d *Datastore) QueryByIndex(ctx context.Context, indexPrefix []byte) (query.Results, error) {
+	if d.index == nil {
+		return nil, ErrNoIndex
+	}
+	ctx = orBackground(ctx)
+	var entries []query.Entry
+	err := d.bolt().View(func(tx *bbolt.Tx) error {
+		bucket, err := d.openBucket(tx)
+		if err != nil {
+			return err
+		}
+		index, err := d.indexBucket(tx, false)
+		if err != nil || index == nil {
+			return err
+		}
+		now := time.Now()
+		c := index.Cursor()
+		for ik, _ := c.Seek(indexPrefix); ik != nil && bytes.HasPrefix(ik, indexPrefix); ik, _ = c.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			keys := index.Bucket(ik)
+			if keys == nil {
+				continue
+			}
+			start, limit := d.scopeBounds()
+			kc := keys.Cursor()
+			k, _ := kc.First()
+			if start != nil {
+				k, _ = kc.Seek(start)
+			}
+			for ; k != nil && (limit == nil || bytes.Compare(k, limit) < 0); k, _ = kc.Next() {
+				v := bucket.Get(k)
+				if v == nil || !bytes.Equal(d.indexKey(k, v), ik) {
+					continue
+				}
+				if _, err := d.liveMeta(v, now); err == datastore.ErrNotFound {
+					continue
+				}
+				entry, err := d.toQueryEntry(k, v, false)
+				if err != nil {
+					return err
+				}
+				entries = append(entries, entry)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return query.ResultsWithEntries(query.Query{}, entries), nil
+}
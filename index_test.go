@@ -0,0 +1,88 @@
+package dsbbolt
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	dskey "github.com/daotl/go-datastore/key"
+	"github.com/stretchr/testify/assert"
+)
+
+// colorIndex indexes the values "color/..." under their color
+func colorIndex(key, value []byte) []byte {
+	if i := bytes.IndexByte(value, '/'); i >= 0 {
+		return value[:i]
+	}
+	return nil
+}
+
+func indexedKeys(t *testing.T, ds *Datastore, prefix string) []string {
+	results, err := ds.QueryByIndex(bg, []byte(prefix))
+	assert.NoError(t, err)
+	entries, err := results.Rest()
+	assert.NoError(t, err)
+	keys := []string{}
+	for _, e := range entries {
+		keys = append(keys, e.Key.String())
+	}
+	return keys
+}
+
+func TestIndex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bolt")
+	ds, err := NewDatastoreWithOptions(path, WithIndex(colorIndex))
+	assert.NoError(t, err)
+	defer ds.Close()
+	put := func(key, value string) {
+		assert.NoError(t, ds.Put(bg, dskey.NewBytesKeyFromString(key), []byte(value)))
+	}
+	put("a", "red/apple")
+	put("b", "green/pear")
+	put("c", "red/cherry")
+	put("d", "unindexed")
+	put("e", "grey/stone")
+
+	assert.Equal(t, []string{"a", "c"}, indexedKeys(t, ds, "red"))
+	assert.Equal(t, []string{"b", "e"}, indexedKeys(t, ds, "gre"))
+	assert.Equal(t, []string{}, indexedKeys(t, ds, "blue"))
+
+	// updates move the key, deletes remove it
+	put("a", "green/apple")
+	assert.NoError(t, ds.Delete(bg, dskey.NewBytesKeyFromString("c")))
+	assert.Equal(t, []string{}, indexedKeys(t, ds, "red"))
+	assert.Equal(t, []string{"a", "b"}, indexedKeys(t, ds, "green"))
+
+	// transactions and batches maintain the index too
+	txn, err := ds.NewTransaction(bg, false)
+	assert.NoError(t, err)
+	assert.NoError(t, txn.Put(bg, dskey.NewBytesKeyFromString("f/rose"), []byte("red/rose")))
+	assert.NoError(t, txn.Delete(bg, dskey.NewBytesKeyFromString("b")))
+	assert.NoError(t, txn.Commit(bg))
+	batch, err := ds.Batch(bg)
+	assert.NoError(t, err)
+	assert.NoError(t, batch.Put(bg, dskey.NewBytesKeyFromString("g"), []byte("red/ruby")))
+	assert.NoError(t, batch.Delete(bg, dskey.NewBytesKeyFromString("a")))
+	assert.NoError(t, batch.Commit(bg))
+	assert.Equal(t, []string{"f/rose", "g"}, indexedKeys(t, ds, "red"))
+	assert.Equal(t, []string{}, indexedKeys(t, ds, "green"))
+
+	n, err := ds.DeletePrefix(bg, dskey.NewBytesKeyFromString("f/"))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []string{"g"}, indexedKeys(t, ds, "red"))
+
+	assert.NoError(t, ds.Truncate(bg))
+	put("h", "red/wine")
+	assert.Equal(t, []string{"h"}, indexedKeys(t, ds, ""))
+
+	// the index persists across reopening
+	assert.NoError(t, ds.Close())
+	ds, err = NewDatastoreWithOptions(path, WithIndex(colorIndex))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"h"}, indexedKeys(t, ds, "red"))
+
+	plain := newTestDatastore(t)
+	_, err = plain.QueryByIndex(bg, []byte("red"))
+	assert.Equal(t, ErrNoIndex, err)
+}
@@ -0,0 +1,128 @@
+package dsbbolt
+
+import (
+	"bytes"
+	"context"
+	"runtime"
+	"time"
+
+	"github.com/daotl/go-datastore"
+	dskey "github.com/daotl/go-datastore/key"
+	"go.etcd.io/bbolt"
+)
+
+// Iterator walks the keys of a datastore in ascending order within a read
+// transaction, see Datastore.Iterator
+type Iterator struct {
+	ds     *Datastore
+	ctx    context.Context
+	tx     *bbolt.Tx
+	cursor *bbolt.Cursor
+	start  []byte
+	limit  []byte // nil to iterate to the last key
+	now    time.Time
+	steps  int
+
+	key   dskey.Key
+	value []byte
+	err   error
+}
+
+// Iterator returns an iterator over the keys a Query with the same prefix
+// would return, a nil prefix iterating over all keys. It is a lighter
+// alternative to Query for plain ordered walks.
+//
+// The iterator holds a read transaction until Close is called. An iterator
+// that is dropped without being closed releases it once garbage collected,
+// but long lived read transactions prevent bbolt from reusing pages, so
+// iterators should always be closed.
+func (d *Datastore) Iterator(ctx context.Context, prefix dskey.Key) (*Iterator, error) {
+	if keyTypeMismatch(prefix, d.ktype) {
+		return nil, ErrKeyTypeNotMatch
+	}
+	tx, err := d.bolt().Begin(false)
+	if err != nil {
+		return nil, err
+	}
+	bucket, err := d.openBucket(tx)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	it := &Iterator{ds: d, ctx: orBackground(ctx), tx: tx, cursor: bucket.Cursor(), now: time.Now()}
+	if it.start, it.limit, err = d.prefixBounds(prefix); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	runtime.SetFinalizer(it, (*Iterator).Close)
+	return it, nil
+}
+
+// Next advances the iterator to the next key, it returns false once there
+// are no keys left or an error occurred, see Err
+func (it *Iterator) Next() bool {
+	if it.tx == nil || it.err != nil {
+		return false
+	}
+	for {
+		if it.steps%queryCheckInterval == 0 {
+			if it.err = it.ctx.Err(); it.err != nil {
+				return false
+			}
+		}
+		var k, v []byte
+		if it.steps == 0 {
+			if it.start == nil {
+				k, v = it.cursor.First()
+			} else {
+				k, v = it.cursor.Seek(it.start)
+			}
+		} else {
+			k, v = it.cursor.Next()
+		}
+		it.steps++
+		if k == nil || it.limit != nil && bytes.Compare(k, it.limit) >= 0 {
+			it.key, it.value = nil, nil
+			return false
+		}
+		if v == nil {
+			// nested bucket
+			continue
+		}
+		if _, err := it.ds.liveMeta(v, it.now); err == datastore.ErrNotFound {
+			continue
+		}
+		if it.value, it.err = it.ds.decodeValue(k, v); it.err != nil {
+			return false
+		}
+		it.key = dskey.NewBytesKey(copyBytes(it.ds.userKey(k)))
+		return true
+	}
+}
+
+// Key returns the current key
+func (it *Iterator) Key() dskey.Key {
+	return it.key
+}
+
+// Value returns the value of the current key
+func (it *Iterator) Value() []byte {
+	return it.value
+}
+
+// Err returns the error that stopped the iteration, if any
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// Close releases the read transaction of the iterator, it can be called
+// more than once
+func (it *Iterator) Close() error {
+	if it.tx == nil {
+		return nil
+	}
+	runtime.SetFinalizer(it, nil)
+	tx := it.tx
+	it.tx, it.cursor = nil, nil
+	return tx.Rollback()
+}
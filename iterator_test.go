@@ -0,0 +1,59 @@
+package dsbbolt
+
+import (
+	"context"
+	"testing"
+
+	dskey "github.com/daotl/go-datastore/key"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIterator(t *testing.T) {
+	ds := newTestDatastore(t)
+	keys := benchmarkKeys(100)
+	// insert in reverse order to check the iterator sorts
+	for i := len(keys) - 1; i >= 0; i-- {
+		assert.NoError(t, ds.Put(bg, keys[i], keys[i].Bytes()))
+	}
+	assert.NoError(t, ds.Put(bg, dskey.NewBytesKeyFromString("other"), nil))
+
+	it, err := ds.Iterator(bg, dskey.NewBytesKeyFromString("bench-"))
+	assert.NoError(t, err)
+	var got []dskey.Key
+	for it.Next() {
+		assert.Equal(t, it.Key().Bytes(), it.Value())
+		got = append(got, it.Key())
+	}
+	assert.NoError(t, it.Err())
+	assert.Equal(t, keys, got)
+	assert.False(t, it.Next())
+	assert.NoError(t, it.Close())
+	assert.NoError(t, it.Close())
+	assert.False(t, it.Next())
+	assert.Equal(t, 0, ds.bolt().Stats().OpenTxN)
+
+	it, err = ds.Iterator(bg, nil)
+	assert.NoError(t, err)
+	n := 0
+	for it.Next() {
+		n++
+	}
+	assert.Equal(t, len(keys)+1, n)
+	assert.NoError(t, it.Close())
+
+	// the context is checked every queryCheckInterval keys
+	var entries []KeyValue
+	for _, k := range benchmarkKeys(2 * queryCheckInterval) {
+		entries = append(entries, KeyValue{Key: k})
+	}
+	assert.NoError(t, ds.PutMany(bg, entries))
+	ctx, cancel := context.WithCancel(bg)
+	it, err = ds.Iterator(ctx, nil)
+	assert.NoError(t, err)
+	defer it.Close()
+	assert.True(t, it.Next())
+	cancel()
+	for it.Next() {
+	}
+	assert.Equal(t, context.Canceled, it.Err())
+}
@@ -0,0 +1,57 @@
+package dsbbolt
+
+import (
+	"encoding/binary"
+
+	dskey "github.com/daotl/go-datastore/key"
+	"go.etcd.io/bbolt"
+)
+
+// keyTypesBucket is the bucket of metaBucket recording the key type each
+// datastore bucket was created with, keyed by bucketPathKey
+var keyTypesBucket = []byte("keytypes")
+
+// bucketPathKey encodes bucketPath as length prefixed names, so that
+// different paths never share a key
+func bucketPathKey(bucketPath [][]byte) []byte {
+	var key []byte
+	for _, name := range bucketPath {
+		key = appendUvarint(key, uint64(len(name)))
+		key = append(key, name...)
+	}
+	return key
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+// checkKeyType returns ErrKeyTypeNotMatch if the key type recorded for
+// bucketPath is not ktype. Buckets without a record, created before key
+// types were recorded, get ktype recorded when tx is writable.
+func checkKeyType(tx *bbolt.Tx, bucketPath [][]byte, ktype dskey.KeyType) error {
+	key := bucketPathKey(bucketPath)
+	if b := tx.Bucket(metaBucket); b != nil {
+		if types := b.Bucket(keyTypesBucket); types != nil {
+			if v := types.Get(key); v != nil {
+				if len(v) != 1 || dskey.KeyType(v[0]) != ktype {
+					return ErrKeyTypeNotMatch
+				}
+				return nil
+			}
+		}
+	}
+	if !tx.Writable() {
+		return nil
+	}
+	b, err := tx.CreateBucketIfNotExists(metaBucket)
+	if err != nil {
+		return err
+	}
+	types, err := b.CreateBucketIfNotExists(keyTypesBucket)
+	if err != nil {
+		return err
+	}
+	return types.Put(key, []byte{byte(ktype)})
+}
@@ -0,0 +1,16 @@
+package dsbbolt
+
+// Logger receives the log messages of a Datastore, with key and value pairs
+// giving their context, e.g. to forward them to a structured logger
+type Logger interface {
+	Debug(msg string, keysAndValues ...interface{})
+	Warn(msg string, keysAndValues ...interface{})
+	Error(msg string, keysAndValues ...interface{})
+}
+
+// nopLogger is the Logger of datastores configured without one
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...interface{}) {}
+func (nopLogger) Warn(string, ...interface{})  {}
+func (nopLogger) Error(string, ...interface{}) {}
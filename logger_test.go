@@ -0,0 +1,65 @@
+package dsbbolt
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+
+	dskey "github.com/daotl/go-datastore/key"
+	"github.com/stretchr/testify/assert"
+)
+
+type logEntry struct {
+	level         string
+	msg           string
+	keysAndValues []interface{}
+}
+
+type recordingLogger struct {
+	mu      sync.Mutex
+	entries []logEntry
+}
+
+func (l *recordingLogger) log(level, msg string, keysAndValues []interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, logEntry{level, msg, keysAndValues})
+}
+
+func (l *recordingLogger) Debug(msg string, keysAndValues ...interface{}) {
+	l.log("debug", msg, keysAndValues)
+}
+
+func (l *recordingLogger) Warn(msg string, keysAndValues ...interface{}) {
+	l.log("warn", msg, keysAndValues)
+}
+
+func (l *recordingLogger) Error(msg string, keysAndValues ...interface{}) {
+	l.log("error", msg, keysAndValues)
+}
+
+func TestLogger(t *testing.T) {
+	logger := &recordingLogger{}
+	ds, err := NewDatastoreWithOptions(filepath.Join(t.TempDir(), "bolt"), WithLogger(logger))
+	assert.NoError(t, err)
+	defer ds.Close()
+
+	txn, err := ds.NewTransaction(bg, false)
+	assert.NoError(t, err)
+	assert.NoError(t, txn.Put(bg, dskey.NewBytesKeyFromString("key"), []byte("value")))
+	assert.NoError(t, txn.Commit(bg))
+	// discarding a committed transaction is a no-op
+	txn.Discard(bg)
+	txn, err = ds.NewTransaction(bg, true)
+	assert.NoError(t, err)
+	txn.Discard(bg)
+
+	var levels, msgs []string
+	for _, e := range logger.entries {
+		levels = append(levels, e.level)
+		msgs = append(msgs, e.msg)
+	}
+	assert.Equal(t, []string{"debug", "debug", "debug", "debug"}, levels)
+	assert.Equal(t, []string{"begin transaction", "commit transaction", "begin transaction", "rollback transaction"}, msgs)
+	assert.Equal(t, []interface{}{"txid", logger.entries[0].keysAndValues[1]}, logger.entries[1].keysAndValues)
+}
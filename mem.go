@@ -0,0 +1,32 @@
+package dsbbolt
+
+import (
+	"os"
+
+	dskey "github.com/daotl/go-datastore/key"
+	"go.etcd.io/bbolt"
+)
+
+// NewMemDatastore instantiates a datastore for tests, stored in a
+// temporary file under os.TempDir that is removed on Close. bbolt cannot
+// keep a db in memory only, but the file is opened with NoSync so writes
+// are not flushed to disk, and a tmpfs os.TempDir keeps it in memory.
+func NewMemDatastore(bucket []byte, keytype dskey.KeyType) (*Datastore, error) {
+	f, err := os.CreateTemp("", "dsbbolt-*.db")
+	if err != nil {
+		return nil, err
+	}
+	path := f.Name()
+	f.Close()
+	ds, err := NewDatastoreWithConfig(path, Config{
+		BoltOptions: &bbolt.Options{NoSync: true, NoFreelistSync: true, FreelistType: bbolt.FreelistMapType},
+		Bucket:      bucket,
+		KeyType:     keytype,
+	})
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	ds.db.temp = true
+	return ds, nil
+}
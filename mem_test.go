@@ -0,0 +1,28 @@
+package dsbbolt
+
+import (
+	"os"
+	"testing"
+
+	dskey "github.com/daotl/go-datastore/key"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemDatastore(t *testing.T) {
+	ds, err := NewMemDatastore([]byte("mem"), dskey.KeyTypeBytes)
+	assert.NoError(t, err)
+	path := ds.bolt().Path()
+	key := dskey.NewBytesKeyFromString("foo")
+	assert.NoError(t, ds.Put(bg, key, []byte("bar")))
+	value, err := ds.Get(bg, key)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("bar"), value)
+
+	assert.NoError(t, ds.Close())
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+	assert.NoError(t, ds.Close())
+
+	_, err = NewMemDatastore([]byte("mem"), dskey.KeyTypeString)
+	assert.Equal(t, ErrKeyTypeNotMatch, err)
+}
@@ -0,0 +1,237 @@
+package dsbbolt
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/daotl/go-datastore"
+	dskey "github.com/daotl/go-datastore/key"
+	"go.etcd.io/bbolt"
+)
+
+var (
+	// ErrNoMetadata is returned by GetWithMeta when metadata is not enabled
+	ErrNoMetadata = errors.New("metadata is not enabled")
+	// ErrVersionConflict is returned by PutIfVersion when the version of
+	// the key is not the expected one
+	ErrVersionConflict = errors.New("version conflict")
+)
+
+// metaHeaderSize is the size of the header prefixed to stored values when
+// metadata is enabled: flags, write time in Unix nanoseconds and version,
+// followed by the optional fields the flags announce
+const metaHeaderSize = 1 + 8 + 8
+
+// header flags announcing optional fields
+const (
+	metaFlagExpires = 1 << iota // expiration time in Unix nanoseconds
+
+	metaFlagsKnown = metaFlagExpires
+)
+
+// Meta is the metadata stored alongside a value
+type Meta struct {
+	Written    time.Time // time of the last write
+	Version    uint64    // number of writes, starting at 1
+	Expiration time.Time // time the value expires at, zero if it never does
+}
+
+// appendMeta appends the header of m and value to dst
+func appendMeta(dst []byte, m Meta, value []byte) []byte {
+	var header [metaHeaderSize + 8]byte
+	n := metaHeaderSize
+	binary.BigEndian.PutUint64(header[1:], uint64(m.Written.UnixNano()))
+	binary.BigEndian.PutUint64(header[9:], m.Version)
+	if !m.Expiration.IsZero() {
+		header[0] |= metaFlagExpires
+		binary.BigEndian.PutUint64(header[n:], uint64(m.Expiration.UnixNano()))
+		n += 8
+	}
+	return append(append(dst, header[:n]...), value...)
+}
+
+// parseMeta splits stored into its metadata and the value following it
+func parseMeta(stored []byte) (Meta, []byte, error) {
+	if len(stored) < metaHeaderSize || stored[0]&^metaFlagsKnown != 0 {
+		return Meta{}, nil, ErrInvalidValue
+	}
+	flags := stored[0]
+	m := Meta{
+		Written: time.Unix(0, int64(binary.BigEndian.Uint64(stored[1:]))),
+		Version: binary.BigEndian.Uint64(stored[9:]),
+	}
+	stored = stored[metaHeaderSize:]
+	if flags&metaFlagExpires != 0 {
+		if len(stored) < 8 {
+			return Meta{}, nil, ErrInvalidValue
+		}
+		m.Expiration = time.Unix(0, int64(binary.BigEndian.Uint64(stored)))
+		stored = stored[8:]
+	}
+	return m, stored, nil
+}
+
+// expired returns whether m has an expiration time that is not after now
+func (m Meta) expired(now time.Time) bool {
+	return !m.Expiration.IsZero() && !now.Before(m.Expiration)
+}
+
+// putStored puts the encoded value under key in bucket, prefixed with a
+// metadata header when metadata is enabled
+func (d *Datastore) putStored(bucket *bbolt.Bucket, key, value []byte) error {
+	return d.putStoredWithExpiration(bucket, key, value, time.Time{})
+}
+
+// putStoredWithExpiration is putStored for a value expiring at expiration,
+// the zero time meaning it never does. It requires metadata to be enabled.
+func (d *Datastore) putStoredWithExpiration(bucket *bbolt.Bucket, key, value []byte, expiration time.Time) error {
+	if d.bloom != nil {
+		// added before the commit, a rolled back put is a false positive
+		d.bloom.add(key)
+	}
+	prev := bucket.Get(key)
+	stored := value
+	if d.meta {
+		m := Meta{Written: time.Now(), Version: 1, Expiration: expiration}
+		if prev != nil {
+			// values written before metadata was enabled restart at version 1
+			if pm, _, err := parseMeta(prev); err == nil {
+				m.Version = pm.Version + 1
+			}
+		}
+		stored = appendMeta(make([]byte, 0, metaHeaderSize+len(value)), m, value)
+	}
+	if d.index != nil {
+		if err := d.reindex(bucket, key, prev, stored); err != nil {
+			return err
+		}
+	}
+	if d.tombstones {
+		if err := d.unbury(bucket.Tx(), key); err != nil {
+			return err
+		}
+	}
+	d.wrote(bucket.Tx(), EventPut, key)
+	return bucket.Put(key, stored)
+}
+
+// wrote reports the write of the stored key to the watchers, the sync
+// policy and the query cache once tx commits
+func (d *Datastore) wrote(tx *bbolt.Tx, op EventOp, key []byte) {
+	d.notify(tx, op, key)
+	d.countWrite(tx)
+	d.invalidateQueries(tx)
+}
+
+// liveMeta returns the metadata of the stored value, or ErrNotFound if the
+// value has expired. Without metadata every value is live.
+func (d *Datastore) liveMeta(stored []byte, now time.Time) (Meta, error) {
+	if !d.meta {
+		return Meta{}, nil
+	}
+	m, _, err := parseMeta(stored)
+	if err != nil {
+		return Meta{}, err
+	}
+	if m.expired(now) {
+		return Meta{}, datastore.ErrNotFound
+	}
+	return m, nil
+}
+
+// GetWithMeta returns the value of key along with its metadata, metadata
+// must have been enabled with WithMetadata
+func (d *Datastore) GetWithMeta(ctx context.Context, key dskey.Key) ([]byte, Meta, error) {
+	if key.KeyType() != d.ktype {
+		return nil, Meta{}, ErrKeyTypeNotMatch
+	}
+	if !d.meta {
+		return nil, Meta{}, ErrNoMetadata
+	}
+	k, err := d.storedKey(key)
+	if err != nil {
+		return nil, Meta{}, err
+	}
+	var value []byte
+	var m Meta
+	if err := d.bolt().View(func(tx *bbolt.Tx) error {
+		bucket, err := d.openBucket(tx)
+		if err != nil {
+			return err
+		}
+		data := bucket.Get(k)
+		if data == nil {
+			return datastore.ErrNotFound
+		}
+		if m, err = d.liveMeta(data, time.Now()); err != nil {
+			return err
+		}
+		value, err = d.decodeValue(k, data)
+		return err
+	}); err != nil {
+		return nil, Meta{}, err
+	}
+	return value, m, nil
+}
+
+// GetVersioned returns the value of key along with its version, the number
+// of times it was put since it was created, see GetWithMeta. Passing the
+// version to PutIfVersion detects the writes made in between, by this or
+// another process.
+func (d *Datastore) GetVersioned(ctx context.Context, key dskey.Key) ([]byte, uint64, error) {
+	value, m, err := d.GetWithMeta(ctx, key)
+	if err != nil {
+		return nil, 0, err
+	}
+	return value, m.Version, nil
+}
+
+// PutIfVersion puts value under key if its version is expectedVersion, 0
+// meaning that the key must be absent, and returns ErrVersionConflict
+// otherwise. The check and the put happen in the same write transaction.
+// Metadata must have been enabled with WithMetadata. A deleted key starts
+// over at version 1 when it is put again.
+func (d *Datastore) PutIfVersion(ctx context.Context, key dskey.Key, value []byte, expectedVersion uint64) error {
+	if key.KeyType() != d.ktype {
+		return ErrKeyTypeNotMatch
+	}
+	if !d.meta {
+		return ErrNoMetadata
+	}
+	if d.bolt().IsReadOnly() {
+		return ErrReadOnly
+	}
+	k, err := d.storedKey(key)
+	if err != nil {
+		return err
+	}
+	if err := d.checkEntry(k, value); err != nil {
+		return err
+	}
+	encoded, err := d.encodeValue(k, value)
+	if err != nil {
+		return err
+	}
+	return d.update(ctx, func(tx *bbolt.Tx) error {
+		bucket, err := d.openBucket(tx)
+		if err != nil {
+			return err
+		}
+		var version uint64
+		if data := bucket.Get(k); data != nil {
+			switch m, err := d.liveMeta(data, time.Now()); err {
+			case nil:
+				version = m.Version
+			case datastore.ErrNotFound:
+			default:
+				return err
+			}
+		}
+		if version != expectedVersion {
+			return ErrVersionConflict
+		}
+		return d.putStored(bucket, k, encoded)
+	})
+}
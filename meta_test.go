@@ -0,0 +1,93 @@
+package dsbbolt
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	dskey "github.com/daotl/go-datastore/key"
+	"github.com/daotl/go-datastore/query"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetadata(t *testing.T) {
+	ds, err := NewDatastoreWithOptions(filepath.Join(t.TempDir(), "bolt"), WithMetadata())
+	assert.NoError(t, err)
+	defer ds.Close()
+
+	key := dskey.NewBytesKeyFromString("foo")
+	before := time.Now()
+	assert.NoError(t, ds.Put(bg, key, []byte("bar")))
+	value, m, err := ds.GetWithMeta(bg, key)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("bar"), value)
+	assert.Equal(t, uint64(1), m.Version)
+	assert.False(t, m.Written.Before(before))
+	assert.True(t, time.Since(m.Written) < time.Minute)
+
+	// the header is invisible to regular reads
+	value, err = ds.Get(bg, key)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("bar"), value)
+	size, err := ds.GetSize(bg, key)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, size)
+	results, err := ds.Query(bg, query.Query{ReturnsSizes: true})
+	assert.NoError(t, err)
+	entries, err := results.Rest()
+	assert.NoError(t, err)
+	assert.Equal(t, []query.Entry{{Key: key, Value: []byte("bar"), Size: 3}}, entries)
+
+	// every write path bumps the version
+	txn, err := ds.NewTransaction(bg, false)
+	assert.NoError(t, err)
+	assert.NoError(t, txn.Put(bg, key, []byte("baz")))
+	assert.NoError(t, txn.Commit(bg))
+	batch, err := ds.Batch(bg)
+	assert.NoError(t, err)
+	assert.NoError(t, batch.Put(bg, key, []byte("qux")))
+	assert.NoError(t, batch.Commit(bg))
+	assert.NoError(t, ds.PutMany(bg, []KeyValue{{Key: key, Value: []byte("quux")}}))
+	value, m, err = ds.GetWithMeta(bg, key)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("quux"), value)
+	assert.Equal(t, uint64(4), m.Version)
+
+	plain := newTestDatastore(t)
+	assert.NoError(t, plain.Put(bg, key, []byte("bar")))
+	_, _, err = plain.GetWithMeta(bg, key)
+	assert.Equal(t, ErrNoMetadata, err)
+}
+
+func TestPutIfVersion(t *testing.T) {
+	ds, err := NewDatastoreWithOptions(filepath.Join(t.TempDir(), "bolt"), WithMetadata())
+	assert.NoError(t, err)
+	defer ds.Close()
+	key := dskey.NewBytesKeyFromString("doc")
+
+	assert.Equal(t, ErrVersionConflict, ds.PutIfVersion(bg, key, []byte("v1"), 1))
+	assert.NoError(t, ds.PutIfVersion(bg, key, []byte("v1"), 0))
+	value, version, err := ds.GetVersioned(bg, key)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("v1"), value)
+	assert.Equal(t, uint64(1), version)
+
+	// a concurrent write between the get and the put is detected
+	assert.NoError(t, ds.Put(bg, key, []byte("concurrent")))
+	assert.Equal(t, ErrVersionConflict, ds.PutIfVersion(bg, key, append(value, '+'), version))
+	value, version, err = ds.GetVersioned(bg, key)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("concurrent"), value)
+	assert.Equal(t, uint64(2), version)
+	assert.NoError(t, ds.PutIfVersion(bg, key, append(value, '+'), version))
+	value, version, err = ds.GetVersioned(bg, key)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("concurrent+"), value)
+	assert.Equal(t, uint64(3), version)
+	assert.Equal(t, ErrVersionConflict, ds.PutIfVersion(bg, key, nil, 0))
+
+	plain := newTestDatastore(t)
+	assert.Equal(t, ErrNoMetadata, plain.PutIfVersion(bg, key, nil, 0))
+	_, _, err = plain.GetVersioned(bg, key)
+	assert.Equal(t, ErrNoMetadata, err)
+}
@@ -0,0 +1,27 @@
+package dsbbolt
+
+import "time"
+
+// Operation names passed to MetricsHook.ObserveOp
+const (
+	OpGet     = "get"
+	OpHas     = "has"
+	OpGetSize = "getsize"
+	OpPut     = "put"
+	OpDelete  = "delete"
+	OpQuery   = "query"
+	OpSync    = "sync"
+)
+
+// MetricsHook receives the duration and outcome of every Datastore
+// operation, e.g. to export latency and error metrics
+type MetricsHook interface {
+	// ObserveOp is called once an operation named op returned err after dur
+	ObserveOp(op string, dur time.Duration, err error)
+}
+
+// observe reports the operation op started at start to the metrics hook,
+// it must only be called when d.metrics is set
+func (d *Datastore) observe(op string, start time.Time, err *error) {
+	d.metrics.ObserveOp(op, time.Since(start), *err)
+}
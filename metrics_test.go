@@ -0,0 +1,58 @@
+package dsbbolt
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/daotl/go-datastore"
+	dskey "github.com/daotl/go-datastore/key"
+	"github.com/daotl/go-datastore/query"
+	"github.com/stretchr/testify/assert"
+)
+
+type opEvent struct {
+	op  string
+	dur time.Duration
+	err error
+}
+
+type recordingHook struct {
+	events []opEvent
+}
+
+func (h *recordingHook) ObserveOp(op string, dur time.Duration, err error) {
+	h.events = append(h.events, opEvent{op, dur, err})
+}
+
+func TestMetricsHook(t *testing.T) {
+	hook := &recordingHook{}
+	ds, err := NewDatastoreWithOptions(filepath.Join(t.TempDir(), "bolt"), WithMetricsHook(hook))
+	assert.NoError(t, err)
+	defer ds.Close()
+
+	key := dskey.NewBytesKeyFromString("foo")
+	assert.NoError(t, ds.Put(bg, key, []byte("bar")))
+	hook.events = nil
+	_, err = ds.Get(bg, key)
+	assert.NoError(t, err)
+	if assert.Len(t, hook.events, 1) {
+		assert.Equal(t, OpGet, hook.events[0].op)
+		assert.True(t, hook.events[0].dur > 0)
+		assert.NoError(t, hook.events[0].err)
+	}
+
+	hook.events = nil
+	_, err = ds.Get(bg, dskey.NewBytesKeyFromString("missing"))
+	assert.Equal(t, datastore.ErrNotFound, err)
+	assert.NoError(t, ds.Delete(bg, key))
+	results, err := ds.Query(bg, query.Query{})
+	assert.NoError(t, err)
+	assert.NoError(t, results.Close())
+	var ops []string
+	for _, e := range hook.events {
+		ops = append(ops, e.op)
+	}
+	assert.Equal(t, []string{OpGet, OpDelete, OpQuery}, ops)
+	assert.Equal(t, datastore.ErrNotFound, hook.events[0].err)
+}
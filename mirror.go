@@ -0,0 +1,88 @@
+package dsbbolt
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/daotl/go-datastore"
+	dskey "github.com/daotl/go-datastore/key"
+	"github.com/daotl/go-datastore/query"
+)
+
+var _ datastore.Datastore = (*MirrorDatastore)(nil)
+
+// MirrorDatastore forwards the writes made through it to a primary and a
+// secondary Datastore, and reads from the primary only, e.g. to fill a new
+// db file while the old one keeps serving reads until the cutover.
+//
+// A write is made to the primary first, and then to the secondary only if
+// it succeeded. The two writes are separate transactions: an error of the
+// secondary, wrapped as "secondary: <err>", means the primary was written
+// and the files diverged, and a crash in between leaves the write in the
+// primary only. Writes made to the primary directly are not mirrored.
+type MirrorDatastore struct {
+	primary, secondary *Datastore
+}
+
+// NewMirrorDatastore returns a MirrorDatastore writing to primary and
+// secondary, which it closes on Close. At the cutover, the secondary can
+// be used directly and the primary closed instead of the mirror.
+func NewMirrorDatastore(primary, secondary *Datastore) *MirrorDatastore {
+	return &MirrorDatastore{primary: primary, secondary: secondary}
+}
+
+func (m *MirrorDatastore) Get(ctx context.Context, key dskey.Key) ([]byte, error) {
+	return m.primary.Get(ctx, key)
+}
+
+func (m *MirrorDatastore) Has(ctx context.Context, key dskey.Key) (bool, error) {
+	return m.primary.Has(ctx, key)
+}
+
+func (m *MirrorDatastore) GetSize(ctx context.Context, key dskey.Key) (int, error) {
+	return m.primary.GetSize(ctx, key)
+}
+
+func (m *MirrorDatastore) Query(ctx context.Context, q query.Query) (query.Results, error) {
+	return m.primary.Query(ctx, q)
+}
+
+func (m *MirrorDatastore) Put(ctx context.Context, key dskey.Key, value []byte) error {
+	if err := m.primary.Put(ctx, key, value); err != nil {
+		return err
+	}
+	if err := m.secondary.Put(ctx, key, value); err != nil {
+		return fmt.Errorf("secondary: %w", err)
+	}
+	return nil
+}
+
+func (m *MirrorDatastore) Delete(ctx context.Context, key dskey.Key) error {
+	if err := m.primary.Delete(ctx, key); err != nil {
+		return err
+	}
+	if err := m.secondary.Delete(ctx, key); err != nil {
+		return fmt.Errorf("secondary: %w", err)
+	}
+	return nil
+}
+
+// Sync syncs the primary and then the secondary
+func (m *MirrorDatastore) Sync(ctx context.Context, prefix dskey.Key) error {
+	if err := m.primary.Sync(ctx, prefix); err != nil {
+		return err
+	}
+	if err := m.secondary.Sync(ctx, prefix); err != nil {
+		return fmt.Errorf("secondary: %w", err)
+	}
+	return nil
+}
+
+// Close closes the primary and the secondary and returns the first error
+func (m *MirrorDatastore) Close() error {
+	err := m.primary.Close()
+	if serr := m.secondary.Close(); serr != nil && err == nil {
+		err = fmt.Errorf("secondary: %w", serr)
+	}
+	return err
+}
@@ -0,0 +1,64 @@
+package dsbbolt
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/daotl/go-datastore"
+	dskey "github.com/daotl/go-datastore/key"
+	"github.com/daotl/go-datastore/query"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMirrorDatastore(t *testing.T) {
+	primary := newTestDatastore(t)
+	secondary, err := NewDatastoreWithOptions(filepath.Join(t.TempDir(), "bolt"), WithMaxValueSize(8))
+	assert.NoError(t, err)
+	m := NewMirrorDatastore(primary, secondary)
+
+	keys := benchmarkKeys(100)
+	for _, k := range keys {
+		assert.NoError(t, m.Put(bg, k, k.Bytes()[:8]))
+	}
+	assert.NoError(t, m.Delete(bg, keys[0]))
+	for _, ds := range []*Datastore{primary, secondary} {
+		n, err := ds.Count(bg, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, 99, n)
+		_, err = ds.Get(bg, keys[0])
+		assert.Equal(t, datastore.ErrNotFound, err)
+		value, err := ds.Get(bg, keys[1])
+		assert.NoError(t, err)
+		assert.Equal(t, keys[1].Bytes()[:8], value)
+	}
+
+	// reads come from the primary only
+	only := dskey.NewBytesKeyFromString("primary-only")
+	assert.NoError(t, primary.Put(bg, only, []byte("v")))
+	has, err := m.Has(bg, only)
+	assert.NoError(t, err)
+	assert.True(t, has)
+	size, err := m.GetSize(bg, only)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, size)
+	results, err := m.Query(bg, query.Query{KeysOnly: true})
+	assert.NoError(t, err)
+	entries, err := results.Rest()
+	assert.NoError(t, err)
+	assert.Len(t, entries, 100)
+
+	// a write failing on the secondary is kept by the primary
+	err = m.Put(bg, keys[0], []byte("too large"))
+	assert.True(t, errors.Is(err, ErrValueTooLarge))
+	value, err := m.Get(bg, keys[0])
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("too large"), value)
+	_, err = secondary.Get(bg, keys[0])
+	assert.Equal(t, datastore.ErrNotFound, err)
+
+	assert.NoError(t, m.Sync(bg, nil))
+	assert.NoError(t, m.Close())
+	_, err = secondary.Get(bg, keys[1])
+	assert.Error(t, err)
+}
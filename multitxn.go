@@ -0,0 +1,102 @@
+package dsbbolt
+
+import (
+	"bytes"
+	"context"
+
+	dskey "github.com/daotl/go-datastore/key"
+)
+
+// MultiBucketTxn is a write transaction spanning several top-level buckets
+// of a db, the buckets WithBucket gives datastores for, so that writes to
+// all of them are committed or discarded together
+type MultiBucketTxn struct {
+	root    *txn
+	buckets map[string]*txn
+}
+
+// NewMultiBucketTxn begins a write transaction spanning any top-level
+// bucket of the db of d, created if it does not exist, as well as the
+// bucket of d itself. It has to be committed or discarded like the
+// transactions of NewTransaction.
+func (d *Datastore) NewMultiBucketTxn(ctx context.Context) (*MultiBucketTxn, error) {
+	t, err := d.NewTransaction(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	return &MultiBucketTxn{root: t.(*txn), buckets: make(map[string]*txn)}, nil
+}
+
+// bucket returns the transaction on the top-level bucket name, sharing the
+// bbolt transaction of m
+func (m *MultiBucketTxn) bucket(name []byte) (*txn, error) {
+	if t, ok := m.buckets[string(name)]; ok {
+		return t, nil
+	}
+	bucketPath := [][]byte{copyBytes(name)}
+	if err := validateBucketPath(bucketPath); err != nil {
+		return nil, err
+	}
+	tx, d := m.root.tx, m.root.ds
+	if len(d.bucket) == 1 && bytes.Equal(name, d.bucket[0]) {
+		// keeps the Bloom filter and index of d up to date
+		m.buckets[string(name)] = m.root
+		return m.root, nil
+	}
+	bucket, err := tx.CreateBucketIfNotExists(bucketPath[0])
+	if err != nil {
+		return nil, err
+	}
+	if err := checkKeyType(tx, bucketPath, d.ktype); err != nil {
+		return nil, err
+	}
+	// like the datastores returned by WithBucket
+	view := *d
+	view.bucket = bucketPath
+	view.ownsDB = false
+	view.bloom = nil
+	view.index = nil
+	view.queryCache = nil
+	t := &txn{ds: &view, tx: tx, id: m.root.id, ktype: d.ktype, bucket: bucket}
+	m.buckets[string(name)] = t
+	return t, nil
+}
+
+// Get returns the value of key in the top-level bucket named bucket
+func (m *MultiBucketTxn) Get(ctx context.Context, bucket []byte, key dskey.Key) ([]byte, error) {
+	t, err := m.bucket(bucket)
+	if err != nil {
+		return nil, err
+	}
+	return t.Get(ctx, key)
+}
+
+// Put puts value under key in the top-level bucket named bucket
+func (m *MultiBucketTxn) Put(ctx context.Context, bucket []byte, key dskey.Key, value []byte) error {
+	t, err := m.bucket(bucket)
+	if err != nil {
+		return err
+	}
+	return t.Put(ctx, key, value)
+}
+
+// Delete deletes key from the top-level bucket named bucket
+func (m *MultiBucketTxn) Delete(ctx context.Context, bucket []byte, key dskey.Key) error {
+	t, err := m.bucket(bucket)
+	if err != nil {
+		return err
+	}
+	return t.Delete(ctx, key)
+}
+
+// Commit commits the writes to every bucket, see the Commit of
+// NewTransaction
+func (m *MultiBucketTxn) Commit(ctx context.Context) error {
+	return m.root.Commit(ctx)
+}
+
+// Discard discards the writes to every bucket, see the Discard of
+// NewTransaction
+func (m *MultiBucketTxn) Discard(ctx context.Context) {
+	m.root.Discard(ctx)
+}
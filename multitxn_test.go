@@ -0,0 +1,58 @@
+package dsbbolt
+
+import (
+	"testing"
+
+	"github.com/daotl/go-datastore"
+	dskey "github.com/daotl/go-datastore/key"
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/bbolt"
+)
+
+func TestMultiBucketTxn(t *testing.T) {
+	ds := newTestDatastore(t)
+	users, orders := []byte("users"), []byte("orders")
+	key := dskey.NewBytesKeyFromString("alice")
+
+	// discarded writes persist in none of the buckets
+	m, err := ds.NewMultiBucketTxn(bg)
+	assert.NoError(t, err)
+	assert.NoError(t, m.Put(bg, users, key, []byte("user")))
+	assert.NoError(t, m.Put(bg, orders, key, []byte("order")))
+	value, err := m.Get(bg, users, key)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("user"), value)
+	m.Discard(bg)
+	assert.NoError(t, ds.bolt().View(func(tx *bbolt.Tx) error {
+		assert.Nil(t, tx.Bucket(users))
+		assert.Nil(t, tx.Bucket(orders))
+		return nil
+	}))
+
+	m, err = ds.NewMultiBucketTxn(bg)
+	assert.NoError(t, err)
+	assert.NoError(t, m.Put(bg, users, key, []byte("user")))
+	assert.NoError(t, m.Put(bg, orders, key, []byte("order")))
+	assert.NoError(t, m.Put(bg, defaultBucket, key, []byte("default")))
+	assert.NoError(t, m.Commit(bg))
+	assert.Equal(t, ErrTxnClosed, m.Commit(bg))
+	for bucket, want := range map[string]string{"users": "user", "orders": "order"} {
+		view, err := ds.WithBucket([]byte(bucket))
+		assert.NoError(t, err)
+		value, err := view.Get(bg, key)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte(want), value)
+	}
+	value, err = ds.Get(bg, key)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("default"), value)
+
+	m, err = ds.NewMultiBucketTxn(bg)
+	assert.NoError(t, err)
+	defer m.Discard(bg)
+	assert.NoError(t, m.Delete(bg, users, key))
+	_, err = m.Get(bg, users, key)
+	assert.Equal(t, datastore.ErrNotFound, err)
+	assert.Error(t, m.Put(bg, metaBucket, key, nil))
+	assert.Error(t, m.Put(bg, nil, key, nil))
+}
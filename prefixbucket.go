@@ -0,0 +1,152 @@
+package dsbbolt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/daotl/go-datastore"
+	dskey "github.com/daotl/go-datastore/key"
+	"github.com/daotl/go-datastore/query"
+)
+
+var _ datastore.Datastore = (*PrefixBucketDatastore)(nil)
+
+// PrefixBucketDatastore stores the keys starting with each of a few
+// registered prefixes in a bucket of their own, and the other keys in the
+// bucket of the Datastore it was returned by, so that scanning a prefix
+// only reads the pages of its bucket and writes to different prefixes do
+// not touch the same pages. Keys are stored whole, the buckets are
+// transparent to callers.
+type PrefixBucketDatastore struct {
+	ds       *Datastore
+	prefixes [][]byte     // longest first
+	buckets  []*Datastore // the datastore of each of prefixes
+}
+
+// WithPrefixBuckets returns a PrefixBucketDatastore routing the keys
+// starting with one of prefixes to a top-level bucket of the db of d
+// dedicated to that prefix, created if it does not exist, and the other
+// keys to d. A key matching several prefixes goes to the bucket of the
+// longest. Keys written directly through d, or before a prefix was
+// registered, are not moved and are not seen by the PrefixBucketDatastore
+// if they start with a registered prefix, so prefixes must be registered
+// the same every time the db is opened. Closing the PrefixBucketDatastore
+// does not close d.
+func (d *Datastore) WithPrefixBuckets(prefixes [][]byte) (*PrefixBucketDatastore, error) {
+	p := &PrefixBucketDatastore{ds: d}
+	seen := make(map[string]bool)
+	for _, prefix := range prefixes {
+		if len(prefix) == 0 {
+			return nil, errors.New("empty bucket prefix")
+		}
+		if seen[string(prefix)] {
+			return nil, errors.New("duplicate bucket prefix")
+		}
+		seen[string(prefix)] = true
+		p.prefixes = append(p.prefixes, copyBytes(prefix))
+	}
+	sort.SliceStable(p.prefixes, func(i, j int) bool {
+		return len(p.prefixes[i]) > len(p.prefixes[j])
+	})
+	for _, prefix := range p.prefixes {
+		bucket, err := d.WithBucket(prefixBucketName(d.bucket, prefix))
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+		p.buckets = append(p.buckets, bucket)
+	}
+	return p, nil
+}
+
+// prefixBucketName returns the name of the top-level bucket of the keys
+// starting with prefix of the datastore in bucketPath
+func prefixBucketName(bucketPath [][]byte, prefix []byte) []byte {
+	return bucketPathKey(append(append([][]byte{[]byte("prefix")}, bucketPath...), prefix))
+}
+
+// route returns the datastore of the key k
+func (p *PrefixBucketDatastore) route(k []byte) *Datastore {
+	for i, prefix := range p.prefixes {
+		if bytes.HasPrefix(k, prefix) {
+			return p.buckets[i]
+		}
+	}
+	return p.ds
+}
+
+// bucketsFor returns the datastores that may hold keys starting with
+// prefix, every one for an empty prefix
+func (p *PrefixBucketDatastore) bucketsFor(prefix []byte) []*Datastore {
+	var buckets []*Datastore
+	inBucket := false
+	for i, registered := range p.prefixes {
+		if bytes.HasPrefix(prefix, registered) {
+			// the longest registered prefix of prefix, the shorter ones
+			// cannot hold its keys
+			if !inBucket {
+				buckets = append(buckets, p.buckets[i])
+			}
+			inBucket = true
+		} else if bytes.HasPrefix(registered, prefix) {
+			buckets = append(buckets, p.buckets[i])
+		}
+	}
+	if !inBucket {
+		buckets = append(buckets, p.ds)
+	}
+	return buckets
+}
+
+func (p *PrefixBucketDatastore) Get(ctx context.Context, key dskey.Key) ([]byte, error) {
+	return p.route(key.Bytes()).Get(ctx, key)
+}
+
+func (p *PrefixBucketDatastore) Has(ctx context.Context, key dskey.Key) (bool, error) {
+	return p.route(key.Bytes()).Has(ctx, key)
+}
+
+func (p *PrefixBucketDatastore) GetSize(ctx context.Context, key dskey.Key) (int, error) {
+	return p.route(key.Bytes()).GetSize(ctx, key)
+}
+
+func (p *PrefixBucketDatastore) Put(ctx context.Context, key dskey.Key, value []byte) error {
+	return p.route(key.Bytes()).Put(ctx, key, value)
+}
+
+func (p *PrefixBucketDatastore) Delete(ctx context.Context, key dskey.Key) error {
+	return p.route(key.Bytes()).Delete(ctx, key)
+}
+
+// Query runs q on the buckets that may hold keys starting with q.Prefix
+// and merges their results like the Query of ShardedDatastore
+func (p *PrefixBucketDatastore) Query(ctx context.Context, q query.Query) (query.Results, error) {
+	var prefix []byte
+	if q.Prefix != nil {
+		prefix = q.Prefix.Bytes()
+	}
+	buckets := p.bucketsFor(prefix)
+	if len(buckets) == 1 {
+		return buckets[0].Query(ctx, q)
+	}
+	return mergeQuery(ctx, q, buckets)
+}
+
+// Sync syncs the db shared by the buckets
+func (p *PrefixBucketDatastore) Sync(ctx context.Context, prefix dskey.Key) error {
+	return p.ds.Sync(ctx, prefix)
+}
+
+// Close closes the datastores of the prefix buckets, but not the
+// Datastore p was returned by nor the shared db
+func (p *PrefixBucketDatastore) Close() error {
+	var first error
+	for _, bucket := range p.buckets {
+		if err := bucket.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
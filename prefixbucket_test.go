@@ -0,0 +1,68 @@
+package dsbbolt
+
+import (
+	"testing"
+
+	dskey "github.com/daotl/go-datastore/key"
+	"github.com/daotl/go-datastore/query"
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/bbolt"
+)
+
+func TestPrefixBuckets(t *testing.T) {
+	ds := newTestDatastore(t)
+	_, err := ds.WithPrefixBuckets([][]byte{[]byte("users/"), []byte("users/")})
+	assert.Error(t, err)
+	p, err := ds.WithPrefixBuckets([][]byte{[]byte("users/"), []byte("orders/")})
+	assert.NoError(t, err)
+	defer p.Close()
+
+	keys := []string{"orders/1", "orders/2", "other", "users/a", "users/b", "usersx"}
+	for _, k := range keys {
+		assert.NoError(t, p.Put(bg, dskey.NewBytesKeyFromString(k), []byte(k)))
+	}
+	for _, k := range keys {
+		v, err := p.Get(bg, dskey.NewBytesKeyFromString(k))
+		assert.NoError(t, err)
+		assert.Equal(t, k, string(v))
+	}
+
+	// the keys of each prefix are stored in its bucket
+	bucketKeys := func(name []byte) []string {
+		var keys []string
+		assert.NoError(t, ds.bolt().View(func(tx *bbolt.Tx) error {
+			return tx.Bucket(name).ForEach(func(k, _ []byte) error {
+				keys = append(keys, string(k))
+				return nil
+			})
+		}))
+		return keys
+	}
+	assert.Equal(t, []string{"users/a", "users/b"}, bucketKeys(prefixBucketName(ds.bucket, []byte("users/"))))
+	assert.Equal(t, []string{"orders/1", "orders/2"}, bucketKeys(prefixBucketName(ds.bucket, []byte("orders/"))))
+	assert.Equal(t, []string{"other", "usersx"}, bucketKeys(ds.bucket[0]))
+
+	queryKeys := func(q query.Query) []string {
+		results, err := p.Query(bg, q)
+		assert.NoError(t, err)
+		entries, err := results.Rest()
+		assert.NoError(t, err)
+		var keys []string
+		for _, e := range entries {
+			keys = append(keys, e.Key.String())
+		}
+		return keys
+	}
+	assert.Equal(t, keys, queryKeys(query.Query{}))
+	assert.Equal(t, []string{"orders/2", "other"}, queryKeys(query.Query{Offset: 1, Limit: 2}))
+	users := dskey.NewBytesKeyFromString("users/")
+	assert.Equal(t, []string{"users/a", "users/b"}, queryKeys(query.Query{Prefix: users}))
+	assert.Equal(t, []*Datastore{p.route(users.Bytes())}, p.bucketsFor(users.Bytes()))
+	assert.Equal(t, []string{"users/a", "users/b", "usersx"},
+		queryKeys(query.Query{Prefix: dskey.NewBytesKeyFromString("user")}))
+
+	assert.NoError(t, p.Delete(bg, dskey.NewBytesKeyFromString("users/a")))
+	has, err := p.Has(bg, dskey.NewBytesKeyFromString("users/a"))
+	assert.NoError(t, err)
+	assert.False(t, has)
+}
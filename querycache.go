@@ -0,0 +1,178 @@
+package dsbbolt
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/daotl/go-datastore/query"
+	"go.etcd.io/bbolt"
+)
+
+// queryCache is an LRU cache of the entries returned by recent queries,
+// cleared whenever a write to the datastore commits
+type queryCache struct {
+	maxEntries int
+	maxBytes   int
+
+	mu      sync.Mutex
+	gen     uint64     // incremented by every clear
+	lru     *list.List // of *cachedQuery, most recently used first
+	queries map[string]*list.Element
+	bytes   int
+	tx      *bbolt.Tx // last write transaction set to clear the cache
+}
+
+// cachedQuery holds the entries returned by the query serialized as key,
+// of size bytes of keys and values
+type cachedQuery struct {
+	key     string
+	entries []query.Entry
+	size    int
+}
+
+func newQueryCache(maxEntries, maxBytes int) *queryCache {
+	return &queryCache{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		lru:        list.New(),
+		queries:    make(map[string]*list.Element),
+	}
+}
+
+// get returns a copy of the entries cached for key
+func (c *queryCache) get(key string) ([]query.Entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.queries[key]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+	return copyEntries(e.Value.(*cachedQuery).entries), true
+}
+
+// generation returns the number of times the cache was cleared, to be
+// passed to put along with the entries of a query started after the call
+func (c *queryCache) generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gen
+}
+
+// put caches the entries of the query serialized as key, unless the cache
+// was cleared since gen: a write may have committed while they were read
+func (c *queryCache) put(key string, entries []query.Entry, size int, gen uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen != c.gen || size > c.maxBytes {
+		return
+	}
+	if e, ok := c.queries[key]; ok {
+		c.remove(e)
+	}
+	c.queries[key] = c.lru.PushFront(&cachedQuery{key: key, entries: entries, size: size})
+	c.bytes += size
+	for c.lru.Len() > c.maxEntries || c.bytes > c.maxBytes {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *queryCache) remove(e *list.Element) {
+	cq := c.lru.Remove(e).(*cachedQuery)
+	delete(c.queries, cq.key)
+	c.bytes -= cq.size
+}
+
+func (c *queryCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	c.lru.Init()
+	c.queries = make(map[string]*list.Element)
+	c.bytes = 0
+}
+
+// invalidateQueries clears the query cache, if any, once tx commits
+func (d *Datastore) invalidateQueries(tx *bbolt.Tx) {
+	c := d.queryCache
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tx != tx {
+		// bbolt runs a single write transaction at a time
+		c.tx = tx
+		tx.OnCommit(c.clear)
+	}
+}
+
+// cacheable returns whether the results of q can be cached: the String of
+// custom filters and order functions does not tell them apart
+func cacheable(q query.Query) bool {
+	if len(q.Filters) > 0 {
+		return false
+	}
+	for _, o := range q.Orders {
+		switch o.(type) {
+		case query.OrderByFunction, *query.OrderByFunction:
+			return false
+		}
+	}
+	return true
+}
+
+// cachedQuery runs q like Query, returning the cached entries of the same
+// query if any, or caching the entries returned once they are all read
+func (d *Datastore) cachedQuery(ctx context.Context, q query.Query) (query.Results, error) {
+	c := d.queryCache
+	// String leaves ReturnsSizes out
+	key := fmt.Sprintf("%s\x00%t\x00%s", d.scope, q.ReturnsSizes, q)
+	if entries, ok := c.get(key); ok {
+		return query.ResultsWithEntries(q, entries), nil
+	}
+	gen := c.generation()
+	results, err := d.query(ctx, q, queryOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var entries []query.Entry
+	size, caching := 0, true
+	return query.ResultsFromIterator(q, query.Iterator{
+		Next: func() (query.Result, bool) {
+			r, ok := results.NextSync()
+			switch {
+			case !ok:
+				if caching {
+					c.put(key, entries, size, gen)
+					caching = false
+				}
+			case r.Error != nil || !r.Entry.Expiration.IsZero():
+				// entries that expire would outlive their expiration
+				caching = false
+			case caching:
+				size += len(r.Entry.Key.Bytes()) + len(r.Entry.Value)
+				if size > c.maxBytes {
+					caching, entries = false, nil
+					break
+				}
+				entries = append(entries, copyEntries([]query.Entry{r.Entry})...)
+			}
+			return r, ok
+		},
+		Close: results.Close,
+	}), nil
+}
+
+func copyEntries(entries []query.Entry) []query.Entry {
+	copied := make([]query.Entry, len(entries))
+	for i, e := range entries {
+		copied[i] = e
+		if e.Value != nil {
+			copied[i].Value = copyBytes(e.Value)
+		}
+	}
+	return copied
+}
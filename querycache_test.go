@@ -0,0 +1,106 @@
+package dsbbolt
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	dskey "github.com/daotl/go-datastore/key"
+	"github.com/daotl/go-datastore/query"
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/bbolt"
+)
+
+func TestQueryCache(t *testing.T) {
+	ds, err := NewDatastoreWithOptions(filepath.Join(t.TempDir(), "bolt"),
+		WithQueryCache(2, 1<<20))
+	assert.NoError(t, err)
+	defer ds.Close()
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, ds.Put(bg, dskey.NewBytesKeyFromString(fmt.Sprintf("key-%d", i)), []byte("v")))
+	}
+	// writes made through the bbolt db directly do not clear the cache
+	putBehind := func(key string) {
+		assert.NoError(t, ds.bolt().Update(func(tx *bbolt.Tx) error {
+			return tx.Bucket(ds.bucket[0]).Put([]byte(key), []byte("v"))
+		}))
+	}
+	queryAll := func(q query.Query) []query.Entry {
+		results, err := ds.Query(bg, q)
+		assert.NoError(t, err)
+		entries, err := results.Rest()
+		assert.NoError(t, err)
+		return entries
+	}
+
+	q := query.Query{Prefix: dskey.NewBytesKeyFromString("key-")}
+	assert.Equal(t, 5, len(queryAll(q)))
+	putBehind("key-behind")
+	entries := queryAll(q)
+	assert.Equal(t, 5, len(entries))
+	entries[0].Value[0] = 'x'
+	assert.Equal(t, "v", string(queryAll(q)[0].Value))
+
+	t.Run("write clears", func(t *testing.T) {
+		assert.NoError(t, ds.Put(bg, dskey.NewBytesKeyFromString("other"), []byte("v")))
+		assert.Equal(t, 6, len(queryAll(q)))
+	})
+
+	t.Run("not cacheable", func(t *testing.T) {
+		fq := q
+		fq.Filters = []query.Filter{query.FilterKeyPrefix{Prefix: dskey.NewBytesKeyFromString("key-")}}
+		assert.Equal(t, 6, len(queryAll(fq)))
+		putBehind("key-behind-2")
+		assert.Equal(t, 7, len(queryAll(fq)))
+		assert.Equal(t, 6, len(queryAll(q)))
+	})
+
+	t.Run("evicted", func(t *testing.T) {
+		for i := 1; i <= 2; i++ {
+			queryAll(query.Query{Limit: i})
+		}
+		assert.Equal(t, 7, len(queryAll(q)))
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		small, err := NewDatastoreWithOptions(filepath.Join(t.TempDir(), "bolt"),
+			WithQueryCache(2, 8))
+		assert.NoError(t, err)
+		defer small.Close()
+		assert.NoError(t, small.Put(bg, dskey.NewBytesKeyFromString("key-0"), []byte("value")))
+		assert.NoError(t, small.Put(bg, dskey.NewBytesKeyFromString("key-1"), []byte("value")))
+		for i := 0; i < 2; i++ {
+			results, err := small.Query(bg, query.Query{})
+			assert.NoError(t, err)
+			entries, err := results.Rest()
+			assert.NoError(t, err)
+			assert.Equal(t, 2, len(entries))
+		}
+		assert.Equal(t, 0, small.queryCache.lru.Len())
+	})
+}
+
+func TestQueryCacheSetTTL(t *testing.T) {
+	ds, err := NewDatastoreWithOptions(filepath.Join(t.TempDir(), "bolt"),
+		WithMetadata(), WithQueryCache(2, 1<<20))
+	assert.NoError(t, err)
+	defer ds.Close()
+	key := dskey.NewBytesKeyFromString("key")
+	assert.NoError(t, ds.Put(bg, key, []byte("v")))
+	for i := 0; i < 2; i++ {
+		results, err := ds.Query(bg, query.Query{})
+		assert.NoError(t, err)
+		entries, err := results.Rest()
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(entries))
+	}
+
+	assert.NoError(t, ds.SetTTL(bg, key, time.Millisecond))
+	time.Sleep(5 * time.Millisecond)
+	results, err := ds.Query(bg, query.Query{})
+	assert.NoError(t, err)
+	entries, err := results.Rest()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(entries))
+}
@@ -0,0 +1,128 @@
+package dsbbolt
+
+import (
+	"context"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"go.etcd.io/bbolt"
+)
+
+// dbHandle holds the bbolt db of a datastore, which Reopen replaces
+type dbHandle struct {
+	db atomic.Value // *bbolt.DB
+	mu sync.Mutex   // serializes Reopen and Close, guards closed and views
+
+	// txns is held for reading by each open datastore transaction, so that
+	// Close can wait for them
+	txns   sync.RWMutex
+	writes chan struct{} // holds a token while a write transaction is open
+	closed bool
+	views  []*Datastore // returned by WithBucket that started a reaper
+	watch  *watchHub
+	syncs  *syncPolicy // nil unless WithSyncEvery applies
+
+	// how the db was opened, path is empty when it was opened by the caller
+	path string
+	mode os.FileMode
+	opts *bbolt.Options
+	temp bool // whether Close removes the file, see NewMemDatastore
+}
+
+func newDBHandle(db *bbolt.DB) *dbHandle {
+	h := &dbHandle{writes: make(chan struct{}, 1), watch: newWatchHub()}
+	h.db.Store(db)
+	return h
+}
+
+// lockWrites waits for the write transactions begun through the datastores
+// of h to end, or returns the error of ctx if it is done first. bbolt
+// allows a single write transaction at a time and waits for it without
+// a deadline.
+func (h *dbHandle) lockWrites(ctx context.Context) error {
+	ctx = orBackground(ctx)
+	select {
+	case h.writes <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (h *dbHandle) unlockWrites() {
+	<-h.writes
+}
+
+// addView records that the reaper of view has to be stopped on Close
+func (h *dbHandle) addView(view *Datastore) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, v := range h.views {
+		if v == view {
+			return
+		}
+	}
+	h.views = append(h.views, view)
+}
+
+// bolt returns the current bbolt db of the datastore
+func (d *Datastore) bolt() *bbolt.DB {
+	return d.db.db.Load().(*bbolt.DB)
+}
+
+// Reopen closes the db file and opens it again at the same path with the
+// same options, e.g. to recover from a stale memory map after the file was
+// moved or truncated. The datastores returned by WithBucket switch to the
+// new db along with d. Only a datastore that opened its db can reopen it,
+// others get ErrDBNotOwned.
+//
+// Closing the db waits for open transactions, iterators and query results
+// to be closed. Operations that start on the old db while it is being
+// closed fail with bbolt.ErrDatabaseNotOpen, as do all operations if the
+// db cannot be opened again, in which case Reopen can be retried. Once the
+// datastore is closed, Reopen returns bbolt.ErrDatabaseNotOpen.
+func (d *Datastore) Reopen(ctx context.Context) error {
+	if !d.ownsDB || d.db.path == "" {
+		return ErrDBNotOwned
+	}
+	if err := orBackground(ctx).Err(); err != nil {
+		return err
+	}
+	h := d.db
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		// nothing would close the db opened again
+		return bbolt.ErrDatabaseNotOpen
+	}
+	reopen := func() (*bbolt.DB, error) {
+		if err := d.bolt().Close(); err != nil {
+			return nil, err
+		}
+		db, err := bbolt.Open(h.path, h.mode, h.opts)
+		if err != nil {
+			return nil, err
+		}
+		if err := ensureBucket(db, d.bucket, d.ktype); err != nil {
+			db.Close()
+			return nil, err
+		}
+		h.db.Store(db)
+		return db, nil
+	}
+	var err error
+	if d.readPool != nil {
+		err = d.readPool.replaceDB(reopen)
+	} else {
+		_, err = reopen()
+	}
+	if err != nil {
+		return err
+	}
+	if d.bloom != nil {
+		// the file may hold keys the filter has not seen
+		return d.loadBloomFilter(d.bloom)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package dsbbolt
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	dskey "github.com/daotl/go-datastore/key"
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/bbolt"
+)
+
+func TestReopen(t *testing.T) {
+	ds, err := NewDatastoreWithOptions(filepath.Join(t.TempDir(), "bolt"),
+		WithReadTxPool(2, time.Hour, 0), WithBloomFilter(100, 0))
+	assert.NoError(t, err)
+	defer ds.Close()
+	other, err := ds.WithBucket([]byte("other"))
+	assert.NoError(t, err)
+	key := dskey.NewBytesKeyFromString("key")
+	assert.NoError(t, ds.Put(bg, key, []byte("value")))
+	assert.NoError(t, other.Put(bg, key, []byte("other")))
+	// leave a pooled read transaction open
+	_, err = ds.Get(bg, key)
+	assert.NoError(t, err)
+
+	old := ds.bolt()
+	assert.NoError(t, ds.Reopen(bg))
+	assert.NotEqual(t, old, ds.bolt())
+	_, err = old.Begin(false)
+	assert.Equal(t, bbolt.ErrDatabaseNotOpen, err)
+
+	// both the datastore and its views keep working on the new db
+	value, err := ds.Get(bg, key)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("value"), value)
+	assert.NoError(t, ds.Put(bg, key, []byte("value2")))
+	value, err = other.Get(bg, key)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("other"), value)
+	assert.Equal(t, ErrDBNotOwned, other.Reopen(bg))
+
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "bolt"), defaultFileMode, nil)
+	assert.NoError(t, err)
+	defer db.Close()
+	borrowed, err := NewDatastoreWithDB(db, nil, dskey.KeyTypeBytes)
+	assert.NoError(t, err)
+	assert.Equal(t, ErrDBNotOwned, borrowed.Reopen(bg))
+}
+
+func TestReopenClosed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bolt")
+	ds, err := NewDatastoreWithOptions(path)
+	assert.NoError(t, err)
+	assert.NoError(t, ds.Close())
+	assert.Equal(t, bbolt.ErrDatabaseNotOpen, ds.Reopen(bg))
+	// the file was not opened again, its lock is free
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: 100 * time.Millisecond})
+	assert.NoError(t, err)
+	assert.NoError(t, db.Close())
+}
@@ -0,0 +1,141 @@
+package dsbbolt
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	"go.etcd.io/bbolt"
+)
+
+// repairTxMaxKeys is the number of keys Repair writes per transaction
+const repairTxMaxKeys = 1000
+
+// RepairReport describes the outcome of Repair
+type RepairReport struct {
+	Recovered int // number of keys copied to the new db
+	Skipped   int // number of cursor moves that failed on a corrupted page
+}
+
+// Repair copies every readable key of bucket in the bbolt file at srcPath
+// into bucket of a new file at dstPath, nil bucket being the default one.
+// The source is opened read-only and scanned with a cursor, when reading a
+// corrupted page panics the scan resumes after the last readable key, so
+// the keys of the corrupted page and possibly some of their neighbours are
+// lost. Skipped counts the failed cursor moves, not the keys lost.
+func Repair(srcPath, dstPath string, bucket []byte) (RepairReport, error) {
+	if bucket == nil {
+		bucket = defaultBucket
+	}
+	src, err := bbolt.Open(srcPath, defaultFileMode, &bbolt.Options{ReadOnly: true})
+	if err != nil {
+		return RepairReport{}, err
+	}
+	defer src.Close()
+	dst, err := bbolt.Open(dstPath, defaultFileMode, nil)
+	if err != nil {
+		return RepairReport{}, err
+	}
+	defer dst.Close()
+
+	var report RepairReport
+	var pending []KeyValue
+	flush := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+		err := dst.Update(func(tx *bbolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists(bucket)
+			if err != nil {
+				return err
+			}
+			for _, kv := range pending {
+				if err := b.Put(kv.Key.Bytes(), kv.Value); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		// only the keys written to the new db are recovered
+		report.Recovered += len(pending)
+		pending = pending[:0]
+		return nil
+	}
+
+	// faults on a corrupted mmap must turn into panics to be recovered
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	err = src.View(func(tx *bbolt.Tx) (err error) {
+		var c *bbolt.Cursor
+		if err := recoverPanic(func() {
+			if b := tx.Bucket(bucket); b != nil {
+				c = b.Cursor()
+			}
+		}); err != nil {
+			return err
+		}
+		if c == nil {
+			return ErrBucketNotFound
+		}
+
+		var k, v []byte
+		var last []byte
+		if err := recoverPanic(func() { k, v = c.First() }); err != nil {
+			report.Skipped++
+		}
+		for k != nil {
+			if v != nil {
+				pending = append(pending, toKeyValue(k, v))
+				last = pending[len(pending)-1].Key.Bytes()
+				if len(pending) >= repairTxMaxKeys {
+					if err := flush(); err != nil {
+						return err
+					}
+				}
+			}
+			if err := recoverPanic(func() { k, v = c.Next() }); err == nil {
+				continue
+			}
+			report.Skipped++
+			k, v = resumeAfter(c, last, &report)
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+	if err := flush(); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// resumeAfter seeks c to the smallest successor of last that can be read,
+// trying keys that differ from last at ever earlier bytes, and returns the
+// key and value found
+func resumeAfter(c *bbolt.Cursor, last []byte, report *RepairReport) (k, v []byte) {
+	seek := make([]byte, len(last))
+	for l := len(last) - 1; l >= 0; l-- {
+		copy(seek, last[:l])
+		for b := int(last[l]) + 1; b <= 0xff; b++ {
+			seek[l] = byte(b)
+			if err := recoverPanic(func() { k, v = c.Seek(seek[:l+1]) }); err == nil {
+				return k, v
+			}
+			report.Skipped++
+		}
+	}
+	return nil, nil
+}
+
+// recoverPanic runs fn and turns a panic into an error
+func recoverPanic(fn func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("recovered from panic: %v", r)
+		}
+	}()
+	fn()
+	return nil
+}
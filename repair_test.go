@@ -0,0 +1,91 @@
+package dsbbolt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	dskey "github.com/daotl/go-datastore/key"
+	"github.com/stretchr/testify/assert"
+)
+
+// corruptLeafPage clears the flags of a leaf page in the middle of the
+// datastore keys of the bbolt file at path, and returns its first key
+func corruptLeafPage(t *testing.T, path string) []byte {
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	pageSize := int(binary.LittleEndian.Uint32(data[24:]))
+
+	// a leaf page has the 0x02 flag and starts with its elements:
+	// flags, pos, ksize and vsize, pos being relative to the element
+	var leaves []int
+	for off := 0; off+pageSize <= len(data); off += pageSize {
+		if binary.LittleEndian.Uint16(data[off+8:]) != 0x02 {
+			continue
+		}
+		elem := off + 16
+		pos := int(binary.LittleEndian.Uint32(data[elem+4:]))
+		if bytes.HasPrefix(data[elem+pos:], []byte("bench-")) {
+			leaves = append(leaves, off)
+		}
+	}
+	if len(leaves) < 3 {
+		t.Fatalf("only %d leaf pages", len(leaves))
+	}
+	off := leaves[len(leaves)/2]
+	elem := off + 16
+	pos := int(binary.LittleEndian.Uint32(data[elem+4:]))
+	ksize := int(binary.LittleEndian.Uint32(data[elem+8:]))
+	first := copyBytes(data[elem+pos : elem+pos+ksize])
+
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	assert.NoError(t, err)
+	defer f.Close()
+	_, err = f.WriteAt([]byte{0, 0}, int64(off+8))
+	assert.NoError(t, err)
+	return first
+}
+
+func TestRepair(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bolt")
+	ds, err := NewDatastore(path, nil, nil, dskey.KeyTypeBytes)
+	assert.NoError(t, err)
+	keys := benchmarkKeys(1000)
+	entries := make([]KeyValue, len(keys))
+	for i, k := range keys {
+		entries[i] = KeyValue{Key: k, Value: make([]byte, 100)}
+	}
+	assert.NoError(t, ds.PutMany(bg, entries))
+	assert.NoError(t, ds.Close())
+
+	t.Run("healthy", func(t *testing.T) {
+		report, err := Repair(path, filepath.Join(t.TempDir(), "repaired"), nil)
+		assert.NoError(t, err)
+		assert.Equal(t, RepairReport{Recovered: len(keys)}, report)
+	})
+
+	t.Run("corrupted", func(t *testing.T) {
+		corrupted := corruptLeafPage(t, path)
+		dstPath := filepath.Join(t.TempDir(), "repaired")
+		report, err := Repair(path, dstPath, nil)
+		assert.NoError(t, err)
+		assert.True(t, report.Skipped > 0)
+		assert.True(t, report.Recovered > len(keys)/2, "recovered %d keys", report.Recovered)
+		assert.True(t, report.Recovered < len(keys), "recovered %d keys", report.Recovered)
+
+		repaired, err := NewDatastore(dstPath, nil, nil, dskey.KeyTypeBytes)
+		assert.NoError(t, err)
+		defer repaired.Close()
+		stats, err := repaired.Stat(bg)
+		assert.NoError(t, err)
+		assert.Equal(t, report.Recovered, stats.KeyCount)
+		v, err := repaired.Get(bg, keys[0])
+		assert.NoError(t, err)
+		assert.Equal(t, make([]byte, 100), v)
+		has, err := repaired.Has(bg, dskey.NewBytesKey(corrupted))
+		assert.NoError(t, err)
+		assert.False(t, has)
+	})
+}
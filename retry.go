@@ -0,0 +1,114 @@
+package dsbbolt
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/daotl/go-datastore"
+	"go.etcd.io/bbolt"
+)
+
+// retryableError marks an error as retryable by Update and View
+type retryableError struct {
+	err error
+}
+
+func (e retryableError) Error() string   { return e.err.Error() }
+func (e retryableError) Temporary() bool { return true }
+
+// Retryable marks err as transient, so that Update and View retry the
+// transaction that returned it
+func Retryable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return retryableError{err}
+}
+
+// isRetryable returns whether err reports itself as temporary, like the
+// errors returned by Retryable
+func isRetryable(err error) bool {
+	t, ok := err.(interface{ Temporary() bool })
+	return ok && t.Temporary()
+}
+
+// Update runs fn in a read-write transaction and commits it if fn returns
+// nil, or discards it otherwise, including when fn panics. A transaction
+// failing with a retryable error is retried in a new transaction, up to the
+// number of retries set with WithRetry, waiting a doubling backoff between
+// attempts.
+func (d *Datastore) Update(ctx context.Context, fn func(datastore.Txn) error) error {
+	return d.retry(ctx, func() error {
+		txn, err := d.NewTransaction(ctx, false)
+		if err != nil {
+			return err
+		}
+		// also discards the transaction if fn panics, a no-op once committed
+		defer txn.Discard(ctx)
+		if err := fn(txn); err != nil {
+			return err
+		}
+		return txn.Commit(ctx)
+	})
+}
+
+// View runs fn in a read-only transaction, retried like in Update
+func (d *Datastore) View(ctx context.Context, fn func(datastore.Txn) error) error {
+	return d.retry(ctx, func() error {
+		txn, err := d.NewTransaction(ctx, true)
+		if err != nil {
+			return err
+		}
+		defer txn.Discard(ctx)
+		return fn(txn)
+	})
+}
+
+// retry runs op until it succeeds, fails with an error that is not
+// retryable or has been retried d.maxRetries times
+func (d *Datastore) retry(ctx context.Context, op func() error) error {
+	ctx = orBackground(ctx)
+	backoff := d.retryBackoff
+	for attempt := 0; ; attempt++ {
+		err := op()
+		if err == nil || attempt >= d.maxRetries || !isRetryable(err) {
+			return err
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+// openWithRetry opens the bbolt db at path like bbolt.Open, retrying up to
+// retries times while the file lock is held, waiting delay before the
+// first retry and twice as long before each next one. Unless opts sets a
+// Timeout, each attempt tries the lock once instead of blocking on it.
+func openWithRetry(path string, mode os.FileMode, opts *bbolt.Options, retries int, delay time.Duration) (*bbolt.DB, error) {
+	if retries <= 0 {
+		return bbolt.Open(path, mode, opts)
+	}
+	o := *bbolt.DefaultOptions
+	if opts != nil {
+		o = *opts
+	}
+	if o.Timeout == 0 {
+		// bbolt gives up after the first failed try when the timeout is
+		// shorter than its polling interval
+		o.Timeout = time.Nanosecond
+	}
+	for attempt := 0; ; attempt++ {
+		db, err := bbolt.Open(path, mode, &o)
+		if err != bbolt.ErrTimeout || attempt >= retries {
+			return db, err
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
@@ -0,0 +1,107 @@
+package dsbbolt
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/daotl/go-datastore"
+	dskey "github.com/daotl/go-datastore/key"
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/bbolt"
+)
+
+func TestUpdateRetry(t *testing.T) {
+	ds, err := NewDatastoreWithOptions(filepath.Join(t.TempDir(), "bolt"),
+		WithRetry(3, time.Millisecond))
+	assert.NoError(t, err)
+	defer ds.Close()
+
+	key := dskey.NewBytesKeyFromString("foo")
+	attempts := 0
+	err = ds.Update(bg, func(txn datastore.Txn) error {
+		attempts++
+		if err := txn.Put(bg, key, []byte{byte(attempts)}); err != nil {
+			return err
+		}
+		if attempts < 3 {
+			return Retryable(errors.New("transient"))
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, attempts)
+	// failed attempts were discarded, the last one committed
+	value, err := ds.Get(bg, key)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{3}, value)
+
+	attempts = 0
+	err = ds.View(bg, func(txn datastore.Txn) error {
+		attempts++
+		return Retryable(errors.New("transient"))
+	})
+	assert.EqualError(t, err, "transient")
+	assert.Equal(t, 4, attempts)
+
+	// errors that are not retryable are returned right away
+	permanent := errors.New("permanent")
+	attempts = 0
+	err = ds.Update(bg, func(txn datastore.Txn) error {
+		attempts++
+		return permanent
+	})
+	assert.Equal(t, permanent, err)
+	assert.Equal(t, 1, attempts)
+
+	ctx, cancel := context.WithCancel(bg)
+	cancel()
+	err = ds.Update(ctx, func(txn datastore.Txn) error {
+		return Retryable(errors.New("transient"))
+	})
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestUpdatePanic(t *testing.T) {
+	ds := newTestDatastore(t)
+	key := dskey.NewBytesKeyFromString("key")
+	assert.Panics(t, func() {
+		ds.Update(bg, func(txn datastore.Txn) error {
+			if err := txn.Put(bg, key, []byte("discarded")); err != nil {
+				return err
+			}
+			panic("boom")
+		})
+	})
+	// the transaction was discarded and released the write lock
+	ctx, cancel := context.WithTimeout(bg, time.Second)
+	defer cancel()
+	has, err := ds.Has(ctx, key)
+	assert.NoError(t, err)
+	assert.False(t, has)
+	assert.NoError(t, ds.Put(ctx, key, []byte("value")))
+}
+
+func TestOpenRetry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bolt")
+	held, err := bbolt.Open(path, defaultFileMode, nil)
+	assert.NoError(t, err)
+
+	// without retries the held lock fails the open
+	_, err = NewDatastoreWithOptions(path, WithOpenRetry(0, 0),
+		WithBoltOptions(&bbolt.Options{Timeout: 10 * time.Millisecond}))
+	assert.Equal(t, bbolt.ErrTimeout, err)
+	_, err = NewDatastoreWithOptions(path, WithOpenRetry(2, time.Millisecond))
+	assert.Equal(t, bbolt.ErrTimeout, err)
+
+	// the lock is released while retrying
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		held.Close()
+	}()
+	ds, err := NewDatastoreWithOptions(path, WithOpenRetry(10, 10*time.Millisecond))
+	assert.NoError(t, err)
+	assert.NoError(t, ds.Close())
+}
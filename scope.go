@@ -0,0 +1,72 @@
+package dsbbolt
+
+import (
+	dskey "github.com/daotl/go-datastore/key"
+)
+
+// Scoped returns a Datastore that shares the db and bucket of d but
+// prepends prefix to every key it stores, so that several components can
+// share a bucket without seeing each other's keys. Keys returned by
+// queries and iterators have the prefix stripped. Scopes nest: scoping a
+// scoped Datastore appends prefix to its scope. Stat, Check and the reaper
+// still cover the whole bucket, and closing a scoped Datastore does not
+// close the shared db.
+func (d *Datastore) Scoped(prefix []byte) *Datastore {
+	view := *d
+	view.scope = append(copyBytes(d.scope), prefix...)
+	view.ownsDB = false
+	view.reaper = &reaper{}
+	return &view
+}
+
+// transformedKey returns key transformed by the function set with
+// WithKeyTransform, or the bytes of key if there is none
+func (d *Datastore) transformedKey(key dskey.Key) ([]byte, error) {
+	if d.keyTransform == nil {
+		return key.Bytes(), nil
+	}
+	return d.keyTransform(key)
+}
+
+// storedKey returns the key key is stored under
+func (d *Datastore) storedKey(key dskey.Key) ([]byte, error) {
+	k, err := d.transformedKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(d.scope) == 0 {
+		return k, nil
+	}
+	return append(copyBytes(d.scope), k...), nil
+}
+
+// prefixBounds returns the range of the stored keys a Query with prefix
+// covers, the limit being exclusive, a nil prefix covering the scope
+func (d *Datastore) prefixBounds(prefix dskey.Key) (start, limit []byte, err error) {
+	if prefix == nil {
+		start, limit = d.scopeBounds()
+		return start, limit, nil
+	}
+	k, err := d.storedKey(prefix)
+	if err != nil {
+		return nil, nil, err
+	}
+	start, limit = bytesPrefix(k)
+	return start, limit, nil
+}
+
+// userKey returns the part of the stored key k seen by callers, k must be
+// within the scope
+func (d *Datastore) userKey(k []byte) []byte {
+	return k[len(d.scope):]
+}
+
+// scopeBounds returns the range of the stored keys within the scope, the
+// limit being exclusive, or nil bounds when d is not scoped
+func (d *Datastore) scopeBounds() (start, limit []byte) {
+	if len(d.scope) == 0 {
+		return nil, nil
+	}
+	_, limit = bytesPrefix(d.scope)
+	return d.scope, limit
+}
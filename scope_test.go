@@ -0,0 +1,74 @@
+package dsbbolt
+
+import (
+	"testing"
+
+	"github.com/daotl/go-datastore"
+	dskey "github.com/daotl/go-datastore/key"
+	"github.com/daotl/go-datastore/query"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScoped(t *testing.T) {
+	ds := newTestDatastore(t)
+	a, b := ds.Scoped([]byte("a/")), ds.Scoped([]byte("b/"))
+	key := dskey.NewBytesKeyFromString("key")
+	assert.NoError(t, a.Put(bg, key, []byte("in a")))
+	assert.NoError(t, b.Put(bg, key, []byte("in b")))
+	assert.NoError(t, b.Put(bg, dskey.NewBytesKeyFromString("only-b"), []byte("in b")))
+
+	value, err := a.Get(bg, key)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("in a"), value)
+	value, err = ds.Get(bg, dskey.NewBytesKeyFromString("b/key"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("in b"), value)
+	has, err := a.Has(bg, dskey.NewBytesKeyFromString("only-b"))
+	assert.NoError(t, err)
+	assert.False(t, has)
+
+	for _, orders := range [][]query.Order{nil, {query.OrderByKeyDescending{}}} {
+		results, err := b.Query(bg, query.Query{Orders: orders})
+		assert.NoError(t, err)
+		entries, err := results.Rest()
+		assert.NoError(t, err)
+		keys := query.EntryKeys(entries)
+		if orders == nil {
+			assert.Equal(t, []dskey.Key{key, dskey.NewBytesKeyFromString("only-b")}, keys)
+		} else {
+			assert.Equal(t, []dskey.Key{dskey.NewBytesKeyFromString("only-b"), key}, keys)
+		}
+	}
+	results, err := b.Query(bg, query.Query{Prefix: dskey.NewBytesKeyFromString("only")})
+	assert.NoError(t, err)
+	entries, err := results.Rest()
+	assert.NoError(t, err)
+	assert.Equal(t, []dskey.Key{dskey.NewBytesKeyFromString("only-b")}, query.EntryKeys(entries))
+
+	it, err := a.Iterator(bg, nil)
+	assert.NoError(t, err)
+	assert.True(t, it.Next())
+	assert.Equal(t, key, it.Key())
+	assert.False(t, it.Next())
+	assert.NoError(t, it.Close())
+
+	// scopes nest
+	nested := a.Scoped([]byte("c/"))
+	txn, err := nested.NewTransaction(bg, false)
+	assert.NoError(t, err)
+	assert.NoError(t, txn.Put(bg, key, []byte("in a/c")))
+	assert.NoError(t, txn.Commit(bg))
+	value, err = ds.Get(bg, dskey.NewBytesKeyFromString("a/c/key"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("in a/c"), value)
+
+	assert.NoError(t, a.Delete(bg, key))
+	_, err = a.Get(bg, key)
+	assert.Equal(t, datastore.ErrNotFound, err)
+	value, err = b.Get(bg, key)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("in b"), value)
+	assert.NoError(t, a.Close())
+	_, err = b.Get(bg, key)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,219 @@
+package dsbbolt
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"hash/fnv"
+
+	"github.com/daotl/go-datastore"
+	dskey "github.com/daotl/go-datastore/key"
+	"github.com/daotl/go-datastore/query"
+)
+
+var _ datastore.Datastore = (*ShardedDatastore)(nil)
+
+// ShardedDatastore spreads its keys over several Datastore shards, each
+// in its own db file, to keep the memory map and write amplification of
+// each file bounded when the keyspace is very large. A key is stored in
+// the shard picked by a hash of its first bytes.
+type ShardedDatastore struct {
+	shards    []*Datastore
+	prefixLen int
+}
+
+// NewShardedDatastore opens a Datastore shard at each of paths with opts.
+// Keys sharing their first prefixLen bytes are stored in the same shard,
+// so that queries with a prefix at least that long only read one shard; 0
+// hashes whole keys, spreading them evenly. The shards must always be
+// opened with the same paths, in the same order, and the same prefixLen.
+func NewShardedDatastore(paths []string, prefixLen int, opts ...Option) (*ShardedDatastore, error) {
+	if len(paths) == 0 {
+		return nil, errors.New("no shard paths")
+	}
+	s := &ShardedDatastore{prefixLen: prefixLen}
+	for _, path := range paths {
+		ds, err := NewDatastoreWithOptions(path, opts...)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.shards = append(s.shards, ds)
+	}
+	return s, nil
+}
+
+// shard returns the shard of the keys starting with the first prefixLen
+// bytes of k, or the whole of k when prefixLen is 0
+func (s *ShardedDatastore) shard(k []byte) *Datastore {
+	if s.prefixLen > 0 && len(k) > s.prefixLen {
+		k = k[:s.prefixLen]
+	}
+	h := fnv.New32a()
+	h.Write(k)
+	return s.shards[h.Sum32()%uint32(len(s.shards))]
+}
+
+func (s *ShardedDatastore) Get(ctx context.Context, key dskey.Key) ([]byte, error) {
+	return s.shard(key.Bytes()).Get(ctx, key)
+}
+
+func (s *ShardedDatastore) Has(ctx context.Context, key dskey.Key) (bool, error) {
+	return s.shard(key.Bytes()).Has(ctx, key)
+}
+
+func (s *ShardedDatastore) GetSize(ctx context.Context, key dskey.Key) (int, error) {
+	return s.shard(key.Bytes()).GetSize(ctx, key)
+}
+
+func (s *ShardedDatastore) Put(ctx context.Context, key dskey.Key, value []byte) error {
+	return s.shard(key.Bytes()).Put(ctx, key, value)
+}
+
+func (s *ShardedDatastore) Delete(ctx context.Context, key dskey.Key) error {
+	return s.shard(key.Bytes()).Delete(ctx, key)
+}
+
+// Query runs q on every shard and merges their results lazily, ordered by
+// q.Orders or by key when q has no orders: only the next entry of each
+// shard is held in memory, and the shards stay open until the results are
+// exhausted or closed. A prefix of at least prefixLen bytes only queries
+// the shard of the prefix.
+func (s *ShardedDatastore) Query(ctx context.Context, q query.Query) (query.Results, error) {
+	if s.prefixLen > 0 && q.Prefix != nil && len(q.Prefix.Bytes()) >= s.prefixLen {
+		return s.shard(q.Prefix.Bytes()).Query(ctx, q)
+	}
+	return mergeQuery(ctx, q, s.shards)
+}
+
+// mergeQuery runs q on every datastore of shards, holding disjoint keys,
+// and merges their results lazily
+func mergeQuery(ctx context.Context, q query.Query, shards []*Datastore) (query.Results, error) {
+	// every shard returns the entries up to the last one q may return
+	sub := q
+	sub.Offset = 0
+	if q.Limit > 0 {
+		sub.Limit = q.Offset + q.Limit
+	}
+	m := &mergedResults{orders: q.Orders}
+	for _, shard := range shards {
+		results, err := shard.Query(ctx, sub)
+		if err != nil {
+			m.close()
+			return nil, err
+		}
+		m.shards = append(m.shards, results)
+	}
+	for _, results := range m.shards {
+		head, ok, err := nextHead(results)
+		if err != nil {
+			m.close()
+			return nil, err
+		}
+		if ok {
+			m.heads = append(m.heads, head)
+		}
+	}
+	heap.Init(m)
+	skipped, returned := 0, 0
+	// the error of a shard is returned after the entry popped before it,
+	// and ends the results
+	var shardErr error
+	done := false
+	return query.ResultsFromIterator(q, query.Iterator{
+		Next: func() (query.Result, bool) {
+			for {
+				if done || q.Limit > 0 && returned >= q.Limit {
+					return query.Result{}, false
+				}
+				if shardErr != nil {
+					done = true
+					return query.Result{Error: shardErr}, true
+				}
+				if m.Len() == 0 {
+					return query.Result{}, false
+				}
+				head := heap.Pop(m).(mergedHead)
+				next, ok, err := nextHead(head.results)
+				if err != nil {
+					shardErr = err
+				} else if ok {
+					heap.Push(m, next)
+				}
+				if skipped < q.Offset {
+					skipped++
+					continue
+				}
+				returned++
+				return query.Result{Entry: head.entry}, true
+			}
+		},
+		Close: m.close,
+	}), nil
+}
+
+// mergedHead is the next entry of the results of a shard
+type mergedHead struct {
+	entry   query.Entry
+	results query.Results
+}
+
+// mergedResults is a heap of the next entry of each shard, ordered by
+// orders
+type mergedResults struct {
+	orders []query.Order
+	heads  []mergedHead
+	shards []query.Results
+}
+
+func (m *mergedResults) Len() int { return len(m.heads) }
+func (m *mergedResults) Less(i, j int) bool {
+	return query.Less(m.orders, m.heads[i].entry, m.heads[j].entry)
+}
+func (m *mergedResults) Swap(i, j int)      { m.heads[i], m.heads[j] = m.heads[j], m.heads[i] }
+func (m *mergedResults) Push(x interface{}) { m.heads = append(m.heads, x.(mergedHead)) }
+func (m *mergedResults) Pop() interface{} {
+	head := m.heads[len(m.heads)-1]
+	m.heads = m.heads[:len(m.heads)-1]
+	return head
+}
+
+// nextHead returns the next entry of results, if there is one
+func nextHead(results query.Results) (mergedHead, bool, error) {
+	r, ok := results.NextSync()
+	if !ok || r.Error != nil {
+		return mergedHead{}, false, r.Error
+	}
+	return mergedHead{entry: r.Entry, results: results}, true, nil
+}
+
+func (m *mergedResults) close() error {
+	var first error
+	for _, results := range m.shards {
+		if err := results.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Sync syncs every shard
+func (s *ShardedDatastore) Sync(ctx context.Context, prefix dskey.Key) error {
+	for _, shard := range s.shards {
+		if err := shard.Sync(ctx, prefix); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes every shard and returns the first error
+func (s *ShardedDatastore) Close() error {
+	var first error
+	for _, shard := range s.shards {
+		if err := shard.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
@@ -0,0 +1,214 @@
+package dsbbolt
+
+import (
+	"fmt"
+	"hash/crc32"
+	"path/filepath"
+	"testing"
+
+	"github.com/daotl/go-datastore"
+	dskey "github.com/daotl/go-datastore/key"
+	"github.com/daotl/go-datastore/query"
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/bbolt"
+)
+
+func newTestShardedDatastore(t *testing.T, n, prefixLen int) *ShardedDatastore {
+	var paths []string
+	for i := 0; i < n; i++ {
+		paths = append(paths, filepath.Join(t.TempDir(), fmt.Sprintf("shard-%d", i)))
+	}
+	s, err := NewShardedDatastore(paths, prefixLen, WithBoltOptions(&bbolt.Options{NoSync: true}))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		s.Close()
+	})
+	return s
+}
+
+func TestShardedDatastore(t *testing.T) {
+	s := newTestShardedDatastore(t, 4, 0)
+	var keys []dskey.Key
+	for i := 0; i < 10000; i++ {
+		key := dskey.NewBytesKeyFromString(fmt.Sprintf("%c/%05d", 'a'+i%2, i))
+		keys = append(keys, key)
+		assert.NoError(t, s.Put(bg, key, key.Bytes()))
+	}
+	for _, shard := range s.shards {
+		n, err := shard.Count(bg, nil)
+		assert.NoError(t, err)
+		assert.True(t, n > 1500, "shard holds %d keys", n)
+	}
+
+	value, err := s.Get(bg, keys[42])
+	assert.NoError(t, err)
+	assert.Equal(t, keys[42].Bytes(), value)
+	assert.NoError(t, s.Delete(bg, keys[42]))
+	has, err := s.Has(bg, keys[42])
+	assert.NoError(t, err)
+	assert.False(t, has)
+	_, err = s.GetSize(bg, keys[42])
+	assert.Equal(t, datastore.ErrNotFound, err)
+
+	results, err := s.Query(bg, query.Query{Prefix: dskey.NewBytesKeyFromString("a/")})
+	assert.NoError(t, err)
+	entries, err := results.Rest()
+	assert.NoError(t, err)
+	assert.Len(t, entries, 4999)
+	for i, e := range entries {
+		assert.Equal(t, e.Key.Bytes(), e.Value)
+		if i > 0 {
+			assert.True(t, entries[i-1].Key.Less(e.Key))
+		}
+	}
+
+	results, err = s.Query(bg, query.Query{
+		Prefix: dskey.NewBytesKeyFromString("b/"),
+		Orders: []query.Order{query.OrderByKeyDescending{}},
+		Offset: 10,
+		Limit:  5,
+	})
+	assert.NoError(t, err)
+	entries, err = results.Rest()
+	assert.NoError(t, err)
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Key.String())
+	}
+	assert.Equal(t, []string{"b/09979", "b/09977", "b/09975", "b/09973", "b/09971"}, got)
+}
+
+func TestShardedDatastorePrefix(t *testing.T) {
+	s := newTestShardedDatastore(t, 4, 2)
+	for i := 0; i < 100; i++ {
+		key := dskey.NewBytesKeyFromString(fmt.Sprintf("%02d/%d", i%10, i))
+		assert.NoError(t, s.Put(bg, key, nil))
+	}
+	// the keys of a prefix are all in its shard
+	prefix := dskey.NewBytesKeyFromString("07/")
+	n, err := s.shard(prefix.Bytes()).Count(bg, prefix)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, n)
+	results, err := s.Query(bg, query.Query{Prefix: prefix, KeysOnly: true})
+	assert.NoError(t, err)
+	entries, err := results.Rest()
+	assert.NoError(t, err)
+	assert.Len(t, entries, 10)
+
+	_, err = NewShardedDatastore(nil, 0)
+	assert.Error(t, err)
+}
+
+func TestShardedQueryMerge(t *testing.T) {
+	s := newTestShardedDatastore(t, 4, 0)
+	single := newTestDatastore(t)
+	keys := benchmarkKeys(2000)
+	for i := range keys {
+		// spread the keys out of order
+		k := keys[(i*7919)%len(keys)]
+		assert.NoError(t, s.Put(bg, k, k.Bytes()))
+		assert.NoError(t, single.Put(bg, k, k.Bytes()))
+	}
+	for _, q := range []query.Query{
+		{},
+		{Orders: []query.Order{query.OrderByKeyDescending{}}},
+		{Prefix: dskey.NewBytesKeyFromString("bench-00001"), KeysOnly: true},
+		{Offset: 995, Limit: 10},
+		{Orders: []query.Order{query.OrderByValue{}}, Offset: 1990},
+		{Filters: []query.Filter{query.FilterKeyCompare{Op: query.GreaterThan, Key: keys[1000]}}, Limit: 3},
+	} {
+		want, err := single.Query(bg, q)
+		assert.NoError(t, err)
+		wantEntries, err := want.Rest()
+		assert.NoError(t, err)
+		got, err := s.Query(bg, q)
+		assert.NoError(t, err)
+		gotEntries, err := got.Rest()
+		assert.NoError(t, err)
+		assert.Equal(t, len(wantEntries), len(gotEntries), "%v", q)
+		for i := range wantEntries {
+			assert.Equal(t, wantEntries[i].Key, gotEntries[i].Key, "%v", q)
+			assert.Equal(t, wantEntries[i].Value, gotEntries[i].Value, "%v", q)
+		}
+	}
+}
+
+// BenchmarkShardedQuery reads the first entries of a query over all the
+// keys of the shards: the merge only holds the next entry of each shard,
+// so the memory allocated does not depend on the number of keys
+func BenchmarkShardedQuery(b *testing.B) {
+	for _, n := range []int{1000, 100000} {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			var paths []string
+			for i := 0; i < 4; i++ {
+				paths = append(paths, filepath.Join(b.TempDir(), fmt.Sprintf("shard-%d", i)))
+			}
+			s, err := NewShardedDatastore(paths, 0)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer s.Close()
+			entries := make(map[*Datastore][]KeyValue)
+			for _, k := range benchmarkKeys(n) {
+				shard := s.shard(k.Bytes())
+				entries[shard] = append(entries[shard], KeyValue{Key: k, Value: k.Bytes()})
+			}
+			for shard, entries := range entries {
+				if err := shard.PutMany(bg, entries); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				results, err := s.Query(bg, query.Query{})
+				if err != nil {
+					b.Fatal(err)
+				}
+				for j := 0; j < 100; j++ {
+					if _, ok := results.NextSync(); !ok {
+						b.Fatal("missing entries")
+					}
+				}
+				results.Close()
+			}
+		})
+	}
+}
+
+func TestShardedQueryError(t *testing.T) {
+	var shards []*Datastore
+	for i := 0; i < 2; i++ {
+		ds, err := NewDatastoreWithOptions(filepath.Join(t.TempDir(), "bolt"),
+			WithValueChecksum(crc32.NewIEEE))
+		assert.NoError(t, err)
+		defer ds.Close()
+		shards = append(shards, ds)
+	}
+	for i := 1; i <= 6; i++ {
+		key := dskey.NewBytesKeyFromString(fmt.Sprint(i))
+		assert.NoError(t, shards[i%2].Put(bg, key, key.Bytes()))
+	}
+	// the value of 4 fails to decode in the middle of its shard
+	assert.NoError(t, shards[0].bolt().Update(func(tx *bbolt.Tx) error {
+		bucket := shards[0].bucketOf(tx)
+		stored := copyBytes(bucket.Get([]byte("4")))
+		stored[0] ^= 1
+		return bucket.Put([]byte("4"), stored)
+	}))
+
+	results, err := mergeQuery(bg, query.Query{}, shards)
+	assert.NoError(t, err)
+	defer results.Close()
+	var keys []string
+	for r := range results.Next() {
+		if r.Error != nil {
+			keys = append(keys, "error")
+			continue
+		}
+		keys = append(keys, r.Key.String())
+	}
+	// the entry popped before the error is returned, and the error ends
+	// the results
+	assert.Equal(t, []string{"1", "2", "error"}, keys)
+}
@@ -0,0 +1,107 @@
+package dsbbolt
+
+import (
+	"bytes"
+	"context"
+	"os"
+
+	"github.com/daotl/go-datastore"
+	"go.etcd.io/bbolt"
+)
+
+var _ datastore.PersistentDatastore = (*Datastore)(nil)
+
+// Stats describes the state of a datastore bucket and its db file
+type Stats struct {
+	// KeyCount is the number of keys of the datastore, within its scope
+	// and not counting nested buckets, including the expired keys not
+	// deleted yet. The other counts cover the whole bucket.
+	KeyCount    int
+	BucketDepth int   // number of levels in the bucket B+tree
+	LeafPages   int   // number of leaf pages used by the bucket
+	BranchPages int   // number of branch pages used by the bucket
+	FileSize    int64 // size of the db file in bytes
+}
+
+// Stat returns statistics about the datastore bucket and the db file
+func (d *Datastore) Stat(ctx context.Context) (Stats, error) {
+	ctx = orBackground(ctx)
+	var stats Stats
+	if err := d.bolt().View(func(tx *bbolt.Tx) error {
+		bucket, err := d.openBucket(tx)
+		if err != nil {
+			return err
+		}
+		bs := bucket.Stats()
+		if len(d.scope) == 0 && bs.BucketN == 1 {
+			// nested buckets would add their own keys to the count
+			stats.KeyCount = bs.KeyN
+		} else {
+			start, limit := d.scopeBounds()
+			if stats.KeyCount, err = countStored(ctx, bucket, start, limit); err != nil {
+				return err
+			}
+		}
+		stats.BucketDepth = bs.Depth
+		stats.LeafPages = bs.LeafPageN
+		stats.BranchPages = bs.BranchPageN
+		return nil
+	}); err != nil {
+		return Stats{}, err
+	}
+	fi, err := os.Stat(d.bolt().Path())
+	if err != nil {
+		return Stats{}, err
+	}
+	stats.FileSize = fi.Size()
+	return stats, nil
+}
+
+// countStored returns the number of stored keys of bucket from start to
+// limit excluded, nil bounds being unbounded, skipping nested buckets
+func countStored(ctx context.Context, bucket *bbolt.Bucket, start, limit []byte) (int, error) {
+	n := 0
+	c := bucket.Cursor()
+	k, v := c.First()
+	if start != nil {
+		k, v = c.Seek(start)
+	}
+	for steps := 0; k != nil && (limit == nil || bytes.Compare(k, limit) < 0); steps++ {
+		if steps%queryCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return 0, err
+			}
+		}
+		if v != nil {
+			n++
+		}
+		k, v = c.Next()
+	}
+	return n, nil
+}
+
+// DiskUsage returns the size of the db file in bytes, bucket views sharing
+// a db report the size of the whole file
+func (d *Datastore) DiskUsage(ctx context.Context) (uint64, error) {
+	fi, err := os.Stat(d.bolt().Path())
+	if err != nil {
+		return 0, err
+	}
+	return uint64(fi.Size()), nil
+}
+
+// LastCommitTxID returns the ID of the last write transaction committed to
+// the db, as seen by a new read transaction: it increases by one with
+// every commit. A replica opened read-only can compare it with the ID of
+// its primary to tell how far behind it is, and Reopen once it falls too
+// far behind.
+func (d *Datastore) LastCommitTxID(ctx context.Context) (int, error) {
+	var id int
+	if err := d.bolt().View(func(tx *bbolt.Tx) error {
+		id = tx.ID()
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
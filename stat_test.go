@@ -0,0 +1,88 @@
+package dsbbolt
+
+import (
+	"path/filepath"
+	"testing"
+
+	dskey "github.com/daotl/go-datastore/key"
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/bbolt"
+)
+
+func TestStat(t *testing.T) {
+	ds := newTestDatastore(t)
+	stats, err := ds.Stat(bg)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, stats.KeyCount)
+
+	keys := benchmarkKeys(1000)
+	for _, k := range keys {
+		assert.NoError(t, ds.Put(bg, k, []byte("value")))
+	}
+	stats, err = ds.Stat(bg)
+	assert.NoError(t, err)
+	assert.Equal(t, len(keys), stats.KeyCount)
+	assert.True(t, stats.LeafPages > 0)
+	assert.True(t, stats.BucketDepth > 0)
+	assert.True(t, stats.FileSize > 0)
+
+	// neither nested buckets nor keys out of the scope are counted
+	assert.NoError(t, ds.bolt().Update(func(tx *bbolt.Tx) error {
+		nested, err := ds.bucketOf(tx).CreateBucket([]byte("nested"))
+		if err != nil {
+			return err
+		}
+		return nested.Put([]byte("key"), []byte("value"))
+	}))
+	stats, err = ds.Stat(bg)
+	assert.NoError(t, err)
+	assert.Equal(t, len(keys), stats.KeyCount)
+	scoped := ds.Scoped([]byte("bench-0000000"))
+	stats, err = scoped.Stat(bg)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, stats.KeyCount)
+}
+
+func TestDiskUsage(t *testing.T) {
+	ds := newTestDatastore(t)
+	before, err := ds.DiskUsage(bg)
+	assert.NoError(t, err)
+	assert.True(t, before > 0)
+
+	value := make([]byte, 1<<20)
+	for _, k := range benchmarkKeys(8) {
+		assert.NoError(t, ds.Put(bg, k, value))
+	}
+	after, err := ds.DiskUsage(bg)
+	assert.NoError(t, err)
+	assert.True(t, after >= before+8<<20)
+}
+
+func TestLastCommitTxID(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bolt")
+	ds, err := NewDatastore(path, nil, nil, dskey.KeyTypeBytes)
+	assert.NoError(t, err)
+	first, err := ds.LastCommitTxID(bg)
+	assert.NoError(t, err)
+	assert.True(t, first > 0)
+	for i, k := range benchmarkKeys(3) {
+		assert.NoError(t, ds.Put(bg, k, []byte("v")))
+		id, err := ds.LastCommitTxID(bg)
+		assert.NoError(t, err)
+		assert.Equal(t, first+i+1, id)
+	}
+	// reads do not commit
+	_, err = ds.Get(bg, benchmarkKeys(1)[0])
+	assert.NoError(t, err)
+	last, err := ds.LastCommitTxID(bg)
+	assert.NoError(t, err)
+	assert.Equal(t, first+3, last)
+	assert.NoError(t, ds.Close())
+
+	replica, err := NewDatastore(path, &bbolt.Options{ReadOnly: true}, nil, dskey.KeyTypeBytes)
+	assert.NoError(t, err)
+	defer replica.Close()
+	id, err := replica.LastCommitTxID(bg)
+	assert.NoError(t, err)
+	assert.Equal(t, last, id)
+}
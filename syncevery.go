@@ -0,0 +1,71 @@
+package dsbbolt
+
+import (
+	"sync"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// syncPolicy syncs a db opened with NoSync every n committed writes, see
+// WithSyncEvery
+type syncPolicy struct {
+	n       int
+	mu      sync.Mutex
+	writes  int       // committed since the last sync
+	tx      *bbolt.Tx // write transaction pending counts the writes of
+	pending *int
+}
+
+// countWrite counts a put or delete made in tx, to be added to the
+// committed writes once tx commits
+func (d *Datastore) countWrite(tx *bbolt.Tx) {
+	p := d.db.syncs
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.tx != tx {
+		// bbolt runs a single write transaction at a time, a rolled back
+		// one is simply forgotten
+		pending := new(int)
+		p.tx, p.pending = tx, pending
+		tx.OnCommit(func() {
+			d.committed(pending)
+		})
+	}
+	*p.pending++
+}
+
+// committed adds the writes of a committed transaction and syncs the db
+// once they add up to n
+func (d *Datastore) committed(pending *int) {
+	p := d.db.syncs
+	p.mu.Lock()
+	p.writes += *pending
+	due := p.writes >= p.n
+	if due {
+		p.writes %= p.n
+	}
+	p.mu.Unlock()
+	if !due {
+		return
+	}
+	var err error
+	if d.metrics != nil {
+		defer d.observe(OpSync, time.Now(), &err)
+	}
+	if err = d.bolt().Sync(); err != nil {
+		d.logger.Error("sync", "err", err)
+	}
+}
+
+// resetWrites restarts the count of writes after the db was synced
+func (h *dbHandle) resetWrites() {
+	if p := h.syncs; p != nil {
+		p.mu.Lock()
+		p.writes = 0
+		p.mu.Unlock()
+	}
+}
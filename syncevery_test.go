@@ -0,0 +1,70 @@
+package dsbbolt
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	dskey "github.com/daotl/go-datastore/key"
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/bbolt"
+)
+
+func countOps(hook *recordingHook, op string) int {
+	n := 0
+	for _, e := range hook.events {
+		if e.op == op {
+			n++
+		}
+	}
+	return n
+}
+
+func TestSyncEvery(t *testing.T) {
+	hook := &recordingHook{}
+	ds, err := NewDatastoreWithOptions(filepath.Join(t.TempDir(), "bolt"),
+		WithBoltOptions(&bbolt.Options{NoSync: true}),
+		WithSyncEvery(10),
+		WithMetricsHook(hook))
+	assert.NoError(t, err)
+	defer ds.Close()
+
+	for i := 0; i < 25; i++ {
+		assert.NoError(t, ds.Put(bg, dskey.NewBytesKeyFromString(fmt.Sprintf("key-%d", i)), []byte("v")))
+	}
+	assert.Equal(t, 2, countOps(hook, OpSync))
+
+	// the writes of a batch are counted once it commits, adding up to 10
+	// with the 5 left over
+	b, err := ds.Batch(bg)
+	assert.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, b.Delete(bg, dskey.NewBytesKeyFromString(fmt.Sprintf("key-%d", i))))
+	}
+	assert.Equal(t, 2, countOps(hook, OpSync))
+	assert.NoError(t, b.Commit(bg))
+	assert.Equal(t, 3, countOps(hook, OpSync))
+
+	// discarded writes are not counted, and Sync starts the count over
+	txn, err := ds.NewTransaction(bg, false)
+	assert.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		assert.NoError(t, txn.Put(bg, dskey.NewBytesKeyFromString(fmt.Sprintf("txn-%d", i)), []byte("v")))
+	}
+	txn.Discard(bg)
+	assert.NoError(t, ds.Sync(bg, nil))
+	assert.Equal(t, 4, countOps(hook, OpSync))
+	for i := 0; i < 9; i++ {
+		assert.NoError(t, ds.Put(bg, dskey.NewBytesKeyFromString(fmt.Sprintf("key-%d", i)), []byte("v")))
+	}
+	assert.Equal(t, 4, countOps(hook, OpSync))
+}
+
+func TestSyncEveryWithoutNoSync(t *testing.T) {
+	hook := &recordingHook{}
+	ds, err := NewDatastoreWithOptions(filepath.Join(t.TempDir(), "bolt"), WithSyncEvery(1), WithMetricsHook(hook))
+	assert.NoError(t, err)
+	defer ds.Close()
+	assert.NoError(t, ds.Put(bg, dskey.NewBytesKeyFromString("key"), []byte("v")))
+	assert.Equal(t, 0, countOps(hook, OpSync))
+}
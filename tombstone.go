@@ -0,0 +1,163 @@
+package dsbbolt
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	dskey "github.com/daotl/go-datastore/key"
+	"go.etcd.io/bbolt"
+)
+
+// ErrNoTombstones is returned by QueryTombstones and PurgeTombstones when
+// tombstones are not enabled
+var ErrNoTombstones = errors.New("tombstones are not enabled")
+
+// tombstonesBucket is the bucket of metaBucket holding the tombstones, a
+// bucket per datastore bucket keyed by bucketPathKey, mapping the stored
+// keys deleted to the time they were deleted at in Unix nanoseconds
+var tombstonesBucket = []byte("tombstones")
+
+// Tombstone records the deletion of a key, see WithTombstones
+type Tombstone struct {
+	Key     dskey.Key
+	Deleted time.Time
+}
+
+// tombstoneBucket returns the tombstones of the datastore, nil if they do
+// not exist and create is false
+func (d *Datastore) tombstoneBucket(tx *bbolt.Tx, create bool) (*bbolt.Bucket, error) {
+	name := bucketPathKey(d.bucket)
+	if !create {
+		b := tx.Bucket(metaBucket)
+		if b != nil {
+			b = b.Bucket(tombstonesBucket)
+		}
+		if b != nil {
+			b = b.Bucket(name)
+		}
+		return b, nil
+	}
+	b, err := tx.CreateBucketIfNotExists(metaBucket)
+	if err == nil {
+		b, err = b.CreateBucketIfNotExists(tombstonesBucket)
+	}
+	if err == nil {
+		b, err = b.CreateBucketIfNotExists(name)
+	}
+	return b, err
+}
+
+// bury records the deletion of the stored key k
+func (d *Datastore) bury(tx *bbolt.Tx, k []byte) error {
+	tombstones, err := d.tombstoneBucket(tx, true)
+	if err != nil {
+		return err
+	}
+	var deleted [8]byte
+	binary.BigEndian.PutUint64(deleted[:], uint64(time.Now().UnixNano()))
+	return tombstones.Put(copyBytes(k), deleted[:])
+}
+
+// unbury removes the tombstone of the stored key k, if any, once it is put
+// again
+func (d *Datastore) unbury(tx *bbolt.Tx, k []byte) error {
+	tombstones, err := d.tombstoneBucket(tx, false)
+	if err != nil || tombstones == nil {
+		return err
+	}
+	return tombstones.Delete(k)
+}
+
+// QueryTombstones returns the tombstones of the keys a Query with the same
+// prefix would have returned before they were deleted, a nil prefix
+// returning all of them, ordered by key. The tombstones are collected in a
+// single read transaction.
+func (d *Datastore) QueryTombstones(ctx context.Context, prefix dskey.Key) ([]Tombstone, error) {
+	if !d.tombstones {
+		return nil, ErrNoTombstones
+	}
+	if keyTypeMismatch(prefix, d.ktype) {
+		return nil, ErrKeyTypeNotMatch
+	}
+	ctx = orBackground(ctx)
+	start, limit, err := d.prefixBounds(prefix)
+	if err != nil {
+		return nil, err
+	}
+	var result []Tombstone
+	err = d.bolt().View(func(tx *bbolt.Tx) error {
+		tombstones, err := d.tombstoneBucket(tx, false)
+		if err != nil || tombstones == nil {
+			return err
+		}
+		c := tombstones.Cursor()
+		k, v := c.First()
+		if start != nil {
+			k, v = c.Seek(start)
+		}
+		for ; k != nil && (limit == nil || bytes.Compare(k, limit) < 0); k, v = c.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if len(v) != 8 {
+				return ErrInvalidValue
+			}
+			result = append(result, Tombstone{
+				Key:     dskey.NewBytesKey(copyBytes(d.userKey(k))),
+				Deleted: time.Unix(0, int64(binary.BigEndian.Uint64(v))),
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// PurgeTombstones removes the tombstones of the keys deleted more than
+// olderThan ago, e.g. once every replica has seen them, and returns how
+// many were removed
+func (d *Datastore) PurgeTombstones(ctx context.Context, olderThan time.Duration) (int, error) {
+	if !d.tombstones {
+		return 0, ErrNoTombstones
+	}
+	if d.bolt().IsReadOnly() {
+		return 0, ErrReadOnly
+	}
+	start, limit := d.scopeBounds()
+	cutoff := time.Now().Add(-olderThan)
+	n := 0
+	err := d.update(ctx, func(tx *bbolt.Tx) error {
+		tombstones, err := d.tombstoneBucket(tx, false)
+		if err != nil || tombstones == nil {
+			return err
+		}
+		c := tombstones.Cursor()
+		k, v := c.First()
+		if start != nil {
+			k, v = c.Seek(start)
+		}
+		for k != nil && (limit == nil || bytes.Compare(k, limit) < 0) {
+			if len(v) == 8 && time.Unix(0, int64(binary.BigEndian.Uint64(v))).After(cutoff) {
+				k, v = c.Next()
+				continue
+			}
+			// Next may skip a key after Delete, seek past the deleted key instead
+			purged := copyBytes(k)
+			if err := c.Delete(); err != nil {
+				return err
+			}
+			n++
+			k, v = c.Seek(purged)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
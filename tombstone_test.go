@@ -0,0 +1,90 @@
+package dsbbolt
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/daotl/go-datastore"
+	dskey "github.com/daotl/go-datastore/key"
+	"github.com/stretchr/testify/assert"
+)
+
+func tombstoneKeys(tombstones []Tombstone) []dskey.Key {
+	keys := make([]dskey.Key, len(tombstones))
+	for i, t := range tombstones {
+		keys[i] = t.Key
+	}
+	return keys
+}
+
+func TestTombstones(t *testing.T) {
+	ds, err := NewDatastoreWithOptions(filepath.Join(t.TempDir(), "bolt"), WithTombstones())
+	assert.NoError(t, err)
+	defer ds.Close()
+
+	a, b := dskey.NewBytesKeyFromString("docs/a"), dskey.NewBytesKeyFromString("docs/b")
+	other := dskey.NewBytesKeyFromString("other")
+	for _, k := range []dskey.Key{a, b, other} {
+		assert.NoError(t, ds.Put(bg, k, []byte("v")))
+	}
+	before := time.Now()
+	assert.NoError(t, ds.Delete(bg, a))
+	_, err = ds.Get(bg, a)
+	assert.Equal(t, datastore.ErrNotFound, err)
+	has, err := ds.Has(bg, a)
+	assert.NoError(t, err)
+	assert.False(t, has)
+
+	tombstones, err := ds.QueryTombstones(bg, nil)
+	assert.NoError(t, err)
+	if assert.Len(t, tombstones, 1) {
+		assert.Equal(t, a, tombstones[0].Key)
+		assert.False(t, tombstones[0].Deleted.Before(before.Truncate(0)))
+	}
+	// deleting an absent key leaves no tombstone
+	assert.NoError(t, ds.Delete(bg, dskey.NewBytesKeyFromString("missing")))
+
+	txn, err := ds.NewTransaction(bg, false)
+	assert.NoError(t, err)
+	assert.NoError(t, txn.Delete(bg, other))
+	assert.NoError(t, txn.Commit(bg))
+	_, err = ds.DeletePrefix(bg, dskey.NewBytesKeyFromString("docs/"))
+	assert.NoError(t, err)
+	tombstones, err = ds.QueryTombstones(bg, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []dskey.Key{a, b, other}, tombstoneKeys(tombstones))
+	tombstones, err = ds.QueryTombstones(bg, dskey.NewBytesKeyFromString("docs/"))
+	assert.NoError(t, err)
+	assert.Equal(t, []dskey.Key{a, b}, tombstoneKeys(tombstones))
+
+	// putting a key again removes its tombstone
+	assert.NoError(t, ds.Put(bg, b, []byte("w")))
+	tombstones, err = ds.QueryTombstones(bg, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []dskey.Key{a, other}, tombstoneKeys(tombstones))
+
+	n, err := ds.PurgeTombstones(bg, time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+	n, err = ds.PurgeTombstones(bg, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	tombstones, err = ds.QueryTombstones(bg, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, tombstones)
+
+	// Truncate leaves a tombstone for every key it deletes
+	assert.NoError(t, ds.Truncate(bg))
+	tombstones, err = ds.QueryTombstones(bg, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []dskey.Key{b}, tombstoneKeys(tombstones))
+}
+
+func TestTombstonesDisabled(t *testing.T) {
+	ds := newTestDatastore(t)
+	_, err := ds.QueryTombstones(bg, nil)
+	assert.Equal(t, ErrNoTombstones, err)
+	_, err = ds.PurgeTombstones(bg, 0)
+	assert.Equal(t, ErrNoTombstones, err)
+}
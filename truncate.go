@@ -0,0 +1,75 @@
+package dsbbolt
+
+import (
+	"context"
+
+	"go.etcd.io/bbolt"
+)
+
+// Truncate deletes every key of the datastore in a single write
+// transaction by deleting its bucket and creating it again, which is much
+// faster than deleting keys one by one. Nested buckets created in the
+// bucket through the bbolt db are deleted along with it. A Scoped
+// Datastore only deletes the keys of its scope, one by one. With
+// WithTombstones, a tombstone is still written for every deleted key.
+func (d *Datastore) Truncate(ctx context.Context) error {
+	if d.bolt().IsReadOnly() {
+		return ErrReadOnly
+	}
+	return d.update(ctx, func(tx *bbolt.Tx) error {
+		d.invalidateQueries(tx)
+		if len(d.scope) > 0 {
+			bucket, err := d.openBucket(tx)
+			if err != nil {
+				return err
+			}
+			start, limit := d.scopeBounds()
+			_, err = d.deleteRange(bucket, start, limit, 0)
+			return err
+		}
+		if err := d.deleteIndex(tx); err != nil {
+			return err
+		}
+		if d.tombstones {
+			if err := d.buryAll(tx); err != nil {
+				return err
+			}
+		}
+		name := d.bucket[len(d.bucket)-1]
+		if len(d.bucket) == 1 {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+			_, err := tx.CreateBucket(name)
+			return err
+		}
+		parent := lookupBucket(tx, d.bucket[:len(d.bucket)-1])
+		if parent == nil {
+			return ErrBucketNotFound
+		}
+		if err := parent.DeleteBucket(name); err != nil {
+			return err
+		}
+		_, err := parent.CreateBucket(name)
+		return err
+	})
+}
+
+// buryAll records the deletion of every stored key of the bucket
+func (d *Datastore) buryAll(tx *bbolt.Tx) error {
+	bucket, err := d.openBucket(tx)
+	if err != nil {
+		return err
+	}
+	c := bucket.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		// nested buckets have no tombstone
+		if v == nil {
+			continue
+		}
+		if err := d.bury(tx, k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package dsbbolt
+
+import (
+	"path/filepath"
+	"testing"
+
+	dskey "github.com/daotl/go-datastore/key"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTruncate(t *testing.T) {
+	ds := newTestDatastore(t)
+	keys := benchmarkKeys(10000)
+	entries := make([]KeyValue, len(keys))
+	for i, k := range keys {
+		entries[i] = KeyValue{Key: k, Value: []byte("value")}
+	}
+	assert.NoError(t, ds.PutMany(bg, entries))
+	other, err := ds.WithBucket([]byte("other"))
+	assert.NoError(t, err)
+	assert.NoError(t, other.Put(bg, keys[0], []byte("value")))
+
+	assert.NoError(t, ds.Truncate(bg))
+	n, err := ds.Count(bg, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+	n, err = other.Count(bg, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	assert.NoError(t, ds.Put(bg, keys[0], []byte("again")))
+	value, err := ds.Get(bg, keys[0])
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("again"), value)
+}
+
+func TestTruncateNestedAndScoped(t *testing.T) {
+	ds, err := NewDatastoreWithOptions(filepath.Join(t.TempDir(), "bolt"),
+		WithBucketPath([]byte("parent"), []byte("child")))
+	assert.NoError(t, err)
+	defer ds.Close()
+	a, b := ds.Scoped([]byte("a/")), ds.Scoped([]byte("b/"))
+	key := dskey.NewBytesKeyFromString("key")
+	assert.NoError(t, a.Put(bg, key, []byte("value")))
+	assert.NoError(t, b.Put(bg, key, []byte("value")))
+
+	assert.NoError(t, a.Truncate(bg))
+	has, err := a.Has(bg, key)
+	assert.NoError(t, err)
+	assert.False(t, has)
+	has, err = b.Has(bg, key)
+	assert.NoError(t, err)
+	assert.True(t, has)
+
+	assert.NoError(t, ds.Truncate(bg))
+	n, err := ds.Count(bg, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
@@ -0,0 +1,266 @@
+package dsbbolt
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/daotl/go-datastore"
+	dskey "github.com/daotl/go-datastore/key"
+	"go.etcd.io/bbolt"
+)
+
+var _ datastore.TTLDatastore = (*Datastore)(nil)
+
+// PutWithTTL stores value under key until ttl has elapsed, after which the
+// key reads as not found until it is deleted, lazily in the background
+// once Get finds it expired or by the reaper. The expiration time is stored
+// in the metadata header, so metadata must have been enabled with
+// WithMetadata.
+func (d *Datastore) PutWithTTL(ctx context.Context, key dskey.Key, value []byte, ttl time.Duration) error {
+	if key.KeyType() != d.ktype {
+		return ErrKeyTypeNotMatch
+	}
+	if !d.meta {
+		return ErrNoMetadata
+	}
+	if d.bolt().IsReadOnly() {
+		return ErrReadOnly
+	}
+	k, err := d.storedKey(key)
+	if err != nil {
+		return err
+	}
+	if err := d.checkEntry(k, value); err != nil {
+		return err
+	}
+	if value, err = d.encodeValue(k, value); err != nil {
+		return err
+	}
+	return d.update(ctx, func(tx *bbolt.Tx) error {
+		bucket, err := d.openBucket(tx)
+		if err != nil {
+			return err
+		}
+		return d.putStoredWithExpiration(bucket, k, value, time.Now().Add(ttl))
+	})
+}
+
+// SetTTL makes the value of key expire once ttl has elapsed
+func (d *Datastore) SetTTL(ctx context.Context, key dskey.Key, ttl time.Duration) error {
+	if key.KeyType() != d.ktype {
+		return ErrKeyTypeNotMatch
+	}
+	if !d.meta {
+		return ErrNoMetadata
+	}
+	if d.bolt().IsReadOnly() {
+		return ErrReadOnly
+	}
+	k, err := d.storedKey(key)
+	if err != nil {
+		return err
+	}
+	return d.update(ctx, func(tx *bbolt.Tx) error {
+		bucket, err := d.openBucket(tx)
+		if err != nil {
+			return err
+		}
+		data := bucket.Get(k)
+		if data == nil {
+			return datastore.ErrNotFound
+		}
+		now := time.Now()
+		m, err := d.liveMeta(data, now)
+		if err != nil {
+			return err
+		}
+		_, value, _ := parseMeta(data)
+		m.Expiration = now.Add(ttl)
+		d.wrote(tx, EventPut, k)
+		return bucket.Put(k, appendMeta(nil, m, value))
+	})
+}
+
+// GetExpiration returns the time the value of key expires at, the zero
+// time if it never does
+func (d *Datastore) GetExpiration(ctx context.Context, key dskey.Key) (time.Time, error) {
+	if key.KeyType() != d.ktype {
+		return time.Time{}, ErrKeyTypeNotMatch
+	}
+	if !d.meta {
+		return time.Time{}, ErrNoMetadata
+	}
+	k, err := d.storedKey(key)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var m Meta
+	if err := d.bolt().View(func(tx *bbolt.Tx) error {
+		bucket, err := d.openBucket(tx)
+		if err != nil {
+			return err
+		}
+		data := bucket.Get(k)
+		if data == nil {
+			return datastore.ErrNotFound
+		}
+		m, err = d.liveMeta(data, time.Now())
+		return err
+	}); err != nil {
+		return time.Time{}, err
+	}
+	return m.Expiration, nil
+}
+
+// deleteExpired deletes key in the background if its value has expired,
+// on a best effort basis since a reader finding an expired value still
+// reports it missing. It is skipped when a write transaction is open,
+// maybe by the reader itself, leaving the key to the reaper.
+func (d *Datastore) deleteExpired(key []byte) {
+	if d.bolt().IsReadOnly() {
+		return
+	}
+	h := d.db
+	select {
+	case h.writes <- struct{}{}:
+	default:
+		return
+	}
+	key = copyBytes(key)
+	go func() {
+		defer h.unlockWrites()
+		// Close waits for the deletion like for a transaction
+		h.txns.RLock()
+		defer h.txns.RUnlock()
+		d.releaseReads()
+		err := d.bolt().Update(func(tx *bbolt.Tx) error {
+			bucket, err := d.openBucket(tx)
+			if err != nil {
+				return err
+			}
+			if data := bucket.Get(key); data != nil {
+				if _, err := d.liveMeta(data, time.Now()); err == datastore.ErrNotFound {
+					return d.deleteStored(bucket, key)
+				}
+			}
+			return nil
+		})
+		if err != nil && err != bbolt.ErrDatabaseNotOpen {
+			d.logger.Warn("delete expired key", "key", key, "err", err)
+		}
+	}()
+}
+
+// ReapExpired deletes every expired key in a single write transaction and
+// returns how many were deleted
+func (d *Datastore) ReapExpired(ctx context.Context) (int, error) {
+	ctx = orBackground(ctx)
+	if !d.meta {
+		return 0, nil
+	}
+	if d.bolt().IsReadOnly() {
+		return 0, ErrReadOnly
+	}
+	deleted := 0
+	err := d.update(ctx, func(tx *bbolt.Tx) error {
+		now := time.Now()
+		bucket, err := d.openBucket(tx)
+		if err != nil {
+			return err
+		}
+		c := bucket.Cursor()
+		for k, v := c.First(); k != nil; {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if v == nil {
+				// nested bucket
+				k, v = c.Next()
+				continue
+			}
+			if _, err := d.liveMeta(v, now); err != datastore.ErrNotFound {
+				k, v = c.Next()
+				continue
+			}
+			// deleting moves the cursor, seek past the deleted key instead
+			reaped := copyBytes(k)
+			if err := d.beforeDelete(bucket, reaped, v); err != nil {
+				return err
+			}
+			if err := c.Delete(); err != nil {
+				return err
+			}
+			deleted++
+			k, v = c.Seek(reaped)
+			if k != nil && bytes.Equal(k, reaped) {
+				k, v = c.Next()
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return deleted, nil
+}
+
+// reaper runs ReapExpired periodically in the background
+type reaper struct {
+	mu   sync.Mutex
+	stop chan struct{} // nil when the reaper is not running
+	done chan struct{}
+}
+
+// StartReaper starts deleting expired keys every interval in the
+// background, restarting the reaper if it is already running. Close stops
+// it, as does StopReaper. The reaper of a datastore returned by WithBucket
+// is also stopped when the datastore owning the db is closed.
+func (d *Datastore) StartReaper(interval time.Duration) error {
+	if !d.meta {
+		return ErrNoMetadata
+	}
+	if d.bolt().IsReadOnly() {
+		return ErrReadOnly
+	}
+	d.StopReaper()
+	if !d.ownsDB {
+		d.db.addView(d)
+	}
+	r := d.reaper
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stop, done := make(chan struct{}), make(chan struct{})
+	r.stop, r.done = stop, done
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if _, err := d.ReapExpired(context.Background()); err != nil {
+					d.logger.Warn("reap expired keys", "err", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// StopReaper stops the background reaper and waits for it to return, it is
+// a no-op if the reaper is not running
+func (d *Datastore) StopReaper() {
+	r := d.reaper
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stop == nil {
+		return
+	}
+	close(r.stop)
+	<-r.done
+	r.stop, r.done = nil, nil
+}
@@ -0,0 +1,132 @@
+package dsbbolt
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/daotl/go-datastore"
+	dskey "github.com/daotl/go-datastore/key"
+	"github.com/daotl/go-datastore/query"
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/bbolt"
+)
+
+// storedValue returns the raw stored value of key, bypassing expiration
+func storedValue(t *testing.T, ds *Datastore, key dskey.Key) []byte {
+	var stored []byte
+	assert.NoError(t, ds.bolt().View(func(tx *bbolt.Tx) error {
+		if v := ds.bucketOf(tx).Get(key.Bytes()); v != nil {
+			stored = copyBytes(v)
+		}
+		return nil
+	}))
+	return stored
+}
+
+func TestTTL(t *testing.T) {
+	ds, err := NewDatastoreWithOptions(filepath.Join(t.TempDir(), "bolt"), WithMetadata())
+	assert.NoError(t, err)
+	defer ds.Close()
+
+	key := dskey.NewBytesKeyFromString("cached")
+	kept := dskey.NewBytesKeyFromString("kept")
+	before := time.Now()
+	assert.NoError(t, ds.PutWithTTL(bg, key, []byte("value"), 50*time.Millisecond))
+	assert.NoError(t, ds.Put(bg, kept, []byte("value")))
+
+	expiration, err := ds.GetExpiration(bg, key)
+	assert.NoError(t, err)
+	assert.True(t, expiration.After(before.Add(40*time.Millisecond)))
+	expiration, err = ds.GetExpiration(bg, kept)
+	assert.NoError(t, err)
+	assert.True(t, expiration.IsZero())
+	value, err := ds.Get(bg, key)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("value"), value)
+
+	time.Sleep(60 * time.Millisecond)
+	// expired keys are hidden before they are deleted
+	results, err := ds.Query(bg, query.Query{})
+	assert.NoError(t, err)
+	entries, err := results.Rest()
+	assert.NoError(t, err)
+	assert.Equal(t, []dskey.Key{kept}, query.EntryKeys(entries))
+	txn, err := ds.NewTransaction(bg, true)
+	assert.NoError(t, err)
+	has, err := txn.Has(bg, key)
+	assert.NoError(t, err)
+	assert.False(t, has)
+	txn.Discard(bg)
+	assert.NotNil(t, storedValue(t, ds, key))
+
+	// Get deletes the expired key lazily, without waiting for an open
+	// write transaction
+	wtxn, err := ds.NewTransaction(bg, false)
+	assert.NoError(t, err)
+	_, err = ds.Get(bg, key)
+	assert.Equal(t, datastore.ErrNotFound, err)
+	wtxn.Discard(bg)
+	assert.NotNil(t, storedValue(t, ds, key))
+	_, err = ds.Get(bg, key)
+	assert.Equal(t, datastore.ErrNotFound, err)
+	deadline := time.Now().Add(5 * time.Second)
+	for storedValue(t, ds, key) != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("expired key not deleted")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	_, err = ds.GetExpiration(bg, key)
+	assert.Equal(t, datastore.ErrNotFound, err)
+
+	assert.NoError(t, ds.SetTTL(bg, kept, time.Hour))
+	expiration, err = ds.GetExpiration(bg, kept)
+	assert.NoError(t, err)
+	assert.True(t, expiration.After(time.Now().Add(59*time.Minute)))
+	value, err = ds.Get(bg, kept)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("value"), value)
+}
+
+func TestReaper(t *testing.T) {
+	ds, err := NewDatastoreWithOptions(filepath.Join(t.TempDir(), "bolt"),
+		WithMetadata(), WithReapInterval(10*time.Millisecond))
+	assert.NoError(t, err)
+	defer ds.Close()
+
+	for _, k := range benchmarkKeys(100) {
+		assert.NoError(t, ds.PutWithTTL(bg, k, []byte("value"), 50*time.Millisecond))
+	}
+	kept := dskey.NewBytesKeyFromString("kept")
+	assert.NoError(t, ds.Put(bg, kept, []byte("value")))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stats, err := ds.Stat(bg)
+		assert.NoError(t, err)
+		if stats.KeyCount == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d keys left", stats.KeyCount)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.NotNil(t, storedValue(t, ds, kept))
+
+	ds.StopReaper()
+	ds.StopReaper()
+	assert.NoError(t, ds.PutWithTTL(bg, kept, []byte("value"), time.Nanosecond))
+	time.Sleep(time.Millisecond)
+	n, err := ds.ReapExpired(bg)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+}
+
+func TestTTLWithoutMetadata(t *testing.T) {
+	ds := newTestDatastore(t)
+	key := dskey.NewBytesKeyFromString("key")
+	assert.Equal(t, ErrNoMetadata, ds.PutWithTTL(bg, key, []byte("value"), time.Second))
+	assert.Equal(t, ErrNoMetadata, ds.StartReaper(time.Second))
+}
@@ -1,7 +1,9 @@
 package dsbbolt
 
 import (
+	"bytes"
 	"context"
+	"time"
 
 	"github.com/daotl/go-datastore"
 	dskey "github.com/daotl/go-datastore/key"
@@ -9,32 +11,111 @@ import (
 	"go.etcd.io/bbolt"
 )
 
+// BoltTxn is implemented by the transactions of a Datastore, giving access
+// to the underlying bbolt transaction
+type BoltTxn interface {
+	datastore.Txn
+	// BoltTx returns the bbolt transaction backing the datastore
+	// transaction, e.g. to use nested buckets or sequences atomically with
+	// datastore operations. The datastore bucket must not be modified
+	// through it, nor the transaction committed or rolled back: doing so
+	// can break the invariants of the datastore, such as the encoding of
+	// stored values.
+	BoltTx() *bbolt.Tx
+	// LastError returns the error of the last Discard that failed, nil if
+	// none did, since Discard itself cannot return it
+	LastError() error
+	// ForEach calls fn on every key a Query with the same prefix would
+	// return, a nil prefix walking all keys, in key order. The key is
+	// deleted when fn returns true, which a writable transaction can do
+	// during the scan, unlike with the results of Query. An error returned
+	// by fn stops the scan and is returned.
+	ForEach(ctx context.Context, prefix dskey.Key, fn func(key dskey.Key, value []byte) (deleteIt bool, err error)) error
+	// ID returns the ID of the bbolt transaction, also available once it
+	// is closed
+	ID() int
+	// Stats returns the statistics of the bbolt transaction, e.g. the
+	// number of pages it allocated to measure its write amplification.
+	// Pages are allocated when a write transaction commits, so the page
+	// counts are only complete once Commit returns.
+	Stats() bbolt.TxStats
+}
+
+var _ BoltTxn = (*txn)(nil)
+
 func (d *Datastore) NewTransaction(ctx context.Context, readOnly bool) (datastore.Txn, error) {
-	tx, err := d.db.Begin(!readOnly)
+	if !readOnly && d.bolt().IsReadOnly() {
+		return nil, ErrReadOnly
+	}
+	if !readOnly {
+		// the write lock is released with the transaction, like txns
+		if err := d.db.lockWrites(ctx); err != nil {
+			return nil, err
+		}
+		d.releaseReads()
+	}
+	// released once the transaction is committed or discarded
+	d.db.txns.RLock()
+	tx, err := d.bolt().Begin(!readOnly)
 	if err != nil {
+		d.unlockTxn(!readOnly)
 		return nil, err
 	}
-	bucket := tx.Bucket(d.bucket)
+	d.logger.Debug("begin transaction", "txid", tx.ID(), "writable", !readOnly)
+	bucket, err := d.openBucket(tx)
+	if err != nil {
+		tx.Rollback()
+		d.unlockTxn(!readOnly)
+		return nil, err
+	}
+
+	return &txn{ds: d, tx: tx, id: tx.ID(), ktype: d.ktype, bucket: bucket}, nil
+}
 
-	return &txn{tx: tx, ktype: d.ktype, bucket: bucket}, nil
+// unlockTxn releases the locks held by a transaction, see NewTransaction
+func (d *Datastore) unlockTxn(writable bool) {
+	d.db.txns.RUnlock()
+	if writable {
+		d.db.unlockWrites()
+	}
 }
 
 type txn struct {
+	ds     *Datastore
 	tx     *bbolt.Tx
+	id     int   // tx.ID(), which is not available once tx is closed
+	err    error // error of the last failed Discard
+	closed bool  // whether the transaction was committed or discarded
 	bucket *bbolt.Bucket
 	ktype  dskey.KeyType
 }
 
+// get returns the stored key and value of key, expired values are not
+// found
+func (b *txn) get(key dskey.Key) (k, data []byte, err error) {
+	if k, err = b.ds.storedKey(key); err != nil {
+		return nil, nil, err
+	}
+	data = b.bucket.Get(k)
+	if data == nil {
+		return nil, nil, datastore.ErrNotFound
+	}
+	if _, err := b.ds.liveMeta(data, time.Now()); err != nil {
+		return nil, nil, err
+	}
+	return k, data, nil
+}
+
 func (b *txn) Get(ctx context.Context, key dskey.Key) ([]byte, error) {
 	if key.KeyType() != b.ktype {
 		return nil, ErrKeyTypeNotMatch
 	}
 
-	data := b.bucket.Get(key.Bytes())
-	if data == nil {
-		return nil, datastore.ErrNotFound
+	k, data, err := b.get(key)
+	if err != nil {
+		return nil, err
 	}
-	return copyBytes(data), nil
+	return b.ds.decodeValue(k, data)
 }
 
 func (b *txn) Has(ctx context.Context, key dskey.Key) (exists bool, err error) {
@@ -42,11 +123,14 @@ func (b *txn) Has(ctx context.Context, key dskey.Key) (exists bool, err error) {
 		return false, ErrKeyTypeNotMatch
 	}
 
-	data := b.bucket.Get(key.Bytes())
-	if data == nil {
+	switch _, _, err := b.get(key); err {
+	case nil:
+		return true, nil
+	case datastore.ErrNotFound:
 		return false, nil
+	default:
+		return false, err
 	}
-	return true, nil
 }
 
 func (b *txn) GetSize(ctx context.Context, key dskey.Key) (int, error) {
@@ -54,40 +138,151 @@ func (b *txn) GetSize(ctx context.Context, key dskey.Key) (int, error) {
 		return -1, ErrKeyTypeNotMatch
 	}
 
-	data := b.bucket.Get(key.Bytes())
-	if data == nil {
-		return -1, datastore.ErrNotFound
+	k, data, err := b.get(key)
+	if err != nil {
+		return -1, err
 	}
-	return len(data), nil
+	return b.ds.valueSize(k, data)
 }
 
 func (b *txn) Query(ctx context.Context, q query.Query) (query.Results, error) {
 	cursor := b.bucket.Cursor()
-	return queryWithCursor(cursor, q, b.ktype, nil)
+	return b.ds.queryWithCursor(ctx, cursor, q, queryOptions{}, nil)
 }
 
 func (b *txn) Put(ctx context.Context, key dskey.Key, value []byte) error {
 	if key.KeyType() != b.ktype {
 		return ErrKeyTypeNotMatch
 	}
-	return b.bucket.Put(key.Bytes(), value)
+	k, err := b.ds.storedKey(key)
+	if err != nil {
+		return err
+	}
+	if err := b.ds.checkEntry(k, value); err != nil {
+		return err
+	}
+	if value, err = b.ds.encodeValue(k, value); err != nil {
+		return err
+	}
+	return b.ds.putStored(b.bucket, k, value)
 }
 
 func (b *txn) Delete(ctx context.Context, key dskey.Key) error {
 	if key.KeyType() != b.ktype {
 		return ErrKeyTypeNotMatch
 	}
-	return b.bucket.Delete(key.Bytes())
+	k, err := b.ds.storedKey(key)
+	if err != nil {
+		return err
+	}
+	return b.ds.deleteStored(b.bucket, k)
+}
+
+func (b *txn) ForEach(ctx context.Context, prefix dskey.Key, fn func(key dskey.Key, value []byte) (deleteIt bool, err error)) error {
+	ctx = orBackground(ctx)
+	if keyTypeMismatch(prefix, b.ktype) {
+		return ErrKeyTypeNotMatch
+	}
+	start, limit, err := b.ds.prefixBounds(prefix)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	c := b.bucket.Cursor()
+	k, v := c.First()
+	if start != nil {
+		k, v = c.Seek(start)
+	}
+	for steps := 0; k != nil && (limit == nil || bytes.Compare(k, limit) < 0); steps++ {
+		if steps%queryCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		if v == nil {
+			// nested bucket
+			k, v = c.Next()
+			continue
+		}
+		if _, err := b.ds.liveMeta(v, now); err == datastore.ErrNotFound {
+			k, v = c.Next()
+			continue
+		}
+		value, err := b.ds.decodeValue(k, v)
+		if err != nil {
+			return err
+		}
+		k = copyBytes(k)
+		deleteIt, err := fn(dskey.NewBytesKey(b.ds.userKey(k)), value)
+		if err != nil {
+			return err
+		}
+		if !deleteIt {
+			k, v = c.Next()
+			continue
+		}
+		if err := b.ds.beforeDelete(b.bucket, k, v); err != nil {
+			return err
+		}
+		if err := c.Delete(); err != nil {
+			return err
+		}
+		// Next may skip a key after Delete, seek past the deleted key instead
+		k, v = c.Seek(k)
+	}
+	return nil
 }
 
-// Commit calls the underlying bolt Commit
+func (b *txn) BoltTx() *bbolt.Tx {
+	return b.tx
+}
+
+// Commit calls the underlying bolt Commit, it returns ErrTxnClosed if the
+// transaction was already committed or discarded
 func (b *txn) Commit(ctx context.Context) error {
-	return b.tx.Commit()
+	if b.closed {
+		return ErrTxnClosed
+	}
+	// bbolt closes the transaction even when the commit fails
+	b.closed = true
+	defer b.ds.unlockTxn(b.tx.Writable())
+	if err := b.tx.Commit(); err != nil {
+		b.ds.logger.Error("commit transaction", "txid", b.id, "err", err)
+		return err
+	}
+	b.ds.logger.Debug("commit transaction", "txid", b.id)
+	return nil
 }
 
 // Discard calls the underlying bolt Rollback. It closes the transaction and ignores all previous updates.
 // Read-only transactions must be rolled back and not committed.
+// Discard cannot return the error of Rollback, it is logged as a warning
+// and kept for LastError. Discarding a transaction that is already closed,
+// e.g. deferred after a Commit, is a no-op.
 func (b *txn) Discard(ctx context.Context) {
-	b.tx.Rollback()
-	return
+	if b.closed {
+		return
+	}
+	b.closed = true
+	defer b.ds.unlockTxn(b.tx.Writable())
+	switch err := b.tx.Rollback(); err {
+	case nil:
+		b.ds.logger.Debug("rollback transaction", "txid", b.id)
+	case bbolt.ErrTxClosed:
+	default:
+		b.err = err
+		b.ds.logger.Warn("rollback transaction", "txid", b.id, "err", err)
+	}
+}
+
+func (b *txn) LastError() error {
+	return b.err
+}
+
+func (b *txn) ID() int {
+	return b.id
+}
+
+func (b *txn) Stats() bbolt.TxStats {
+	return b.tx.Stats()
 }
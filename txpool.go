@@ -0,0 +1,161 @@
+package dsbbolt
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// readTxPool keeps read transactions open to be reused by reads, see
+// WithReadTxPool
+type readTxPool struct {
+	db       *bbolt.DB
+	maxAge   time.Duration
+	maxReads int
+	idle     chan *pooledTx
+
+	mu     sync.RWMutex // held for reading while a pooled tx is in use
+	closed bool
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+type pooledTx struct {
+	tx      *bbolt.Tx
+	created time.Time
+	reads   int
+}
+
+func newReadTxPool(db *bbolt.DB, size int, maxAge time.Duration, maxReads int) *readTxPool {
+	p := &readTxPool{
+		db:       db,
+		maxAge:   maxAge,
+		maxReads: maxReads,
+		idle:     make(chan *pooledTx, size),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go p.recycle()
+	return p
+}
+
+// view runs fn in a pooled read transaction, or in a new one if none is
+// idle. Transactions older than maxAge or used maxReads times are replaced.
+func (p *readTxPool) view(fn func(tx *bbolt.Tx) error) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return p.db.View(fn)
+	}
+	var ptx *pooledTx
+	select {
+	case ptx = <-p.idle:
+		if time.Since(ptx.created) >= p.maxAge || p.maxReads > 0 && ptx.reads >= p.maxReads {
+			ptx.tx.Rollback()
+			ptx = nil
+		}
+	default:
+	}
+	if ptx == nil {
+		tx, err := p.db.Begin(false)
+		if err != nil {
+			return err
+		}
+		ptx = &pooledTx{tx: tx, created: time.Now()}
+	}
+	ptx.reads++
+	err := fn(ptx.tx)
+	select {
+	case p.idle <- ptx:
+	default:
+		ptx.tx.Rollback()
+	}
+	return err
+}
+
+// recycle rolls back the idle transactions every maxAge, so that they
+// don't hold on to pages for longer than that when reads stop
+func (p *readTxPool) recycle() {
+	defer close(p.done)
+	ticker := time.NewTicker(p.maxAge)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.drain()
+		}
+	}
+}
+
+func (p *readTxPool) drain() {
+	for {
+		select {
+		case ptx := <-p.idle:
+			ptx.tx.Rollback()
+		default:
+			return
+		}
+	}
+}
+
+// replaceDB runs open, which closes the db of the pool and returns a new
+// one, while no pooled transaction is in use, and makes the pool use the
+// new db
+func (p *readTxPool) replaceDB(open func() (*bbolt.DB, error)) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.drain()
+	db, err := open()
+	if err != nil {
+		return err
+	}
+	p.db = db
+	return nil
+}
+
+// close rolls back the pooled transactions once they are no longer in use,
+// later reads open their own transactions
+func (p *readTxPool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+	p.closed = true
+	close(p.stop)
+	<-p.done
+	p.drain()
+}
+
+// view runs fn in a read transaction, taken from the pool if there is one
+func (d *Datastore) view(fn func(tx *bbolt.Tx) error) error {
+	if d.readPool != nil {
+		return d.readPool.view(fn)
+	}
+	return d.bolt().View(fn)
+}
+
+// update runs fn in a write transaction, or returns the error of ctx if it
+// is done before the transaction can begin. Idle pooled read transactions
+// are rolled back first: bbolt waits for every open read transaction
+// before remapping a growing db file, and a write then sees its own result
+// in the reads that follow it.
+func (d *Datastore) update(ctx context.Context, fn func(tx *bbolt.Tx) error) error {
+	if err := d.db.lockWrites(ctx); err != nil {
+		return err
+	}
+	defer d.db.unlockWrites()
+	d.releaseReads()
+	return d.bolt().Update(fn)
+}
+
+// releaseReads rolls back the idle pooled read transactions, if any
+func (d *Datastore) releaseReads() {
+	if d.readPool != nil {
+		d.readPool.drain()
+	}
+}
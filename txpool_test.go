@@ -0,0 +1,103 @@
+package dsbbolt
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	dskey "github.com/daotl/go-datastore/key"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadTxPool(t *testing.T) {
+	ds, err := NewDatastoreWithOptions(filepath.Join(t.TempDir(), "bolt"),
+		WithReadTxPool(1, time.Hour, 2))
+	assert.NoError(t, err)
+	key := dskey.NewBytesKeyFromString("key")
+	assert.NoError(t, ds.Put(bg, key, []byte("v1")))
+
+	value, err := ds.Get(bg, key)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("v1"), value)
+	assert.Equal(t, 1, ds.bolt().Stats().OpenTxN)
+	// writes release the pooled transaction and are seen by later reads
+	assert.NoError(t, ds.Put(bg, key, []byte("v2")))
+	assert.Equal(t, 0, ds.bolt().Stats().OpenTxN)
+	value, err = ds.Get(bg, key)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("v2"), value)
+
+	// the pooled transaction is replaced after maxReads reads
+	txID := ds.bolt().Stats().TxN
+	for i := 0; i < 4; i++ {
+		_, err = ds.Get(bg, key)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 2, ds.bolt().Stats().TxN-txID)
+	assert.Equal(t, 1, ds.bolt().Stats().OpenTxN)
+
+	// Close must not wait for the pooled transaction
+	assert.NoError(t, ds.Close())
+}
+
+func TestReadTxPoolMaxAge(t *testing.T) {
+	ds, err := NewDatastoreWithOptions(filepath.Join(t.TempDir(), "bolt"),
+		WithReadTxPool(4, 10*time.Millisecond, 0))
+	assert.NoError(t, err)
+	defer ds.Close()
+	key := dskey.NewBytesKeyFromString("key")
+	assert.NoError(t, ds.Put(bg, key, []byte("v1")))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_, err := ds.Get(bg, key)
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.NoError(t, ds.Put(bg, key, []byte("v2")))
+	value, err := ds.Get(bg, key)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("v2"), value)
+
+	// idle transactions are rolled back in the background
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 0, ds.bolt().Stats().OpenTxN)
+}
+
+func BenchmarkGetParallel(b *testing.B) {
+	for _, bb := range []struct {
+		name string
+		opts []Option
+	}{
+		{"NoPool", nil},
+		{"Pool", []Option{WithReadTxPool(64, 100*time.Millisecond, 0)}},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			ds, err := NewDatastoreWithOptions(filepath.Join(b.TempDir(), "bolt"), bb.opts...)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer ds.Close()
+			key := dskey.NewBytesKeyFromString("key")
+			if err := ds.Put(bg, key, []byte("value")); err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := ds.Get(bg, key); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
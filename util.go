@@ -1,10 +1,21 @@
 package dsbbolt
 
 import (
+	"context"
+
 	dskey "github.com/daotl/go-datastore/key"
 	"github.com/daotl/go-datastore/query"
 )
 
+// orBackground returns ctx, or context.Background() if ctx is nil, so that
+// methods checking their context accept a nil one like the others
+func orBackground(ctx context.Context) context.Context {
+	if ctx == nil {
+		return context.Background()
+	}
+	return ctx
+}
+
 func copyBytes(src []byte) []byte {
 	dst := make([]byte, len(src))
 	copy(dst, src)
@@ -17,10 +28,15 @@ func toQueryEntry(k []byte, v []byte, KeysOnly bool) query.Entry {
 	if !KeysOnly {
 		entry.Value = copyBytes(v)
 	}
+	// v points into the mmap, taking its length reads no value page
 	entry.Size = len(v)
 	return entry
 }
 
+func toKeyValue(k, v []byte) KeyValue {
+	return KeyValue{Key: dskey.NewBytesKey(copyBytes(k)), Value: copyBytes(v)}
+}
+
 // bytesPrefix returns key range that satisfy the given prefix,
 // the bytes that equals to prefix is not included.
 // start: prefix + 0x00
@@ -0,0 +1,199 @@
+package dsbbolt
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"sync/atomic"
+
+	dskey "github.com/daotl/go-datastore/key"
+	"go.etcd.io/bbolt"
+)
+
+// watchBufferSize is the number of events buffered for a watcher before
+// the next ones are dropped
+const watchBufferSize = 128
+
+// EventOp is the kind of change an Event reports
+type EventOp int
+
+const (
+	// EventPut reports that a value was put under Event.Key
+	EventPut EventOp = iota + 1
+	// EventDelete reports that Event.Key was deleted
+	EventDelete
+	// EventDropped reports that events were dropped because the watcher
+	// did not keep up, any key of the watched prefix may have changed
+	EventDropped
+)
+
+func (op EventOp) String() string {
+	switch op {
+	case EventPut:
+		return "put"
+	case EventDelete:
+		return "delete"
+	case EventDropped:
+		return "dropped"
+	default:
+		return "unknown"
+	}
+}
+
+// Event is a change of a key delivered by Watch, Key is nil for
+// EventDropped
+type Event struct {
+	Op  EventOp
+	Key dskey.Key
+}
+
+// watcher receives the events of the stored keys of bucket, encoded by
+// bucketPathKey, from start to limit excluded, nil bounds being unbounded
+type watcher struct {
+	ch           chan Event
+	done         chan struct{} // closed when the watcher is removed
+	bucket       []byte
+	scope        []byte
+	start, limit []byte
+	dropped      bool // whether events were dropped since the last delivered
+}
+
+func (w *watcher) matches(bucket, k []byte) bool {
+	return bytes.Equal(bucket, w.bucket) &&
+		(w.start == nil || bytes.Compare(k, w.start) >= 0) &&
+		(w.limit == nil || bytes.Compare(k, w.limit) < 0)
+}
+
+// send delivers e unless the buffer is full, signaling the events dropped
+// since the last delivered one first
+func (w *watcher) send(e Event) {
+	if w.dropped {
+		select {
+		case w.ch <- Event{Op: EventDropped}:
+			w.dropped = false
+		default:
+			return
+		}
+	}
+	select {
+	case w.ch <- e:
+	default:
+		w.dropped = true
+	}
+}
+
+// watchHub holds the watchers of the datastores sharing a db
+type watchHub struct {
+	n        int32 // number of watchers, read without holding mu
+	mu       sync.Mutex
+	watchers map[*watcher]struct{}
+	closed   bool
+}
+
+func newWatchHub() *watchHub {
+	return &watchHub{watchers: make(map[*watcher]struct{})}
+}
+
+func (h *watchHub) add(w *watcher) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return false
+	}
+	h.watchers[w] = struct{}{}
+	atomic.AddInt32(&h.n, 1)
+	return true
+}
+
+func (h *watchHub) remove(w *watcher) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.watchers[w]; !ok {
+		return
+	}
+	delete(h.watchers, w)
+	atomic.AddInt32(&h.n, -1)
+	close(w.done)
+	close(w.ch)
+}
+
+// close removes every watcher, closing their channels
+func (h *watchHub) close() {
+	h.mu.Lock()
+	h.closed = true
+	watchers := make([]*watcher, 0, len(h.watchers))
+	for w := range h.watchers {
+		watchers = append(watchers, w)
+	}
+	h.mu.Unlock()
+	for _, w := range watchers {
+		h.remove(w)
+	}
+}
+
+// publish delivers the change of the stored key k of bucket, encoded by
+// bucketPathKey, to the watchers matching it
+func (h *watchHub) publish(bucket []byte, op EventOp, k []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for w := range h.watchers {
+		if w.matches(bucket, k) {
+			w.send(Event{Op: op, Key: dskey.NewBytesKey(copyBytes(k[len(w.scope):]))})
+		}
+	}
+}
+
+// notify publishes the change of the stored key k once tx commits, if
+// anyone is watching
+func (d *Datastore) notify(tx *bbolt.Tx, op EventOp, k []byte) {
+	h := d.db.watch
+	if atomic.LoadInt32(&h.n) == 0 {
+		return
+	}
+	bucket, k := bucketPathKey(d.bucket), copyBytes(k)
+	tx.OnCommit(func() {
+		h.publish(bucket, op, k)
+	})
+}
+
+// Watch returns a channel receiving the puts and deletes of the keys a
+// Query with the same prefix would return, a nil prefix watching all keys.
+// Events are published once the transaction making the change commits,
+// they only cover the writes made through d and the datastores returned
+// by its WithBucket and Scoped, and not those made through BoltTx nor the
+// keys deleted by Truncate. Events of concurrent transactions may be
+// received out of commit order.
+//
+// Up to 128 events are buffered for a slow receiver, later ones are
+// dropped and the next event received is EventDropped. The channel is
+// closed when ctx is done or the datastore is closed.
+func (d *Datastore) Watch(ctx context.Context, prefix dskey.Key) (<-chan Event, error) {
+	if keyTypeMismatch(prefix, d.ktype) {
+		return nil, ErrKeyTypeNotMatch
+	}
+	ctx = orBackground(ctx)
+	start, limit, err := d.prefixBounds(prefix)
+	if err != nil {
+		return nil, err
+	}
+	w := &watcher{
+		ch:     make(chan Event, watchBufferSize),
+		done:   make(chan struct{}),
+		bucket: bucketPathKey(d.bucket),
+		scope:  copyBytes(d.scope),
+		start:  start,
+		limit:  limit,
+	}
+	h := d.db.watch
+	if !h.add(w) {
+		return nil, bbolt.ErrDatabaseNotOpen
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			h.remove(w)
+		case <-w.done:
+		}
+	}()
+	return w.ch, nil
+}
@@ -0,0 +1,73 @@
+package dsbbolt
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	dskey "github.com/daotl/go-datastore/key"
+	"github.com/stretchr/testify/assert"
+)
+
+func receive(t *testing.T, events <-chan Event) Event {
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("no event received")
+		return Event{}
+	}
+}
+
+func TestWatch(t *testing.T) {
+	ds := newTestDatastore(t)
+	ctx, cancel := context.WithCancel(bg)
+	defer cancel()
+	events, err := ds.Watch(ctx, dskey.NewBytesKeyFromString("watched/"))
+	assert.NoError(t, err)
+
+	key := dskey.NewBytesKeyFromString("watched/foo")
+	assert.NoError(t, ds.Put(bg, dskey.NewBytesKeyFromString("other"), []byte("bar")))
+	assert.NoError(t, ds.Put(bg, key, []byte("bar")))
+	assert.Equal(t, Event{Op: EventPut, Key: key}, receive(t, events))
+	assert.NoError(t, ds.Delete(bg, key))
+	assert.Equal(t, Event{Op: EventDelete, Key: key}, receive(t, events))
+
+	// discarded transactions publish nothing
+	txn, err := ds.NewTransaction(bg, false)
+	assert.NoError(t, err)
+	assert.NoError(t, txn.Put(bg, key, []byte("discarded")))
+	txn.Discard(bg)
+	batch, err := ds.Batch(bg)
+	assert.NoError(t, err)
+	assert.NoError(t, batch.Put(bg, key, []byte("batched")))
+	assert.NoError(t, batch.Commit(bg))
+	assert.Equal(t, Event{Op: EventPut, Key: key}, receive(t, events))
+
+	// a slow receiver is told that events were dropped
+	for i := 0; i < watchBufferSize+10; i++ {
+		assert.NoError(t, ds.Put(bg, key, []byte("bar")))
+	}
+	for i := 0; i < watchBufferSize; i++ {
+		assert.Equal(t, EventPut, receive(t, events).Op)
+	}
+	assert.NoError(t, ds.Put(bg, key, []byte("bar")))
+	assert.Equal(t, Event{Op: EventDropped}, receive(t, events))
+	assert.NoError(t, ds.Put(bg, key, []byte("bar")))
+	assert.Equal(t, Event{Op: EventPut, Key: key}, receive(t, events))
+
+	// scoped keys are seen without the scope
+	scoped, err := ds.Scoped([]byte("watched/")).Watch(ctx, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, ds.Put(bg, key, []byte("bar")))
+	assert.Equal(t, Event{Op: EventPut, Key: dskey.NewBytesKeyFromString("foo")}, receive(t, scoped))
+
+	cancel()
+	for range events {
+	}
+	assert.NoError(t, ds.Close())
+	for range scoped {
+	}
+	_, err = ds.Watch(bg, nil)
+	assert.Error(t, err)
+}